@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/open-bastion/open-bastion/internal/logger"
+	"os"
+)
+
+//auditUsage is the usage of the audit subcommand
+const auditUsage = "usage: open-bastion audit verify [-key HMAC_KEY_FILE] [-expect-head SEQ:HASH | -head-file " +
+	"AUDIT_HEAD_FILE] AUDIT_LOG_FILE"
+
+//runAudit runs the audit subcommand and returns its exit status. The verify subcommand checks the hash chain of an
+//audit log, and the MAC of its records if a key is given, then prints its head. The removal of the last records is
+//only detected against the expected head, given on the command line or read from the head file of the bastion.
+func runAudit(args []string) int {
+	if len(args) == 0 || args[0] != "verify" {
+		fmt.Fprintln(os.Stderr, auditUsage)
+		return 2
+	}
+
+	flags := flag.NewFlagSet("audit verify", flag.ContinueOnError)
+	keyFile := flags.String("key", "", "(Optional) Specifies the file of the HMAC key the records are signed with")
+	expectHead := flags.String("expect-head", "", "(Optional) Specifies the SEQ:HASH head the log must contain")
+	headFile := flags.String("head-file", "", "(Optional) Specifies the head file the log must contain the head of")
+
+	if err := flags.Parse(args[1:]); err != nil || flags.NArg() != 1 || (*expectHead != "" && *headFile != "") {
+		fmt.Fprintln(os.Stderr, auditUsage)
+		return 2
+	}
+
+	var key []byte
+	var expected logger.AuditHead
+	var err error
+
+	if *keyFile != "" {
+		if key, err = logger.ReadAuditKey(*keyFile); err != nil {
+			fmt.Fprintln(os.Stderr, "Error : "+err.Error())
+			return 1
+		}
+	}
+
+	if *expectHead != "" {
+		expected, err = logger.ParseAuditHead(*expectHead)
+	} else if *headFile != "" {
+		expected, err = logger.ReadAuditHead(*headFile, key)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error : "+err.Error())
+		return 1
+	}
+
+	file, err := os.Open(flags.Arg(0))
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error : "+err.Error())
+		return 1
+	}
+
+	defer file.Close()
+
+	head, err := logger.VerifyAuditLogHead(file, key, expected)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error : %v is not intact after %v valid records : %v\n", flags.Arg(0), head.Records,
+			err)
+		return 1
+	}
+
+	if expected.Records == 0 {
+		fmt.Printf("%v valid records, head %v, the removal of the last records is not checked without an expected "+
+			"head\n", head.Records, head)
+	} else {
+		fmt.Printf("%v valid records, head %v, contains the expected head %v\n", head.Records, head, expected)
+	}
+
+	return 0
+}
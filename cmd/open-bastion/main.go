@@ -6,10 +6,17 @@ import (
 	"github.com/open-bastion/open-bastion/internal/auth"
 	"github.com/open-bastion/open-bastion/internal/config"
 	"github.com/open-bastion/open-bastion/internal/datastore"
+	"github.com/open-bastion/open-bastion/internal/egress"
 	"github.com/open-bastion/open-bastion/internal/ingress"
 	"github.com/open-bastion/open-bastion/internal/logger"
+	"github.com/open-bastion/open-bastion/internal/obclient"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/ssh"
+	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
 )
 
 func main() {
@@ -17,8 +24,15 @@ func main() {
 
 	flag.Parse()
 
+	if flag.Arg(0) == "audit" {
+		os.Exit(runAudit(flag.Args()[1:]))
+	}
+
+	if flag.Arg(0) == "migrate-keys" {
+		os.Exit(runMigrateKeys(*configPath, flag.Args()[1:]))
+	}
+
 	var sshServer ingress.Ingress
-	var authInfo auth.Auth
 
 	logger.InitDefaultLogger()
 
@@ -28,10 +42,26 @@ func main() {
 		log.Fatal().Err(err).Msgf("error parsing configuration file")
 	}
 
-	logger.InitLogger(bastionConfig)
+	//The keys of the shared file must be imported first, ignoring them would lock every user out
+	if bastionConfig.AuthorizedKeysFile != "" {
+		log.Fatal().Msgf(legacyKeysError, bastionConfig.AuthorizedKeysFile)
+	}
+
+	err = logger.InitLogger(bastionConfig)
+
+	if err != nil {
+		log.Fatal().Err(err).Msgf("error initializing the logger")
+	}
 	ctx := logger.InitContextLogger(context.Background())
 	logger.Info("logger initialized")
 
+	err = logger.InitAuditLog(bastionConfig.Audit.File, bastionConfig.Audit.HeadFile,
+		bastionConfig.Audit.HMACKeyFile)
+
+	if err != nil {
+		logger.FatalfWithErr(err, "error")
+	}
+
 	dataStore, err := datastore.InitStore(bastionConfig)
 
 	if err != nil {
@@ -39,18 +69,38 @@ func main() {
 	}
 	logger.Infof("data store initialized, using: %v", dataStore.GetType())
 
-	err = authInfo.ReadAuthorizedKeysFile(bastionConfig.AuthorizedKeysFile)
+	authInfo, err := auth.NewAuth(dataStore, bastionConfig)
+
+	if err != nil {
+		logger.FatalfWithErr(err, "error")
+	}
+
+	err = sshServer.ConfigSSHServer(authInfo, bastionConfig.PrivateKeyFile)
 
 	if err != nil {
-		logger.FatalfWithErr(err, "error reading authorized_keys file")
+		logger.FatalfWithErr(err, "error")
 	}
-	logger.Info("authorized_keys parsed")
 
-	err = sshServer.ConfigSSHServer(authInfo.AuthorizedKeys, bastionConfig.PrivateKeyFile, dataStore)
+	sshServer.HostKeyChecker = egress.NewHostKeyChecker(dataStore, bastionConfig)
+	sshServer.Sessions = obclient.NewSessionRegistry()
+	sshServer.Throttle = ingress.NewThrottle(bastionConfig)
+	sshServer.Sources, err = auth.NewSourceFilter(bastionConfig.Sources.SourceRules)
 
 	if err != nil {
 		logger.FatalfWithErr(err, "error")
 	}
+	logger.Infof("backend host keys verified with policy: %v", bastionConfig.HostKeyPolicy)
+
+	sshServer.EgressCA, err = egress.NewCertificateAuthority(bastionConfig)
+
+	if err != nil {
+		logger.FatalfWithErr(err, "error")
+	}
+
+	if sshServer.EgressCA != nil {
+		logger.Infof("backend connections use certificates of the egress CA %v",
+			ssh.FingerprintSHA256(sshServer.EgressCA.PublicKey()))
+	}
 
 	err = sshServer.ConfigTCPListener(bastionConfig.ListenAddress + ":" + strconv.Itoa(bastionConfig.ListenPort))
 
@@ -59,5 +109,110 @@ func main() {
 	}
 	logger.Info("server configured")
 
+	stopped := make(chan struct{})
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP, syscall.SIGUSR1)
+
+	//SIGHUP reloads the configuration and SIGUSR1 reopens the log file. The first other signal stops the listener and
+	//drains the sessions, a second one closes them right away.
+	go func(current config.Config) {
+		var sig os.Signal
+
+	signalLoop:
+		for sig = range signals {
+			switch sig {
+			case syscall.SIGHUP:
+				current = reloadConfig(*configPath, current, &sshServer)
+			case syscall.SIGUSR1:
+				reopenLogFile()
+			default:
+				break signalLoop
+			}
+		}
+
+		logger.Infof("received %v, shutting down", sig)
+
+		drainPeriod := time.Duration(0)
+
+		if current.DrainPeriod != config.NoDrainPeriod {
+			drainPeriod = time.Duration(current.DrainPeriod) * time.Second
+		}
+
+		drainCtx, cancel := context.WithTimeout(context.Background(), drainPeriod)
+
+		go func() {
+			for {
+				select {
+				case sig := <-signals:
+					if sig == syscall.SIGUSR1 {
+						reopenLogFile()
+					}
+
+					if sig == syscall.SIGHUP || sig == syscall.SIGUSR1 {
+						continue
+					}
+
+					logger.Warnf("received %v, closing the remaining sessions", sig)
+					cancel()
+
+					return
+				case <-drainCtx.Done():
+					return
+				}
+			}
+		}()
+
+		if err := sshServer.Shutdown(drainCtx); err != nil {
+			logger.WarnWithErr(err, "sessions still active at the end of the drain period")
+		}
+
+		cancel()
+		close(stopped)
+	}(bastionConfig)
+
 	sshServer.ListenAndServe(ctx, dataStore, bastionConfig)
+
+	<-stopped
+	logger.Info("bastion stopped")
+
+	if err := logger.CloseAuditLog(); err != nil {
+		logger.ErrorWithErr(err, "error closing the audit log")
+	}
+
+	if err := logger.CloseLogOutputs(); err != nil {
+		log.Error().Err(err).Msg("error closing the log outputs")
+	}
+}
+
+//reopenLogFile reopens the log file after an external rotation
+func reopenLogFile() {
+	if err := logger.ReopenLogFile(); err != nil {
+		log.Error().Err(err).Msg("error reopening the log file")
+		return
+	}
+
+	logger.Info("log file reopened")
+}
+
+//reloadConfig parses the configuration file again and applies it to the new connections. The current configuration
+//is kept and returned if the new one is invalid.
+func reloadConfig(configPath string, current config.Config, sshServer *ingress.Ingress) config.Config {
+	logger.Info("reloading the configuration")
+
+	c, err := config.ParseConfig(configPath)
+
+	if err != nil {
+		logger.WarnWithErr(err, "invalid configuration, keeping the current one")
+		return current
+	}
+
+	if err := sshServer.Reload(c); err != nil {
+		logger.WarnWithErr(err, "invalid configuration, keeping the current one")
+		return current
+	}
+
+	logger.SetLevel(c.Level())
+	logger.Info("configuration reloaded")
+
+	return c
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/open-bastion/open-bastion/internal/config"
+	"github.com/open-bastion/open-bastion/internal/datastore"
+	"github.com/open-bastion/open-bastion/internal/logger"
+	"os"
+)
+
+//migrateKeysUsage is the usage of the migrate-keys subcommand
+const migrateKeysUsage = "usage: open-bastion [-config-file FILE] migrate-keys [-user USERNAME]"
+
+//legacyKeysError is the error of a bastion configured with the authorized keys file of the previous versions
+const legacyKeysError = "AuthorizedKeysFile %v is no longer read, the authorized keys are stored per user in the " +
+	"data store. Import them with 'open-bastion [-config-file FILE] migrate-keys [-user USERNAME]', then remove " +
+	"AuthorizedKeysFile from the configuration"
+
+//runMigrateKeys runs the migrate-keys subcommand and returns its exit status. It imports the keys of the configured
+//AuthorizedKeysFile into the data store, each key is allowed for the user named by its comment or for the -user one.
+func runMigrateKeys(configPath string, args []string) int {
+	flags := flag.NewFlagSet("migrate-keys", flag.ContinueOnError)
+	defaultUser := flags.String("user", "", "(Optional) Specifies the user of the keys whose comment names no user")
+
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, migrateKeysUsage)
+		return 2
+	}
+
+	logger.InitDefaultLogger()
+
+	bastionConfig, err := config.ParseConfig(configPath)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error : "+err.Error())
+		return 1
+	}
+
+	if bastionConfig.AuthorizedKeysFile == "" {
+		fmt.Fprintln(os.Stderr, "Error : no AuthorizedKeysFile to import in the configuration")
+		return 1
+	}
+
+	dataStore, err := datastore.InitStore(bastionConfig)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error : "+err.Error())
+		return 1
+	}
+
+	imported, err := datastore.ImportAuthorizedKeysFile(dataStore, bastionConfig.AuthorizedKeysFile, *defaultUser)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error : %v keys imported from %v : %v\n", imported, bastionConfig.AuthorizedKeysFile,
+			err)
+		return 1
+	}
+
+	fmt.Printf("%v keys imported from %v, remove AuthorizedKeysFile from the configuration\n", imported,
+		bastionConfig.AuthorizedKeysFile)
+
+	return 0
+}
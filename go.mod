@@ -3,7 +3,10 @@ module github.com/open-bastion/open-bastion
 go 1.14
 
 require (
+	github.com/mattn/go-sqlite3 v1.14.22
 	github.com/rs/zerolog v1.18.0
 	github.com/stretchr/testify v1.6.0
-	golang.org/x/crypto v0.0.0-20200510223506-06a226fb4e37
+	golang.org/x/crypto v0.31.0
+	gopkg.in/yaml.v3 v3.0.1
+	rsc.io/qr v0.2.0
 )
@@ -1,44 +1,390 @@
 package auth
 
 import (
-	"bufio"
+	"bytes"
+	"errors"
+	"github.com/open-bastion/open-bastion/internal/config"
+	"github.com/open-bastion/open-bastion/internal/datastore"
 	"github.com/open-bastion/open-bastion/internal/logger"
 	"golang.org/x/crypto/ssh"
-	"os"
+	"sync"
+	"time"
 )
 
+//totpPrompt is the keyboard-interactive question of the second factor
+const totpPrompt = "Verification code: "
+
 // Auth contains the information to authenticate the clients
 type Auth struct {
-	AuthorizedKeys map[string]bool
+	DataStore    datastore.DataStore
+	Policy       Policy
+	PasswordHash string
+	Lockout      *Lockout
+	MFAIssuer    string
+	UserCAKeys   []ssh.PublicKey
+	Revocations  *RevocationList
+	UserSources  map[string]SourceFilter
+
+	//totpSteps contains the last TOTP time step accepted for each user, a code is never accepted twice. It is shared
+	//with the reloaded Auth.
+	totpSteps *totpSteps
+
+	//offered contains the key offered by each connection during its handshake. It is shared with the reloaded Auth,
+	//the connections authenticating during a reload keep their key.
+	offered *offeredKeys
 }
 
-// ReadAuthorizedKeysFile reads the specified file and populate a map of the parsed keys
-func (ak *Auth) ReadAuthorizedKeysFile(path string) (err error) {
-	ak.AuthorizedKeys = make(map[string]bool)
-	f, err := os.Open(path)
+//totpSteps contains the last TOTP time step accepted for each user
+type totpSteps struct {
+	mu    sync.Mutex
+	steps map[string]int64
+}
+
+// NewAuth returns an Auth authenticating the users of the data store with the configured methods. The trusted user
+// CA keys and the revocation list are read from the configured files.
+func NewAuth(dataStore datastore.DataStore, c config.Config) (*Auth, error) {
+	a := &Auth{
+		DataStore:    dataStore,
+		Policy:       NewPolicy(c),
+		PasswordHash: c.Password.Hash,
+		Lockout: NewLockout(c.Password.MaxFailures,
+			time.Duration(c.Password.LockoutDuration)*time.Second),
+		MFAIssuer: c.MFA.Issuer,
+		totpSteps: &totpSteps{steps: make(map[string]int64)},
+		offered:   &offeredKeys{keys: make(map[string]string)},
+	}
+
+	var err error
+
+	if c.TrustedUserCAKeys != "" {
+		a.UserCAKeys, err = LoadUserCAKeys(c.TrustedUserCAKeys)
+
+		if err != nil {
+			return nil, errors.New("could not load the trusted user CA keys : " + err.Error())
+		}
+	}
+
+	a.UserSources = make(map[string]SourceFilter, len(c.Sources.Users))
+
+	for username, rules := range c.Sources.Users {
+		if a.UserSources[username], err = NewSourceFilter(rules); err != nil {
+			return nil, errors.New("invalid source filter of user " + username + " : " + err.Error())
+		}
+	}
+
+	if c.RevokedKeys != "" {
+		a.Revocations, err = LoadRevocationList(c.RevokedKeys)
+
+		if err != nil {
+			return nil, errors.New("could not load the revoked keys : " + err.Error())
+		}
+	}
+
+	return a, nil
+}
+
+// Reload returns the Auth of a new configuration. The failed password attempts, the used TOTP codes and the keys
+// offered by the connections are kept, a reload does not unlock the accounts nor allow replaying a code.
+func (a *Auth) Reload(c config.Config) (*Auth, error) {
+	n, err := NewAuth(a.DataStore, c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	n.Lockout = a.Lockout.withLimits(n.Lockout.MaxFailures, n.Lockout.Duration)
+	n.totpSteps = a.totpSteps
+	n.offered = a.offered
+
+	return n, nil
+}
+
+// ConfigureServer installs the callbacks of the authentication methods allowed by the policy, the other methods are
+// not offered to the clients
+func (a *Auth) ConfigureServer(sshConfig *ssh.ServerConfig) {
+	sshConfig.PublicKeyCallback = nil
+	sshConfig.PasswordCallback = nil
+	sshConfig.AuthLogCallback = a.AuthLogCallback
+
+	if a.Policy.PermitKeyLogin {
+		sshConfig.PublicKeyCallback = a.PublicKeyCallback
+	}
+
+	if a.Policy.PermitPasswordLogin {
+		sshConfig.PasswordCallback = a.PasswordCallback
+	}
+}
+
+//checkSource returns an error if the source filter of the user refuses the address of the client
+func (a *Auth) checkSource(c ssh.ConnMetadata) error {
+	if f, ok := a.UserSources[c.User()]; ok && !f.AllowsAddr(c.RemoteAddr()) {
+		return errors.New("user " + c.User() + " is not allowed to connect from " + c.RemoteAddr().String())
+	}
+
+	return nil
+}
+
+//checkUser returns an error if the user cannot log in
+func (a *Auth) checkUser(username string) error {
+	if err := a.Policy.CheckUser(username); err != nil {
+		return err
+	}
+
+	s, err := a.DataStore.GetUserStatus(username)
 
 	if err != nil {
 		return err
 	}
 
-	line := 1
-	//TODO check if there is a line length limitation for the scanner
-	scanner := bufio.NewScanner(f)
+	if s == datastore.Inactive {
+		return errors.New("account deactivated")
+	}
+
+	if s != datastore.Active {
+		return errors.New("invalid user")
+	}
+
+	return nil
+}
+
+// PublicKeyCallback accepts a public key only if it is one of the authorized keys of the active user the client
+// tries to log in as and if its from and expiry-time options allow it, or a certificate of a trusted user CA for
+// this user. Revoked keys and certificates are refused.
+func (a *Auth) PublicKeyCallback(c ssh.ConnMetadata, pubKey ssh.PublicKey) (*ssh.Permissions, error) {
+	if !a.Policy.PermitKeyLogin {
+		return nil, errors.New("public key login is not allowed")
+	}
+
+	if cert, ok := pubKey.(*ssh.Certificate); ok {
+		a.offered.set(c.RemoteAddr(), ssh.FingerprintSHA256(cert.Key))
+	} else {
+		a.offered.set(c.RemoteAddr(), ssh.FingerprintSHA256(pubKey))
+	}
+
+	if err := a.checkUser(c.User()); err != nil {
+		return nil, err
+	}
+
+	if err := a.checkSource(c); err != nil {
+		return nil, err
+	}
+
+	if cert, ok := pubKey.(*ssh.Certificate); ok {
+		perms, err := a.certificatePermissions(c, cert)
 
-	for scanner.Scan() {
-		pubKey, _, _, _, err := ssh.ParseAuthorizedKey(scanner.Bytes())
 		if err != nil {
-			logger.WarnfWithErr(err, "error reading key line %v", line)
-		} else {
-			ak.AuthorizedKeys[string(pubKey.Marshal())] = true
+			return nil, err
 		}
 
-		line++
+		return a.secondFactor(c.User(), perms)
+	}
+
+	if a.Revocations.IsKeyRevoked(pubKey) {
+		return nil, errors.New("revoked public key " + ssh.FingerprintSHA256(pubKey))
+	}
+
+	keys, err := a.DataStore.GetUserAuthorizedKeys(c.User())
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, k := range keys {
+		if !bytes.Equal(k.Key.Marshal(), pubKey.Marshal()) {
+			continue
+		}
+
+		options, err := ParseKeyOptions(k.Options)
+
+		if err != nil {
+			return nil, errors.New("invalid options for key " + k.Fingerprint() + " : " + err.Error())
+		}
+
+		if err := options.CheckSource(c.RemoteAddr()); err != nil {
+			return nil, err
+		}
+
+		if err := options.CheckExpiry(time.Now()); err != nil {
+			return nil, err
+		}
+
+		//The options enforced during the session are carried by the permissions
+		extensions := options.Extensions()
+		// Record the public key used for authentication.
+		extensions["pubkey-fp"] = ssh.FingerprintSHA256(pubKey)
+
+		return a.secondFactor(c.User(), &ssh.Permissions{Extensions: extensions})
+	}
+
+	return nil, errors.New("unknown public key for user " + c.User())
+}
+
+// PasswordCallback accepts the password of an active user who has one, unless too many failed attempts locked the
+// account
+func (a *Auth) PasswordCallback(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	if !a.Policy.PermitPasswordLogin {
+		return nil, errors.New("password login is not allowed")
+	}
+
+	//A second factor following the password is not attributed to a key offered before
+	a.offered.forget(c.RemoteAddr())
+
+	if err := a.checkUser(c.User()); err != nil {
+		return nil, err
 	}
 
-	if err := scanner.Err(); err != nil {
+	if err := a.checkSource(c); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	if a.Lockout.Locked(c.User(), now) {
+		return nil, errors.New("account " + c.User() + " locked after too many failed password attempts")
+	}
+
+	hash, err := a.DataStore.GetUserPasswordHash(c.User())
+
+	if err != nil {
+		return nil, err
+	}
+
+	if hash == "" {
+		return nil, errors.New("password login is not enabled for user " + c.User())
+	}
+
+	ok, err := VerifyPassword(hash, string(password))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		if a.Lockout.Fail(c.User(), now) {
+			logger.Warnf("account %v locked after %v failed password attempts", c.User(), a.Lockout.MaxFailures)
+		}
+
+		return nil, errors.New("invalid password for user " + c.User())
+	}
+
+	a.Lockout.Reset(c.User())
+
+	return a.secondFactor(c.User(), &ssh.Permissions{})
+}
+
+//secondFactor returns the permissions of a successful first authentication step, or a partial success requiring the
+//TOTP code of the users who enrolled
+func (a *Auth) secondFactor(username string, perms *ssh.Permissions) (*ssh.Permissions, error) {
+	secret, err := a.DataStore.GetUserTOTPSecret(username)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if secret == "" {
+		return perms, nil
+	}
+
+	totpCallback := func(c ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+		if err := a.checkTOTP(c.User(), secret, challenge); err != nil {
+			return nil, err
+		}
+
+		return perms, nil
+	}
+
+	return nil, &ssh.PartialSuccessError{Next: ssh.ServerAuthCallbacks{KeyboardInteractiveCallback: totpCallback}}
+}
+
+//checkTOTP asks the client for a verification code and returns an error unless it is a valid code not used yet.
+//The failures count toward the lockout of the account.
+func (a *Auth) checkTOTP(username string, secret string, challenge ssh.KeyboardInteractiveChallenge) error {
+	now := time.Now()
+
+	if a.Lockout.Locked(username, now) {
+		return errors.New("account " + username + " locked after too many failed attempts")
+	}
+
+	answers, err := challenge(username, "", []string{totpPrompt}, []bool{false})
+
+	if err != nil {
 		return err
 	}
 
+	if len(answers) != 1 {
+		return errors.New("invalid keyboard-interactive answers")
+	}
+
+	step, err := ValidateTOTP(secret, answers[0], now)
+
+	if err != nil {
+		return err
+	}
+
+	if step < 0 || !a.useTOTPStep(username, step) {
+		if a.Lockout.Fail(username, now) {
+			logger.Warnf("account %v locked after %v failed attempts", username, a.Lockout.MaxFailures)
+		}
+
+		return errors.New("invalid verification code for user " + username)
+	}
+
+	a.Lockout.Reset(username)
+
+	return nil
+}
+
+//useTOTPStep records the time step of an accepted code and returns false if it, or a later one, was already used
+func (a *Auth) useTOTPStep(username string, step int64) bool {
+	a.totpSteps.mu.Lock()
+	defer a.totpSteps.mu.Unlock()
+
+	if last, ok := a.totpSteps.steps[username]; ok && step <= last {
+		return false
+	}
+
+	a.totpSteps.steps[username] = step
+
+	return true
+}
+
+// EnrollTOTP stores the secret of the user if the code is valid, the user must then give a code at each login
+func (a *Auth) EnrollTOTP(username string, secret string, code string) error {
+	step, err := ValidateTOTP(secret, code, time.Now())
+
+	if err != nil {
+		return err
+	}
+
+	if step < 0 {
+		return errors.New("invalid verification code")
+	}
+
+	if err := a.DataStore.SetUserTOTPSecret(username, secret); err != nil {
+		return err
+	}
+
+	//The confirmation code cannot be reused to log in
+	a.useTOTPStep(username, step)
+
+	return nil
+}
+
+// SetUserPassword hashes the password with the configured algorithm, stores it and unlocks the account
+func (a *Auth) SetUserPassword(username string, password string) error {
+	hash, err := HashPassword(password, a.PasswordHash)
+
+	if err != nil {
+		return err
+	}
+
+	err = a.DataStore.SetUserPasswordHash(username, hash)
+
+	if err != nil {
+		return err
+	}
+
+	a.Lockout.Reset(username)
+
 	return nil
 }
@@ -0,0 +1,349 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/open-bastion/open-bastion/internal/config"
+	"github.com/open-bastion/open-bastion/internal/datastore"
+	"github.com/open-bastion/open-bastion/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+//testConnMetadata is the connection metadata of a client logging in as user
+type testConnMetadata struct {
+	user string
+}
+
+func (c testConnMetadata) User() string          { return c.user }
+func (c testConnMetadata) SessionID() []byte     { return nil }
+func (c testConnMetadata) ClientVersion() []byte { return nil }
+func (c testConnMetadata) ServerVersion() []byte { return nil }
+func (c testConnMetadata) RemoteAddr() net.Addr  { return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)} }
+func (c testConnMetadata) LocalAddr() net.Addr   { return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)} }
+
+//newTestKey returns a new ed25519 authorized key
+func newTestKey(t *testing.T) datastore.AuthorizedKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	key, err := ssh.NewPublicKey(pub)
+	assert.Nil(t, err)
+
+	return datastore.AuthorizedKey{Key: key}
+}
+
+func TestAuth_PublicKeyCallback(t *testing.T) {
+	s := datastore.NewMemoryStore(datastore.DefaultKeyPolicy())
+
+	aliceKey := newTestKey(t)
+	bobKey := newTestKey(t)
+	unknownKey := newTestKey(t)
+
+	restrictedKey := newTestKey(t)
+	restrictedKey.Options = []string{`from="10.0.0.0/8"`}
+
+	expiredKey := newTestKey(t)
+	expiredKey.Options = []string{`expiry-time="20200101"`}
+
+	forcedKey := newTestKey(t)
+	forcedKey.Options = []string{`command="bastion whoami"`, "restrict"}
+
+	assert.Nil(t, s.AddUser("alice", "", 0))
+	assert.Nil(t, s.AddUser("bob", "", 0))
+	assert.Nil(t, s.AddUser("root", "", 0))
+	assert.Nil(t, s.AddUserAuthorizedKey("alice", aliceKey))
+	assert.Nil(t, s.AddUserAuthorizedKey("root", aliceKey))
+	assert.Nil(t, s.AddUserAuthorizedKey("bob", bobKey))
+
+	for _, k := range []datastore.AuthorizedKey{restrictedKey, expiredKey, forcedKey} {
+		assert.Nil(t, s.AddUserAuthorizedKey("alice", k))
+	}
+	assert.Nil(t, s.SetUserStatus("bob", datastore.Inactive))
+
+	a := Auth{DataStore: s, Policy: Policy{PermitKeyLogin: true}}
+
+	tests := []struct {
+		name     string
+		username string
+		key      datastore.AuthorizedKey
+		wantErr  bool
+	}{
+		{name: "test ok", username: "alice", key: aliceKey, wantErr: false},
+		{name: "test key of another user", username: "alice", key: bobKey, wantErr: true},
+		{name: "test unknown key", username: "alice", key: unknownKey, wantErr: true},
+		{name: "test inactive user", username: "bob", key: bobKey, wantErr: true},
+		{name: "test unknown user", username: "charlie", key: aliceKey, wantErr: true},
+		{name: "test root", username: "root", key: aliceKey, wantErr: true},
+		{name: "test from mismatch", username: "alice", key: restrictedKey, wantErr: true},
+		{name: "test expired", username: "alice", key: expiredKey, wantErr: true},
+		{name: "test forced command", username: "alice", key: forcedKey, wantErr: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			perms, err := a.PublicKeyCallback(testConnMetadata{user: tt.username}, tt.key.Key)
+			assert.Equal(t, tt.wantErr, err != nil)
+
+			if !tt.wantErr {
+				assert.Equal(t, tt.key.Fingerprint(), perms.Extensions["pubkey-fp"])
+
+				command, _ := ForceCommand(perms)
+				assert.Equal(t, tt.key.Fingerprint() == forcedKey.Fingerprint(), command == "bastion whoami")
+			}
+		})
+	}
+}
+
+func TestAuth_PasswordCallback(t *testing.T) {
+	s := datastore.NewMemoryStore(datastore.DefaultKeyPolicy())
+
+	assert.Nil(t, s.AddUser("alice", "", 0))
+	assert.Nil(t, s.AddUser("bob", "", 0))
+
+	a, err := NewAuth(s, config.Config{
+		PermitPasswordLogin: true,
+		Password:            config.Password{Hash: config.PasswordHashBcrypt, MaxFailures: 2, LockoutDuration: 60},
+	})
+	assert.Nil(t, err)
+
+	assert.Nil(t, a.SetUserPassword("alice", "correct horse battery"))
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantErr  bool
+	}{
+		{name: "test ok", username: "alice", password: "correct horse battery", wantErr: false},
+		{name: "test no password", username: "bob", password: "correct horse battery", wantErr: true},
+		{name: "test unknown user", username: "charlie", password: "correct horse battery", wantErr: true},
+		{name: "test invalid password", username: "alice", password: "wrong", wantErr: true},
+		{name: "test invalid password locks", username: "alice", password: "wrong", wantErr: true},
+		{name: "test locked", username: "alice", password: "correct horse battery", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := a.PasswordCallback(testConnMetadata{user: tt.username}, []byte(tt.password))
+
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+
+	//Setting a new password unlocks the account
+	assert.Nil(t, a.SetUserPassword("alice", "another correct horse"))
+
+	_, err = a.PasswordCallback(testConnMetadata{user: "alice"}, []byte("another correct horse"))
+	assert.Nil(t, err)
+}
+
+//answerTOTP returns a keyboard-interactive challenge answering the code
+func answerTOTP(code string) ssh.KeyboardInteractiveChallenge {
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		return []string{code}, nil
+	}
+}
+
+func TestAuth_SecondFactor(t *testing.T) {
+	s := datastore.NewMemoryStore(datastore.DefaultKeyPolicy())
+
+	aliceKey := newTestKey(t)
+	bobKey := newTestKey(t)
+
+	assert.Nil(t, s.AddUser("alice", "", 0))
+	assert.Nil(t, s.AddUser("bob", "", 0))
+	assert.Nil(t, s.AddUserAuthorizedKey("alice", aliceKey))
+	assert.Nil(t, s.AddUserAuthorizedKey("bob", bobKey))
+
+	a, err := NewAuth(s, config.Config{PermitKeyLogin: true, Password: config.Password{MaxFailures: 3}})
+	assert.Nil(t, err)
+
+	secret, err := NewTOTPSecret()
+	assert.Nil(t, err)
+
+	code, err := TOTPCode(secret, time.Now())
+	assert.Nil(t, err)
+
+	assert.NotNil(t, a.EnrollTOTP("alice", secret, "000000x"))
+	assert.Nil(t, a.EnrollTOTP("alice", secret, code))
+
+	//Users without a second factor log in with their key only
+	perms, err := a.PublicKeyCallback(testConnMetadata{user: "bob"}, bobKey.Key)
+	assert.Nil(t, err)
+	assert.NotNil(t, perms)
+
+	_, err = a.PublicKeyCallback(testConnMetadata{user: "alice"}, aliceKey.Key)
+
+	var partial *ssh.PartialSuccessError
+	assert.True(t, errors.As(err, &partial))
+
+	callback := partial.Next.KeyboardInteractiveCallback
+	assert.NotNil(t, callback)
+
+	//The code used to enroll cannot be replayed
+	_, err = callback(testConnMetadata{user: "alice"}, answerTOTP(code))
+	assert.NotNil(t, err)
+
+	next, err := TOTPCode(secret, time.Now().Add(totpPeriod*time.Second))
+	assert.Nil(t, err)
+
+	perms, err = callback(testConnMetadata{user: "alice"}, answerTOTP(next))
+	assert.Nil(t, err)
+	assert.Equal(t, ssh.FingerprintSHA256(aliceKey.Key), perms.Extensions["pubkey-fp"])
+
+	_, err = callback(testConnMetadata{user: "alice"}, answerTOTP(next))
+	assert.NotNil(t, err)
+}
+
+func TestAuth_ConfigureServer(t *testing.T) {
+	tests := []struct {
+		name         string
+		policy       Policy
+		wantKey      bool
+		wantPassword bool
+	}{
+		{name: "test key only", policy: Policy{PermitKeyLogin: true}, wantKey: true, wantPassword: false},
+		{name: "test password only", policy: Policy{PermitPasswordLogin: true}, wantKey: false, wantPassword: true},
+		{name: "test both", policy: Policy{PermitKeyLogin: true, PermitPasswordLogin: true}, wantKey: true,
+			wantPassword: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sshConfig := &ssh.ServerConfig{}
+			a := Auth{Policy: tt.policy}
+
+			a.ConfigureServer(sshConfig)
+
+			assert.Equal(t, tt.wantKey, sshConfig.PublicKeyCallback != nil)
+			assert.Equal(t, tt.wantPassword, sshConfig.PasswordCallback != nil)
+		})
+	}
+}
+
+func TestPolicy_CheckBackendUser(t *testing.T) {
+	assert.Nil(t, Policy{}.CheckBackendUser("alice"))
+	assert.NotNil(t, Policy{}.CheckBackendUser(RootUser))
+	assert.Nil(t, Policy{PermitRootLogin: true}.CheckBackendUser(RootUser))
+
+	//Root never logs in the bastion itself
+	assert.NotNil(t, Policy{PermitRootLogin: true}.CheckUser(RootUser))
+	assert.Nil(t, Policy{}.CheckUser("alice"))
+}
+
+//readAuditFields returns the fields of the records of the audit log at path
+func readAuditFields(t *testing.T, path string) []map[string]string {
+	content, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+
+	var fields []map[string]string
+
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		if line == "" {
+			continue
+		}
+
+		var r logger.AuditRecord
+		assert.Nil(t, json.Unmarshal([]byte(line), &r))
+		assert.Equal(t, "alice", r.User)
+		assert.Equal(t, "authentication "+r.Fields["result"], r.Message)
+
+		fields = append(fields, r.Fields)
+	}
+
+	return fields
+}
+
+func TestAuth_AuthLogCallback(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "open-bastion-testing")
+
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	t.Cleanup(func() { _ = os.RemoveAll(tempDir) })
+
+	s := datastore.NewMemoryStore(datastore.DefaultKeyPolicy())
+	aliceKey := newTestKey(t)
+	otherKey := newTestKey(t)
+
+	assert.Nil(t, s.AddUser("alice", "", 0))
+	assert.Nil(t, s.AddUserAuthorizedKey("alice", aliceKey))
+
+	a, err := NewAuth(s, config.Config{PermitKeyLogin: true})
+	assert.Nil(t, err)
+
+	partial := &ssh.PartialSuccessError{}
+
+	tests := []struct {
+		name            string
+		offer           ssh.PublicKey
+		reload          bool
+		method          string
+		err             error
+		wantResult      string
+		wantReason      string
+		wantFingerprint string
+	}{
+		{name: "test none not logged", method: "none", err: errors.New("no auth passed yet")},
+		{name: "test public key failure", offer: otherKey.Key, method: "publickey",
+			err: errors.New("unknown public key"), wantResult: logger.AuthFailure, wantReason: "unknown public key",
+			wantFingerprint: otherKey.Fingerprint()},
+		{name: "test public key success", offer: aliceKey.Key, method: "publickey", err: nil,
+			wantResult: logger.AuthSuccess, wantFingerprint: aliceKey.Fingerprint()},
+		{name: "test password failure without key", method: "password", err: errors.New("invalid password"),
+			wantResult: logger.AuthFailure, wantReason: "invalid password"},
+		{name: "test public key partial success", offer: aliceKey.Key, method: "publickey", err: partial,
+			wantResult: logger.AuthPartial, wantReason: "second factor required",
+			wantFingerprint: aliceKey.Fingerprint()},
+		{name: "test second factor failure", method: "keyboard-interactive", err: errors.New("invalid code"),
+			wantResult: logger.AuthFailure, wantReason: "invalid code", wantFingerprint: aliceKey.Fingerprint()},
+		{name: "test second factor success after reload", reload: true, method: "keyboard-interactive", err: nil,
+			wantResult: logger.AuthSuccess, wantFingerprint: aliceKey.Fingerprint()},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(tempDir, strconv.Itoa(i)+".log")
+			assert.Nil(t, logger.InitAuditLog(path, "", ""))
+
+			if tt.offer != nil {
+				_, _ = a.PublicKeyCallback(testConnMetadata{user: "alice"}, tt.offer)
+			}
+
+			if tt.reload {
+				a, err = a.Reload(config.Config{PermitKeyLogin: true})
+				assert.Nil(t, err)
+			}
+
+			a.AuthLogCallback(testConnMetadata{user: "alice"}, tt.method, tt.err)
+			assert.Nil(t, logger.CloseAuditLog())
+
+			fields := readAuditFields(t, path)
+
+			if tt.wantResult == "" {
+				assert.Len(t, fields, 0)
+				return
+			}
+
+			assert.Equal(t, []map[string]string{{
+				"method":               tt.method,
+				"publicKeyFingerprint": tt.wantFingerprint,
+				"result":               tt.wantResult,
+				"reason":               tt.wantReason,
+			}}, fields)
+		})
+	}
+
+	//The key is forgotten once the handshake is over
+	a.EndHandshake(testConnMetadata{}.RemoteAddr())
+	assert.Equal(t, "", a.offered.get(testConnMetadata{}.RemoteAddr()))
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"github.com/open-bastion/open-bastion/internal/logger"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"sync"
+)
+
+//offeredKeys contains the fingerprint of the last public key offered by each connection during the handshake,
+//ssh.ServerConfig.AuthLogCallback is not given the key. A nil offeredKeys records nothing.
+type offeredKeys struct {
+	mu   sync.Mutex
+	keys map[string]string
+}
+
+//set records the key offered by the connection
+func (o *offeredKeys) set(addr net.Addr, fingerprint string) {
+	if o == nil {
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.keys[addr.String()] = fingerprint
+}
+
+//get returns the last key offered by the connection
+func (o *offeredKeys) get(addr net.Addr) string {
+	if o == nil {
+		return ""
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.keys[addr.String()]
+}
+
+//forget removes the key offered by the connection
+func (o *offeredKeys) forget(addr net.Addr) {
+	if o == nil {
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	delete(o.keys, addr.String())
+}
+
+// AuthLogCallback logs every authentication attempt with its method, the key fingerprint, the result and the reason
+// of the failure. The none method the clients start with is not an attempt and is not logged.
+func (a *Auth) AuthLogCallback(c ssh.ConnMetadata, method string, err error) {
+	if method == "none" {
+		return
+	}
+
+	e := logger.AuthEvent{
+		User:   c.User(),
+		IP:     c.RemoteAddr().String(),
+		Method: method,
+		Result: logger.AuthSuccess,
+	}
+
+	if method == "publickey" || method == "keyboard-interactive" {
+		e.Fingerprint = a.offered.get(c.RemoteAddr())
+	}
+
+	if _, ok := err.(*ssh.PartialSuccessError); ok {
+		e.Result = logger.AuthPartial
+		e.Reason = "second factor required"
+	} else if err != nil {
+		e.Result = logger.AuthFailure
+		e.Reason = err.Error()
+	}
+
+	logger.LogAuthEvent(e)
+}
+
+// EndHandshake forgets the authentication state of the connection once its handshake is over
+func (a *Auth) EndHandshake(addr net.Addr) {
+	a.offered.forget(addr)
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"net"
+	"strings"
+)
+
+// Critical options and extensions of the OpenSSH user certificates, see the CERTIFICATES section of ssh-keygen(1)
+const (
+	ForceCommandCriticalOption  = "force-command"
+	SourceAddressCriticalOption = "source-address"
+	PermitPortForwardingCertExt = "permit-port-forwarding"
+)
+
+// LoadUserCAKeys reads the trusted user CA public keys at path, in the authorized_keys format
+func LoadUserCAKeys(path string) ([]ssh.PublicKey, error) {
+	content, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []ssh.PublicKey
+
+	for len(bytes.TrimSpace(content)) > 0 {
+		var key ssh.PublicKey
+
+		key, _, _, content, err = ssh.ParseAuthorizedKey(content)
+
+		if err != nil {
+			return nil, errors.New("invalid user CA key : " + err.Error())
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+//isUserAuthority returns whether the key is one of the trusted user CA keys
+func (a *Auth) isUserAuthority(auth ssh.PublicKey) bool {
+	for _, k := range a.UserCAKeys {
+		if bytes.Equal(k.Marshal(), auth.Marshal()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//certificatePermissions checks the certificate the client authenticates with. The certificate must be signed by a
+//trusted user CA, valid now, not revoked, and list the username as principal. Its force-command critical option
+//and its lack of the permit-port-forwarding extension are enforced during the session like the authorized key
+//options.
+func (a *Auth) certificatePermissions(c ssh.ConnMetadata, cert *ssh.Certificate) (*ssh.Permissions, error) {
+	//ssh.CertChecker accepts any principal when the list is empty, sshd refuses such certificates
+	if len(cert.ValidPrincipals) == 0 {
+		return nil, errors.New("certificate " + cert.KeyId + " has no principal")
+	}
+
+	checker := ssh.CertChecker{
+		IsUserAuthority:          a.isUserAuthority,
+		IsRevoked:                a.Revocations.IsCertRevoked,
+		SupportedCriticalOptions: []string{ForceCommandCriticalOption, SourceAddressCriticalOption},
+	}
+
+	perms, err := checker.Authenticate(c, cert)
+
+	if err != nil {
+		return nil, errors.New("invalid certificate " + cert.KeyId + " : " + err.Error())
+	}
+
+	//The server only checks the source-address of the permissions it is given, these ones are built here
+	if sources, ok := perms.CriticalOptions[SourceAddressCriticalOption]; ok {
+		if err := checkSourceAddress(c.RemoteAddr(), sources); err != nil {
+			return nil, errors.New("invalid certificate " + cert.KeyId + " : " + err.Error())
+		}
+	}
+
+	extensions := make(map[string]string)
+
+	if command, ok := perms.CriticalOptions[ForceCommandCriticalOption]; ok {
+		extensions[ForceCommandExtension] = command
+	}
+
+	if _, ok := cert.Extensions[PermitPortForwardingCertExt]; !ok {
+		extensions[NoPortForwardingExtension] = ""
+	}
+
+	// Record the public key used for authentication.
+	extensions["pubkey-fp"] = ssh.FingerprintSHA256(cert.Key)
+
+	return &ssh.Permissions{Extensions: extensions}, nil
+}
+
+//checkSourceAddress returns an error unless the address matches one of the comma separated addresses or CIDR
+//blocks of a source-address critical option
+func checkSourceAddress(addr net.Addr, sources string) error {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+
+	if !ok {
+		return errors.New("source-address requires a TCP connection")
+	}
+
+	for _, source := range strings.Split(sources, ",") {
+		source = strings.TrimSpace(source)
+
+		if ip := net.ParseIP(source); ip != nil {
+			if ip.Equal(tcpAddr.IP) {
+				return nil
+			}
+
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(source)
+
+		if err != nil {
+			return errors.New("invalid source-address " + source)
+		}
+
+		if network.Contains(tcpAddr.IP) {
+			return nil
+		}
+	}
+
+	return errors.New("source address " + tcpAddr.IP.String() + " not allowed")
+}
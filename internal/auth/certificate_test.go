@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/open-bastion/open-bastion/internal/datastore"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+//newTestCA returns a new ed25519 CA signer
+func newTestCA(t *testing.T) ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	signer, err := ssh.NewSignerFromKey(priv)
+	assert.Nil(t, err)
+
+	return signer
+}
+
+//newTestCert returns a user certificate of a new key, valid for an hour, signed by the CA after edit is applied
+func newTestCert(t *testing.T, ca ssh.Signer, edit func(cert *ssh.Certificate)) *ssh.Certificate {
+	cert := &ssh.Certificate{
+		Key:             newTestKey(t).Key,
+		Serial:          42,
+		CertType:        ssh.UserCert,
+		KeyId:           "alice@example.com",
+		ValidPrincipals: []string{"alice"},
+		ValidAfter:      uint64(time.Now().Add(-time.Minute).Unix()),
+		ValidBefore:     uint64(time.Now().Add(time.Hour).Unix()),
+		Permissions: ssh.Permissions{
+			Extensions: map[string]string{PermitPortForwardingCertExt: ""},
+		},
+	}
+
+	if edit != nil {
+		edit(cert)
+	}
+
+	assert.Nil(t, cert.SignCert(rand.Reader, ca))
+
+	return cert
+}
+
+func TestAuth_PublicKeyCallback_Certificate(t *testing.T) {
+	s := datastore.NewMemoryStore(datastore.DefaultKeyPolicy())
+
+	assert.Nil(t, s.AddUser("alice", "", 0))
+	assert.Nil(t, s.AddUser("bob", "", 0))
+
+	ca := newTestCA(t)
+	untrustedCA := newTestCA(t)
+
+	revoked, err := ParseRevocationList([]byte("# revoked certificates\nserial: 100-200\nid: mallory@example.com\n"))
+	assert.Nil(t, err)
+
+	a := Auth{
+		DataStore:   s,
+		Policy:      Policy{PermitKeyLogin: true},
+		UserCAKeys:  []ssh.PublicKey{ca.PublicKey()},
+		Revocations: revoked,
+	}
+
+	tests := []struct {
+		name          string
+		username      string
+		cert          *ssh.Certificate
+		wantErr       bool
+		wantForced    string
+		wantNoForward bool
+	}{
+		{name: "test ok", username: "alice", cert: newTestCert(t, ca, nil), wantErr: false},
+		{name: "test wrong principal", username: "bob", cert: newTestCert(t, ca, nil), wantErr: true},
+		{name: "test no principal", username: "alice", cert: newTestCert(t, ca, func(c *ssh.Certificate) {
+			c.ValidPrincipals = nil
+		}), wantErr: true},
+		{name: "test untrusted CA", username: "alice", cert: newTestCert(t, untrustedCA, nil), wantErr: true},
+		{name: "test expired", username: "alice", cert: newTestCert(t, ca, func(c *ssh.Certificate) {
+			c.ValidBefore = uint64(time.Now().Add(-time.Minute).Unix())
+		}), wantErr: true},
+		{name: "test not yet valid", username: "alice", cert: newTestCert(t, ca, func(c *ssh.Certificate) {
+			c.ValidAfter = uint64(time.Now().Add(time.Hour).Unix())
+		}), wantErr: true},
+		{name: "test host certificate", username: "alice", cert: newTestCert(t, ca, func(c *ssh.Certificate) {
+			c.CertType = ssh.HostCert
+		}), wantErr: true},
+		{name: "test revoked serial", username: "alice", cert: newTestCert(t, ca, func(c *ssh.Certificate) {
+			c.Serial = 150
+		}), wantErr: true},
+		{name: "test revoked key id", username: "alice", cert: newTestCert(t, ca, func(c *ssh.Certificate) {
+			c.KeyId = "mallory@example.com"
+		}), wantErr: true},
+		{name: "test source address", username: "alice", cert: newTestCert(t, ca, func(c *ssh.Certificate) {
+			c.CriticalOptions = map[string]string{SourceAddressCriticalOption: "10.0.0.0/8"}
+		}), wantErr: true},
+		{name: "test source address ok", username: "alice", cert: newTestCert(t, ca, func(c *ssh.Certificate) {
+			c.CriticalOptions = map[string]string{SourceAddressCriticalOption: "127.0.0.1/32,10.0.0.0/8"}
+		}), wantErr: false},
+		{name: "test unsupported option", username: "alice", cert: newTestCert(t, ca, func(c *ssh.Certificate) {
+			c.CriticalOptions = map[string]string{"verify-required": ""}
+		}), wantErr: true},
+		{name: "test forced command", username: "alice", cert: newTestCert(t, ca, func(c *ssh.Certificate) {
+			c.CriticalOptions = map[string]string{ForceCommandCriticalOption: "bastion whoami"}
+			c.Extensions = nil
+		}), wantErr: false, wantForced: "bastion whoami", wantNoForward: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			perms, err := a.PublicKeyCallback(testConnMetadata{user: tt.username}, tt.cert)
+
+			assert.Equal(t, tt.wantErr, err != nil)
+
+			if err != nil {
+				return
+			}
+
+			command, forced := ForceCommand(perms)
+			assert.Equal(t, tt.wantForced != "", forced)
+			assert.Equal(t, tt.wantForced, command)
+			assert.Equal(t, tt.wantNoForward, PermitsOpen(perms, "backend", 22) != nil)
+			assert.Equal(t, ssh.FingerprintSHA256(tt.cert.Key), perms.Extensions["pubkey-fp"])
+		})
+	}
+
+	//Revoking the CA key revokes every certificate it signed
+	a.Revocations, err = ParseRevocationList([]byte("key: " + string(ssh.MarshalAuthorizedKey(ca.PublicKey()))))
+	assert.Nil(t, err)
+
+	_, err = a.PublicKeyCallback(testConnMetadata{user: "alice"}, newTestCert(t, ca, nil))
+	assert.NotNil(t, err)
+}
+
+func TestParseRevocationList(t *testing.T) {
+	key := newTestKey(t).Key
+	other := newTestKey(t).Key
+
+	tests := []struct {
+		name        string
+		content     string
+		wantErr     bool
+		wantRevoked bool
+	}{
+		{name: "test key", content: "key: " + string(ssh.MarshalAuthorizedKey(key)), wantErr: false,
+			wantRevoked: true},
+		{name: "test sha256", content: "sha256: " + string(ssh.MarshalAuthorizedKey(key)), wantErr: false,
+			wantRevoked: true},
+		{name: "test hash", content: "hash: " + ssh.FingerprintSHA256(key), wantErr: false, wantRevoked: true},
+		{name: "test other key", content: "key: " + string(ssh.MarshalAuthorizedKey(other)), wantErr: false,
+			wantRevoked: false},
+		{name: "test comments", content: "\n# nothing revoked\n", wantErr: false, wantRevoked: false},
+		{name: "test invalid key", content: "key: ssh-ed25519 AAAA", wantErr: true},
+		{name: "test invalid serial", content: "serial: 10-1", wantErr: true},
+		{name: "test md5 hash", content: "hash: MD5:00:11", wantErr: true},
+		{name: "test unknown entry", content: "name: alice", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := ParseRevocationList([]byte(tt.content))
+
+			assert.Equal(t, tt.wantErr, err != nil)
+
+			if err == nil {
+				assert.Equal(t, tt.wantRevoked, r.IsKeyRevoked(key))
+			}
+		})
+	}
+
+	var none *RevocationList
+	assert.False(t, none.IsKeyRevoked(key))
+	assert.False(t, none.IsCertRevoked(&ssh.Certificate{Key: key}))
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// Lockout counts the consecutive failed password attempts of each user and locks the accounts reaching MaxFailures
+// for Duration. It is safe for concurrent use, the counters are lost when the bastion restarts.
+type Lockout struct {
+	MaxFailures int
+	Duration    time.Duration
+
+	mu    sync.Mutex
+	users map[string]*lockoutState
+}
+
+//lockoutState contains the failed attempts of a user
+type lockoutState struct {
+	failures    int
+	lockedUntil time.Time
+}
+
+// NewLockout returns a Lockout without failed attempts
+func NewLockout(maxFailures int, duration time.Duration) *Lockout {
+	return &Lockout{
+		MaxFailures: maxFailures,
+		Duration:    duration,
+		users:       make(map[string]*lockoutState),
+	}
+}
+
+// Locked returns whether the account of the user is locked
+func (l *Lockout) Locked(username string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s, ok := l.users[username]
+
+	return ok && now.Before(s.lockedUntil)
+}
+
+// Fail records a failed attempt and returns whether the account is now locked
+func (l *Lockout) Fail(username string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s, ok := l.users[username]
+
+	//The attempts before an expired lockout are forgotten
+	if !ok || (!s.lockedUntil.IsZero() && !now.Before(s.lockedUntil)) {
+		s = &lockoutState{}
+		l.users[username] = s
+	}
+
+	s.failures++
+
+	if s.failures >= l.MaxFailures {
+		s.lockedUntil = now.Add(l.Duration)
+		return true
+	}
+
+	return false
+}
+
+// Reset forgets the failed attempts of the user, after a successful login or a password change
+func (l *Lockout) Reset(username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.users, username)
+}
+
+//withLimits returns l if its limits are unchanged, otherwise a Lockout of the new limits starting with the failed
+//attempts of l
+func (l *Lockout) withLimits(maxFailures int, duration time.Duration) *Lockout {
+	if l.MaxFailures == maxFailures && l.Duration == duration {
+		return l
+	}
+
+	n := NewLockout(maxFailures, duration)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for username, s := range l.users {
+		copied := *s
+		n.users[username] = &copied
+	}
+
+	return n
+}
@@ -0,0 +1,321 @@
+package auth
+
+import (
+	"errors"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Extensions of the ssh.Permissions carrying the authorized key options enforced during the session
+const (
+	ForceCommandExtension     = "force-command"
+	NoPortForwardingExtension = "no-port-forwarding"
+	PermitOpenExtension       = "permitopen"
+)
+
+// KeyOptions contains the options of an authorized key, see the AUTHORIZED_KEYS FILE FORMAT section of sshd(8).
+// The backend connections are port forwarding from the bastion point of view, they are denied by no-port-forwarding
+// and restrict. A permitopen option lifts restrict and allows the listed backends only.
+type KeyOptions struct {
+	From             []string
+	ExpiryTime       time.Time
+	Command          string
+	NoPortForwarding bool
+	PermitOpen       []string
+}
+
+//supportedKeyOptions maps the supported options to whether they take a value. The no-agent-forwarding, no-pty,
+//no-user-rc and no-x11-forwarding options are accepted but have no effect, the bastion does not provide these features.
+var supportedKeyOptions = map[string]bool{
+	"from":                true,
+	"expiry-time":         true,
+	"command":             true,
+	"permitopen":          true,
+	"no-port-forwarding":  false,
+	"port-forwarding":     false,
+	"restrict":            false,
+	"no-agent-forwarding": false,
+	"no-pty":              false,
+	"no-user-rc":          false,
+	"no-x11-forwarding":   false,
+}
+
+// ParseKeyOptions parses the options of an authorized key. Unknown options are refused rather than ignored, a key
+// must never be less restricted than its owner expects.
+func ParseKeyOptions(options []string) (KeyOptions, error) {
+	var o KeyOptions
+
+	//noPortForwarding is whether the port forwarding is denied by no-port-forwarding rather than by restrict
+	noPortForwarding := false
+
+	for _, option := range options {
+		name, value, hasValue := option, "", false
+
+		if i := strings.IndexByte(option, '='); i >= 0 {
+			var err error
+
+			name = option[:i]
+			hasValue = true
+			value, err = unquoteOption(option[i+1:])
+
+			if err != nil {
+				return KeyOptions{}, errors.New("invalid value for option " + name + " : " + err.Error())
+			}
+		}
+
+		name = strings.ToLower(name)
+
+		withValue, supported := supportedKeyOptions[name]
+
+		if !supported {
+			return KeyOptions{}, errors.New("unsupported option " + name)
+		}
+
+		if hasValue != withValue {
+			return KeyOptions{}, errors.New("invalid option " + option)
+		}
+
+		switch name {
+		case "from":
+			o.From = append(o.From, strings.Split(value, ",")...)
+		case "expiry-time":
+			t, err := parseExpiryTime(value)
+
+			if err != nil {
+				return KeyOptions{}, err
+			}
+
+			if o.ExpiryTime.IsZero() || t.Before(o.ExpiryTime) {
+				o.ExpiryTime = t
+			}
+		case "command":
+			o.Command = value
+		case "permitopen":
+			if _, _, err := net.SplitHostPort(value); err != nil {
+				return KeyOptions{}, errors.New("invalid permitopen value " + value)
+			}
+
+			o.PermitOpen = append(o.PermitOpen, value)
+		case "no-port-forwarding":
+			o.NoPortForwarding = true
+			noPortForwarding = true
+		case "restrict":
+			o.NoPortForwarding = true
+		case "port-forwarding":
+			//As in sshd, the options are applied in order, port-forwarding lifts a previous restrict
+			o.NoPortForwarding = false
+			noPortForwarding = false
+		}
+	}
+
+	//permitopen overrides restrict, a restricted key still reaches the backends it lists
+	if len(o.PermitOpen) > 0 && !noPortForwarding {
+		o.NoPortForwarding = false
+	}
+
+	return o, nil
+}
+
+//unquoteOption returns the value of a double quoted option, inner double quotes are escaped with a backslash
+func unquoteOption(s string) (string, error) {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return "", errors.New("the value must be double quoted")
+	}
+
+	return strings.ReplaceAll(s[1:len(s)-1], `\"`, `"`), nil
+}
+
+//parseExpiryTime parses a YYYYMMDD[HHMM[SS]] time, in UTC with a Z suffix and in the local time zone otherwise
+func parseExpiryTime(s string) (time.Time, error) {
+	loc := time.Local
+
+	if strings.HasSuffix(s, "Z") || strings.HasSuffix(s, "z") {
+		loc = time.UTC
+		s = s[:len(s)-1]
+	}
+
+	layouts := map[int]string{8: "20060102", 12: "200601021504", 14: "20060102150405"}
+	layout, ok := layouts[len(s)]
+
+	if !ok {
+		return time.Time{}, errors.New("invalid expiry-time " + s)
+	}
+
+	t, err := time.ParseInLocation(layout, s, loc)
+
+	if err != nil {
+		return time.Time{}, errors.New("invalid expiry-time " + s)
+	}
+
+	return t, nil
+}
+
+// CheckSource returns an error if the from option does not allow the client address
+func (o KeyOptions) CheckSource(addr net.Addr) error {
+	if len(o.From) == 0 {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+
+	if err != nil {
+		host = addr.String()
+	}
+
+	ip := net.ParseIP(host)
+	allowed := false
+
+	for _, pattern := range o.From {
+		negated := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+
+		if !matchAddress(pattern, host, ip) {
+			continue
+		}
+
+		if negated {
+			return errors.New("key not allowed from " + host)
+		}
+
+		allowed = true
+	}
+
+	if !allowed {
+		return errors.New("key not allowed from " + host)
+	}
+
+	return nil
+}
+
+//matchAddress returns whether the pattern, a CIDR block or a wildcard pattern, matches the address
+func matchAddress(pattern string, host string, ip net.IP) bool {
+	if strings.Contains(pattern, "/") {
+		_, block, err := net.ParseCIDR(pattern)
+
+		return err == nil && ip != nil && block.Contains(ip)
+	}
+
+	return matchWildcard(pattern, host)
+}
+
+//matchWildcard matches s against a pattern where * matches any sequence of characters and ? any single character
+func matchWildcard(pattern string, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if matchWildcard(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+		}
+
+		pattern = pattern[1:]
+		s = s[1:]
+	}
+
+	return len(s) == 0
+}
+
+// CheckExpiry returns an error if the key is expired
+func (o KeyOptions) CheckExpiry(now time.Time) error {
+	if !o.ExpiryTime.IsZero() && now.After(o.ExpiryTime) {
+		return errors.New("key expired on " + o.ExpiryTime.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// Extensions returns the options enforced during the session, to be stored in the ssh.Permissions
+func (o KeyOptions) Extensions() map[string]string {
+	ext := make(map[string]string)
+
+	if o.Command != "" {
+		ext[ForceCommandExtension] = o.Command
+	}
+
+	if o.NoPortForwarding {
+		ext[NoPortForwardingExtension] = ""
+	}
+
+	if len(o.PermitOpen) > 0 {
+		ext[PermitOpenExtension] = strings.Join(o.PermitOpen, ",")
+	}
+
+	return ext
+}
+
+// ForceCommand returns the command forced by the key the client authenticated with, if any
+func ForceCommand(perms *ssh.Permissions) (string, bool) {
+	if perms == nil {
+		return "", false
+	}
+
+	command, ok := perms.Extensions[ForceCommandExtension]
+
+	return command, ok
+}
+
+// Restricted returns whether the key the client authenticated with is restricted by a forced command or by its port
+// forwarding options. Such a key was issued for some backends or for a command only.
+func Restricted(perms *ssh.Permissions) bool {
+	if perms == nil {
+		return false
+	}
+
+	for _, ext := range []string{ForceCommandExtension, NoPortForwardingExtension, PermitOpenExtension} {
+		if _, ok := perms.Extensions[ext]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PermitsOpen returns an error if the key the client authenticated with does not allow a connection to the backend
+func PermitsOpen(perms *ssh.Permissions, host string, port int) error {
+	if perms == nil {
+		return nil
+	}
+
+	if _, ok := perms.Extensions[NoPortForwardingExtension]; ok {
+		return errors.New("backend connections are not allowed with this key")
+	}
+
+	permitOpen, ok := perms.Extensions[PermitOpenExtension]
+
+	if !ok {
+		return nil
+	}
+
+	for _, p := range strings.Split(permitOpen, ",") {
+		permittedHost, permittedPort, err := net.SplitHostPort(p)
+
+		if err != nil {
+			continue
+		}
+
+		if !matchWildcard(permittedHost, host) {
+			continue
+		}
+
+		if permittedPort == "*" || permittedPort == strconv.Itoa(port) {
+			return nil
+		}
+	}
+
+	return errors.New("backend " + net.JoinHostPort(host, strconv.Itoa(port)) + " is not allowed with this key")
+}
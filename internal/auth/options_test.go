@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParseKeyOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []string
+		want    KeyOptions
+		wantErr bool
+	}{
+		{name: "test none", options: nil, want: KeyOptions{}, wantErr: false},
+		{name: "test from", options: []string{`from="10.0.0.0/8,!10.0.0.1"`},
+			want: KeyOptions{From: []string{"10.0.0.0/8", "!10.0.0.1"}}, wantErr: false},
+		{name: "test command", options: []string{`command="ssh deploy@10.0.0.5 \"quoted\""`},
+			want: KeyOptions{Command: `ssh deploy@10.0.0.5 "quoted"`}, wantErr: false},
+		{name: "test expiry-time utc", options: []string{`expiry-time="202001021504Z"`},
+			want: KeyOptions{ExpiryTime: time.Date(2020, 1, 2, 15, 4, 0, 0, time.UTC)}, wantErr: false},
+		{name: "test restrict", options: []string{"restrict"}, want: KeyOptions{NoPortForwarding: true},
+			wantErr: false},
+		{name: "test restrict with permitopen", options: []string{"restrict", `permitopen="db:5432"`},
+			want: KeyOptions{PermitOpen: []string{"db:5432"}}, wantErr: false},
+		{name: "test no-port-forwarding with permitopen", options: []string{"no-port-forwarding",
+			`permitopen="db:5432"`}, want: KeyOptions{NoPortForwarding: true, PermitOpen: []string{"db:5432"}},
+			wantErr: false},
+		{name: "test restrict lifted", options: []string{"restrict", "port-forwarding", `permitopen="*:22"`},
+			want: KeyOptions{PermitOpen: []string{"*:22"}}, wantErr: false},
+		{name: "test ignored", options: []string{"no-pty", "No-Agent-Forwarding"}, want: KeyOptions{},
+			wantErr: false},
+		{name: "test unsupported", options: []string{`environment="A=B"`}, wantErr: true},
+		{name: "test missing value", options: []string{"from"}, wantErr: true},
+		{name: "test unexpected value", options: []string{`restrict="yes"`}, wantErr: true},
+		{name: "test unquoted value", options: []string{"command=ls"}, wantErr: true},
+		{name: "test invalid expiry-time", options: []string{`expiry-time="2020"`}, wantErr: true},
+		{name: "test invalid permitopen", options: []string{`permitopen="db"`}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseKeyOptions(tt.options)
+
+			assert.Equal(t, tt.wantErr, err != nil)
+
+			if !tt.wantErr {
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestKeyOptions_CheckSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		from    []string
+		ip      string
+		wantErr bool
+	}{
+		{name: "test no restriction", from: nil, ip: "192.168.1.1", wantErr: false},
+		{name: "test cidr", from: []string{"10.0.0.0/8"}, ip: "10.1.2.3", wantErr: false},
+		{name: "test cidr mismatch", from: []string{"10.0.0.0/8"}, ip: "192.168.1.1", wantErr: true},
+		{name: "test wildcard", from: []string{"192.168.1.*"}, ip: "192.168.1.42", wantErr: false},
+		{name: "test single character", from: []string{"192.168.1.?"}, ip: "192.168.1.42", wantErr: true},
+		{name: "test negated", from: []string{"10.0.0.0/8", "!10.0.0.1"}, ip: "10.0.0.1", wantErr: true},
+		{name: "test negated other", from: []string{"10.0.0.0/8", "!10.0.0.1"}, ip: "10.0.0.2", wantErr: false},
+		{name: "test ipv6", from: []string{"2001:db8::/32"}, ip: "2001:db8::1", wantErr: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := &net.TCPAddr{IP: net.ParseIP(tt.ip), Port: 40000}
+			err := KeyOptions{From: tt.from}.CheckSource(addr)
+
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+func TestKeyOptions_CheckExpiry(t *testing.T) {
+	now := time.Now()
+
+	assert.Nil(t, KeyOptions{}.CheckExpiry(now))
+	assert.Nil(t, KeyOptions{ExpiryTime: now.Add(time.Hour)}.CheckExpiry(now))
+	assert.NotNil(t, KeyOptions{ExpiryTime: now.Add(-time.Hour)}.CheckExpiry(now))
+}
+
+func TestPermitsOpen(t *testing.T) {
+	tests := []struct {
+		name    string
+		options KeyOptions
+		host    string
+		port    int
+		wantErr bool
+	}{
+		{name: "test no restriction", options: KeyOptions{}, host: "10.0.0.1", port: 22, wantErr: false},
+		{name: "test no port forwarding", options: KeyOptions{NoPortForwarding: true}, host: "10.0.0.1", port: 22,
+			wantErr: true},
+		{name: "test permitted", options: KeyOptions{PermitOpen: []string{"10.0.0.1:22"}}, host: "10.0.0.1",
+			port: 22, wantErr: false},
+		{name: "test other port", options: KeyOptions{PermitOpen: []string{"10.0.0.1:22"}}, host: "10.0.0.1",
+			port: 2222, wantErr: true},
+		{name: "test any port", options: KeyOptions{PermitOpen: []string{"10.0.0.1:*"}}, host: "10.0.0.1",
+			port: 2222, wantErr: false},
+		{name: "test wildcard host", options: KeyOptions{PermitOpen: []string{"*.ci.example.com:22"}},
+			host: "runner.ci.example.com", port: 22, wantErr: false},
+		{name: "test other host", options: KeyOptions{PermitOpen: []string{"10.0.0.1:22", "10.0.0.2:22"}},
+			host: "10.0.0.3", port: 22, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := PermitsOpen(&ssh.Permissions{Extensions: tt.options.Extensions()}, tt.host, tt.port)
+
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+func TestPermitsOpen_Restrict(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []string
+		host    string
+		wantErr bool
+	}{
+		{name: "test restrict", options: []string{"restrict"}, host: "db", wantErr: true},
+		{name: "test restrict with permitopen", options: []string{"restrict", `permitopen="db:5432"`}, host: "db",
+			wantErr: false},
+		{name: "test restrict with other permitopen", options: []string{"restrict", `permitopen="db:5432"`},
+			host: "web", wantErr: true},
+		{name: "test no-port-forwarding with permitopen", options: []string{"no-port-forwarding",
+			`permitopen="db:5432"`}, host: "db", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options, err := ParseKeyOptions(tt.options)
+			assert.Nil(t, err)
+
+			err = PermitsOpen(&ssh.Permissions{Extensions: options.Extensions()}, tt.host, 5432)
+
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+func TestRestricted(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []string
+		want    bool
+	}{
+		{name: "test unrestricted", options: nil, want: false},
+		{name: "test restrict", options: []string{"restrict"}, want: true},
+		{name: "test permitopen", options: []string{`permitopen="db:22"`}, want: true},
+		{name: "test forced command", options: []string{`command="bastion whoami"`}, want: true},
+		{name: "test restrict lifted", options: []string{"restrict", "port-forwarding"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options, err := ParseKeyOptions(tt.options)
+			assert.Nil(t, err)
+
+			assert.Equal(t, tt.want, Restricted(&ssh.Permissions{Extensions: options.Extensions()}))
+		})
+	}
+
+	assert.False(t, Restricted(nil))
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/open-bastion/open-bastion/internal/config"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+// MinPasswordLength is the minimum length of the user passwords
+const MinPasswordLength = 12
+
+//Parameters of the new argon2id hashes, the second recommended option of RFC 9106
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+)
+
+// HashPassword returns the hash of the password with the algorithm (argon2id or bcrypt) in the PHC string format
+func HashPassword(password string, algorithm string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", fmt.Errorf("the password must contain at least %v characters", MinPasswordLength)
+	}
+
+	switch algorithm {
+	case config.PasswordHashArgon2id:
+		salt := make([]byte, argon2SaltLen)
+
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+
+		key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argon2Memory, argon2Time,
+			argon2Threads, base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	case config.PasswordHashBcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+
+		return string(hash), err
+	}
+
+	return "", errors.New("unknown password hash algorithm " + algorithm)
+}
+
+// VerifyPassword returns whether the password matches the hash, the algorithm is read from the hash
+func VerifyPassword(hash string, password string) (bool, error) {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		return verifyArgon2id(hash, password)
+	}
+
+	if strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$") {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, nil
+		}
+
+		return err == nil, err
+	}
+
+	return false, errors.New("unknown password hash format")
+}
+
+//verifyArgon2id compares the password with an argon2id hash in the PHC string format
+func verifyArgon2id(hash string, password string) (bool, error) {
+	invalid := errors.New("invalid argon2id hash")
+	parts := strings.Split(hash, "$")
+
+	if len(parts) != 6 {
+		return false, invalid
+	}
+
+	var version int
+	var memory, time uint32
+	var threads uint8
+
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, invalid
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, invalid
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+
+	if err != nil {
+		return false, invalid
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+
+	if err != nil || len(key) == 0 {
+		return false, invalid
+	}
+
+	candidate := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+
+	return subtle.ConstantTimeCompare(key, candidate) == 1, nil
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/open-bastion/open-bastion/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashPassword(t *testing.T) {
+	tests := []struct {
+		name      string
+		password  string
+		algorithm string
+		prefix    string
+		wantErr   bool
+	}{
+		{name: "test argon2id", password: "correct horse battery", algorithm: config.PasswordHashArgon2id,
+			prefix: "$argon2id$v=19$", wantErr: false},
+		{name: "test bcrypt", password: "correct horse battery", algorithm: config.PasswordHashBcrypt,
+			prefix: "$2a$", wantErr: false},
+		{name: "test too short", password: "short", algorithm: config.PasswordHashArgon2id, wantErr: true},
+		{name: "test unknown algorithm", password: "correct horse battery", algorithm: "md5", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, err := HashPassword(tt.password, tt.algorithm)
+			assert.Equal(t, tt.wantErr, err != nil)
+
+			if tt.wantErr {
+				return
+			}
+
+			assert.Contains(t, hash, tt.prefix)
+
+			ok, err := VerifyPassword(hash, tt.password)
+			assert.Nil(t, err)
+			assert.True(t, ok)
+
+			ok, err = VerifyPassword(hash, tt.password+"!")
+			assert.Nil(t, err)
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestVerifyPassword_InvalidHash(t *testing.T) {
+	for _, hash := range []string{"", "plain", "$argon2id$v=19$m=65536,t=3,p=4$salt", "$argon2id$v=1$m=1,t=1,p=1$AA$AA",
+		"$argon2id$v=19$m=65536,t=3,p=4$!!$AA"} {
+		ok, err := VerifyPassword(hash, "correct horse battery")
+
+		assert.False(t, ok)
+		assert.NotNil(t, err, hash)
+	}
+}
+
+func TestLockout(t *testing.T) {
+	now := time.Now()
+	l := NewLockout(3, time.Minute)
+
+	assert.False(t, l.Fail("alice", now))
+	assert.False(t, l.Fail("alice", now))
+	assert.False(t, l.Locked("alice", now))
+
+	//A successful login forgets the failed attempts
+	l.Reset("alice")
+
+	assert.False(t, l.Fail("alice", now))
+	assert.False(t, l.Fail("alice", now))
+	assert.True(t, l.Fail("alice", now))
+	assert.True(t, l.Locked("alice", now))
+	assert.False(t, l.Locked("bob", now))
+
+	//The lockout expires, the counter starts again
+	later := now.Add(2 * time.Minute)
+
+	assert.False(t, l.Locked("alice", later))
+	assert.False(t, l.Fail("alice", later))
+	assert.False(t, l.Locked("alice", later))
+}
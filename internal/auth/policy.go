@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"errors"
+	"github.com/open-bastion/open-bastion/internal/config"
+)
+
+// RootUser is the superuser account, it never logs in the bastion
+const RootUser = "root"
+
+// Policy contains the authentication methods and the accounts allowed by the configuration. PermitRootLogin only
+// applies to the backends, root can never log in the bastion itself.
+type Policy struct {
+	PermitKeyLogin      bool
+	PermitPasswordLogin bool
+	PermitRootLogin     bool
+}
+
+// NewPolicy returns the authentication policy of the configuration
+func NewPolicy(c config.Config) Policy {
+	return Policy{
+		PermitKeyLogin:      c.PermitKeyLogin,
+		PermitPasswordLogin: c.PermitPasswordLogin,
+		PermitRootLogin:     c.PermitRootLogin,
+	}
+}
+
+// CheckUser returns an error if the user is not allowed to log in the bastion
+func (p Policy) CheckUser(username string) error {
+	if username == RootUser {
+		return errors.New("root login is not allowed on the bastion")
+	}
+
+	return nil
+}
+
+// CheckBackendUser returns an error if the bastion must not log in a backend as the user
+func (p Policy) CheckBackendUser(username string) error {
+	if username == RootUser && !p.PermitRootLogin {
+		return errors.New("root login on the backends is not allowed")
+	}
+
+	return nil
+}
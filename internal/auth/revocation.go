@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// RevocationList contains the revoked keys and certificates, in the text format ssh-keygen -k reads to build a KRL,
+// see the KEY REVOCATION LISTS section of ssh-keygen(1). Each line is one of:
+//
+//	serial: SERIAL[-SERIAL]
+//	id: KEY_ID
+//	key: PUBLIC_KEY
+//	sha256: PUBLIC_KEY
+//	hash: SHA256:FINGERPRINT
+//
+// The serials and key IDs apply to the certificates of every trusted CA. A revoked key revokes the certificates
+// it is the key or the signing CA of.
+type RevocationList struct {
+	serials      []serialRange
+	keyIDs       map[string]bool
+	fingerprints map[string]bool
+}
+
+//serialRange is an inclusive range of revoked certificate serials
+type serialRange struct {
+	first uint64
+	last  uint64
+}
+
+// ParseRevocationList parses a revocation list, the blank lines and the comments starting with # are ignored
+func ParseRevocationList(content []byte) (*RevocationList, error) {
+	r := &RevocationList{
+		keyIDs:       make(map[string]bool),
+		fingerprints: make(map[string]bool),
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	n := 0
+
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if err := r.parseLine(line); err != nil {
+			return nil, errors.New("line " + strconv.Itoa(n) + " : " + err.Error())
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+//parseLine adds the entry of a line to the revocation list
+func (r *RevocationList) parseLine(line string) error {
+	i := strings.IndexByte(line, ':')
+
+	if i < 0 {
+		return errors.New("missing entry type")
+	}
+
+	kind, value := strings.ToLower(strings.TrimSpace(line[:i])), strings.TrimSpace(line[i+1:])
+
+	if value == "" {
+		return errors.New("missing value for " + kind)
+	}
+
+	switch kind {
+	case "serial":
+		first, last := value, value
+
+		if j := strings.IndexByte(value, '-'); j >= 0 {
+			first, last = value[:j], value[j+1:]
+		}
+
+		s := serialRange{}
+		var err error
+
+		if s.first, err = strconv.ParseUint(strings.TrimSpace(first), 0, 64); err != nil {
+			return errors.New("invalid serial " + value)
+		}
+
+		if s.last, err = strconv.ParseUint(strings.TrimSpace(last), 0, 64); err != nil || s.last < s.first {
+			return errors.New("invalid serial " + value)
+		}
+
+		r.serials = append(r.serials, s)
+	case "id":
+		r.keyIDs[value] = true
+	case "key", "sha256":
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(value))
+
+		if err != nil {
+			return errors.New("invalid public key : " + err.Error())
+		}
+
+		r.fingerprints[ssh.FingerprintSHA256(key)] = true
+	case "hash":
+		if !strings.HasPrefix(value, "SHA256:") {
+			return errors.New("only SHA256 fingerprints are supported")
+		}
+
+		r.fingerprints[value] = true
+	default:
+		return errors.New("unknown entry type " + kind)
+	}
+
+	return nil
+}
+
+// LoadRevocationList reads the revocation list at path
+func LoadRevocationList(path string) (*RevocationList, error) {
+	content, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseRevocationList(content)
+}
+
+// IsKeyRevoked returns whether the key is revoked. A nil list revokes nothing.
+func (r *RevocationList) IsKeyRevoked(key ssh.PublicKey) bool {
+	if r == nil {
+		return false
+	}
+
+	return r.fingerprints[ssh.FingerprintSHA256(key)]
+}
+
+// IsCertRevoked returns whether the certificate is revoked by its serial, its key ID, its key or its CA key
+func (r *RevocationList) IsCertRevoked(cert *ssh.Certificate) bool {
+	if r == nil {
+		return false
+	}
+
+	for _, s := range r.serials {
+		if cert.Serial >= s.first && cert.Serial <= s.last {
+			return true
+		}
+	}
+
+	return r.keyIDs[cert.KeyId] || r.IsKeyRevoked(cert.Key) || r.IsKeyRevoked(cert.SignatureKey)
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"errors"
+	"github.com/open-bastion/open-bastion/internal/config"
+	"net"
+	"strings"
+)
+
+// SourceFilter allows the source addresses matching its rules. An address is refused if it matches a deny rule or if
+// there are allow rules and it matches none of them. The zero SourceFilter allows every address.
+type SourceFilter struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// NewSourceFilter returns the filter of the configured rules, CIDR blocks or single addresses
+func NewSourceFilter(c config.SourceRules) (SourceFilter, error) {
+	var f SourceFilter
+	var err error
+
+	if f.allow, err = parseNetworks(c.Allow); err != nil {
+		return SourceFilter{}, err
+	}
+
+	if f.deny, err = parseNetworks(c.Deny); err != nil {
+		return SourceFilter{}, err
+	}
+
+	return f, nil
+}
+
+//parseNetworks parses CIDR blocks, a single address is a block of one address
+func parseNetworks(rules []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(rules))
+
+	for _, r := range rules {
+		r = strings.TrimSpace(r)
+
+		if ip := net.ParseIP(r); ip != nil {
+			bits := 8 * net.IPv6len
+
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(r)
+
+		if err != nil {
+			return nil, errors.New("invalid source rule " + r)
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+//matchNetworks returns whether the IP belongs to one of the networks
+func matchNetworks(networks []*net.IPNet, ip net.IP) bool {
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Allows returns whether the filter allows the IP
+func (f SourceFilter) Allows(ip net.IP) bool {
+	if ip == nil || matchNetworks(f.deny, ip) {
+		return len(f.allow) == 0 && len(f.deny) == 0
+	}
+
+	return len(f.allow) == 0 || matchNetworks(f.allow, ip)
+}
+
+// AllowsAddr returns whether the filter allows the IP of a TCP address, the other addresses are only allowed by
+// filters without rules
+func (f SourceFilter) AllowsAddr(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+
+	if !ok {
+		return f.Allows(nil)
+	}
+
+	return f.Allows(tcpAddr.IP)
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"net"
+	"testing"
+
+	"github.com/open-bastion/open-bastion/internal/config"
+	"github.com/open-bastion/open-bastion/internal/datastore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSourceFilter_Allows(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   config.SourceRules
+		ip      string
+		wantErr bool
+		want    bool
+	}{
+		{name: "test no rules", rules: config.SourceRules{}, ip: "192.0.2.1", want: true},
+		{name: "test allowed", rules: config.SourceRules{Allow: []string{"10.0.0.0/8", "192.0.2.0/24"}},
+			ip: "192.0.2.1", want: true},
+		{name: "test not allowed", rules: config.SourceRules{Allow: []string{"10.0.0.0/8"}}, ip: "192.0.2.1",
+			want: false},
+		{name: "test denied", rules: config.SourceRules{Deny: []string{"192.0.2.0/24"}}, ip: "192.0.2.1",
+			want: false},
+		{name: "test not denied", rules: config.SourceRules{Deny: []string{"192.0.2.0/24"}}, ip: "198.51.100.1",
+			want: true},
+		{name: "test deny wins", rules: config.SourceRules{Allow: []string{"192.0.2.0/24"}, Deny: []string{"192.0.2.7"}},
+			ip: "192.0.2.7", want: false},
+		{name: "test single address", rules: config.SourceRules{Allow: []string{"192.0.2.7"}}, ip: "192.0.2.7",
+			want: true},
+		{name: "test ipv6", rules: config.SourceRules{Allow: []string{"2001:db8::/32"}}, ip: "2001:db8::1", want: true},
+		{name: "test ipv4 mapped", rules: config.SourceRules{Allow: []string{"192.0.2.0/24"}}, ip: "::ffff:192.0.2.1",
+			want: true},
+		{name: "test invalid rule", rules: config.SourceRules{Allow: []string{"192.0.2.0/33"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewSourceFilter(tt.rules)
+
+			assert.Equal(t, tt.wantErr, err != nil)
+
+			if err == nil {
+				assert.Equal(t, tt.want, f.Allows(net.ParseIP(tt.ip)))
+			}
+		})
+	}
+}
+
+func TestAuth_UserSources(t *testing.T) {
+	s := datastore.NewMemoryStore(datastore.DefaultKeyPolicy())
+
+	aliceKey := newTestKey(t)
+	bobKey := newTestKey(t)
+
+	assert.Nil(t, s.AddUser("alice", "", 0))
+	assert.Nil(t, s.AddUser("bob", "", 0))
+	assert.Nil(t, s.AddUserAuthorizedKey("alice", aliceKey))
+	assert.Nil(t, s.AddUserAuthorizedKey("bob", bobKey))
+
+	a, err := NewAuth(s, config.Config{
+		PermitKeyLogin: true,
+		Sources: config.Sources{Users: map[string]config.SourceRules{
+			"alice": {Allow: []string{"10.0.0.0/8"}},
+			"bob":   {Allow: []string{"127.0.0.0/8"}},
+		}},
+	})
+	assert.Nil(t, err)
+
+	//The test connections come from 127.0.0.1
+	_, err = a.PublicKeyCallback(testConnMetadata{user: "alice"}, aliceKey.Key)
+	assert.NotNil(t, err)
+
+	_, err = a.PublicKeyCallback(testConnMetadata{user: "bob"}, bobKey.Key)
+	assert.Nil(t, err)
+
+	_, err = NewAuth(s, config.Config{
+		Sources: config.Sources{Users: map[string]config.SourceRules{"alice": {Deny: []string{"invalid"}}}},
+	})
+	assert.NotNil(t, err)
+}
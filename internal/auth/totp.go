@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//Parameters of the RFC 6238 codes, the defaults of the authenticator applications
+const (
+	totpPeriod    = 30
+	totpDigits    = 6
+	totpModulo    = 1000000
+	totpSkew      = 1
+	totpSecretLen = 20
+)
+
+//totpEncoding is the encoding of the secrets in the otpauth URIs
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret returns a new random TOTP secret encoded in base32
+func NewTOTPSecret() (string, error) {
+	secret := make([]byte, totpSecretLen)
+
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// TOTPURI returns the otpauth URI of the secret, to be imported in an authenticator application
+func TOTPURI(issuer string, username string, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpPeriod))
+
+	u := url.URL{Scheme: "otpauth", Host: "totp", Path: "/" + issuer + ":" + username, RawQuery: v.Encode()}
+
+	return u.String()
+}
+
+//totpCounter returns the RFC 6238 time step of t
+func totpCounter(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+//hotp returns the RFC 4226 code of the secret for the counter
+func hotp(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	_, _ = mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, code%totpModulo)
+}
+
+//decodeTOTPSecret decodes a base32 secret, with or without padding
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	key, err := totpEncoding.DecodeString(strings.TrimRight(strings.ToUpper(secret), "="))
+
+	if err != nil || len(key) == 0 {
+		return nil, errors.New("invalid TOTP secret")
+	}
+
+	return key, nil
+}
+
+// TOTPCode returns the code of the secret at the time t
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := decodeTOTPSecret(secret)
+
+	if err != nil {
+		return "", err
+	}
+
+	return hotp(key, totpCounter(t)), nil
+}
+
+// ValidateTOTP returns the time step of the code if it is valid at the time t, one step of clock skew is tolerated.
+// It returns -1 if the code is invalid.
+func ValidateTOTP(secret string, code string, t time.Time) (int64, error) {
+	key, err := decodeTOTPSecret(secret)
+
+	if err != nil {
+		return -1, err
+	}
+
+	code = strings.TrimSpace(code)
+
+	if len(code) != totpDigits {
+		return -1, nil
+	}
+
+	counter := totpCounter(t)
+
+	for c := counter - totpSkew; c <= counter+totpSkew; c++ {
+		if hmac.Equal([]byte(hotp(key, c)), []byte(code)) {
+			return c, nil
+		}
+	}
+
+	return -1, nil
+}
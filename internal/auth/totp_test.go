@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//rfc6238Secret is the SHA1 secret of the RFC 6238 test vectors, "12345678901234567890" in base32
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	tests := []struct {
+		name string
+		time int64
+		code string
+	}{
+		{name: "test 59", time: 59, code: "287082"},
+		{name: "test 1111111109", time: 1111111109, code: "081804"},
+		{name: "test 1111111111", time: 1111111111, code: "050471"},
+		{name: "test 1234567890", time: 1234567890, code: "005924"},
+		{name: "test 2000000000", time: 2000000000, code: "279037"},
+		{name: "test 20000000000", time: 20000000000, code: "353130"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := TOTPCode(rfc6238Secret, time.Unix(tt.time, 0))
+
+			assert.Nil(t, err)
+			assert.Equal(t, tt.code, code)
+		})
+	}
+
+	_, err := TOTPCode("not base32!", time.Now())
+	assert.NotNil(t, err)
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+	}{
+		{name: "test ok", code: "050471", wantStep: 37037037},
+		{name: "test previous step", code: "081804", wantStep: 37037036},
+		{name: "test invalid", code: "123456", wantStep: -1},
+		{name: "test too short", code: "50471", wantStep: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, err := ValidateTOTP(rfc6238Secret, tt.code, now)
+
+			assert.Nil(t, err)
+			assert.Equal(t, tt.wantStep, step)
+		})
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	assert.Nil(t, err)
+
+	uri := TOTPURI("open-bastion", "alice", secret)
+
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/open-bastion:alice?"))
+	assert.Contains(t, uri, "secret="+secret)
+	assert.Contains(t, uri, "issuer=open-bastion")
+}
@@ -1,42 +1,209 @@
 package config
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"github.com/open-bastion/open-bastion/internal/logger"
 	"io/ioutil"
 	"net"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
 )
 
 const (
 	DefaultUsersDirectory = "/var/lib/open-bastion/users/"
 	DefaultLogsDirectory  = "/var/log/open-bastion/"
+	LogFileName           = "open-bastion.log"
+	AuditFileName         = "audit.log"
+	DefaultKnownHostsFile = "/var/lib/open-bastion/known_hosts"
+	DefaultRecordingsDir  = "/var/lib/open-bastion/recordings/"
+	DefaultAuditHeadFile  = "/var/lib/open-bastion/audit.head"
 
 	DefaultStorage = "system"
+
+	DefaultSQLDriver     = "sqlite3"
+	DefaultSQLDataSource = "/var/lib/open-bastion/open-bastion.db"
+
+	PasswordHashArgon2id = "argon2id"
+	PasswordHashBcrypt   = "bcrypt"
+
+	DefaultPasswordHash            = PasswordHashArgon2id
+	DefaultPasswordMaxFailures     = 5
+	DefaultPasswordLockoutDuration = 900
+
+	DefaultMFAIssuer = "open-bastion"
+
+	HostKeyPolicyStrict         = "strict"
+	HostKeyPolicyTOFU           = "tofu"
+	HostKeyPolicyTOFUThenStrict = "tofu-then-strict"
+
+	DefaultHostKeyPolicy = HostKeyPolicyStrict
+
+	DefaultEgressCAValidity = 300
+
+	DefaultThrottleMaxFailures    = 10
+	DefaultThrottleWindow         = 300
+	DefaultThrottleBanDuration    = 60
+	DefaultThrottleMaxBanDuration = 3600
+
+	DefaultDrainPeriod = 30
+	NoDrainPeriod      = -1
+
+	DefaultLogMaxSize    = 100
+	DefaultLogMaxAge     = 24
+	DefaultLogMaxBackups = 7
+
+	DefaultSyslogAddress  = "/dev/log"
+	DefaultSyslogFacility = "daemon"
+	DefaultSyslogTag      = "open-bastion"
 )
 
 // Config struct contains the server configuration
 type Config struct {
-	PermitPasswordLogin bool   `json:"PermitPasswordLogin"`
-	PermitKeyLogin      bool   `json:"PermitKeyLogin"`
-	PermitRootLogin     bool   `json:"PermitRootLogin"`
-	AuthorizedKeysFile  string `json:"AuthorizedKeysFile"`
-	PrivateKeyFile      string `json:"PrivateKeyFile"`
-	UserKeysDir         string `json:"UserKeysDir"`
-	ListenPort          int    `json:"ListenPort"`
-	ListenAddress       string `json:"ListenAddress"`
-	Log                 Log    `json:"Log"`
-	DataStoreType       string `json:"DataStoreType"`
-	BackendTimeout      int    `json:"BackendTimeout"`
-}
-
-//Log contains the logger configuration
+	PermitPasswordLogin bool       `json:"PermitPasswordLogin"`
+	Password            Password   `json:"Password"`
+	MFA                 MFA        `json:"MFA"`
+	Throttle            Throttle   `json:"Throttle"`
+	Sources             Sources    `json:"Sources"`
+	PermitKeyLogin      bool       `json:"PermitKeyLogin"`
+	PermitRootLogin     bool       `json:"PermitRootLogin"`
+	TrustedUserCAKeys   string     `json:"TrustedUserCAKeys"`
+	RevokedKeys         string     `json:"RevokedKeys"`
+	AuthorizedKeysFile  string     `json:"AuthorizedKeysFile"`
+	PrivateKeyFile      string     `json:"PrivateKeyFile"`
+	UserKeysDir         string     `json:"UserKeysDir"`
+	KnownHostsFile      string     `json:"KnownHostsFile"`
+	HostKeyPolicy       string     `json:"HostKeyPolicy"`
+	HostKeyTOFUPeriod   int        `json:"HostKeyTOFUPeriod"`
+	ListenPort          int        `json:"ListenPort"`
+	ListenAddress       string     `json:"ListenAddress"`
+	Log                 Log        `json:"Log"`
+	Audit               Audit      `json:"Audit"`
+	Recording           Recording  `json:"Recording"`
+	EgressKeys          EgressKeys `json:"EgressKeys"`
+	EgressCA            EgressCA   `json:"EgressCA"`
+	DataStoreType       string     `json:"DataStoreType"`
+	SQL                 SQL        `json:"SQL"`
+	Memory              Memory     `json:"Memory"`
+	BackendTimeout      int        `json:"BackendTimeout"`
+	DrainPeriod         int        `json:"DrainPeriod"`
+}
+
+//Password contains the password login policy. Hash is the algorithm of the new password hashes (argon2id or bcrypt),
+//MaxFailures consecutive failed attempts lock the account for LockoutDuration seconds.
+type Password struct {
+	Hash            string `json:"Hash"`
+	MaxFailures     int    `json:"MaxFailures"`
+	LockoutDuration int    `json:"LockoutDuration"`
+}
+
+//MFA contains the configuration of the TOTP second factor. Issuer is the account issuer displayed by the
+//authenticator applications.
+type MFA struct {
+	Issuer string `json:"Issuer"`
+}
+
+//Throttle contains the brute-force protection policy. MaxFailures failed authentications of a source IP or username
+//in Window seconds ban it for BanDuration seconds, doubled at each new ban up to MaxBanDuration. Each failed
+//authentication is delayed by TarpitDelay milliseconds.
+type Throttle struct {
+	MaxFailures    int `json:"MaxFailures"`
+	Window         int `json:"Window"`
+	BanDuration    int `json:"BanDuration"`
+	MaxBanDuration int `json:"MaxBanDuration"`
+	TarpitDelay    int `json:"TarpitDelay"`
+}
+
+//SourceRules contains the CIDR blocks or addresses the clients may connect from. Deny rules win over allow rules, an
+//empty Allow list allows every address which is not denied.
+type SourceRules struct {
+	Allow []string `json:"Allow"`
+	Deny  []string `json:"Deny"`
+}
+
+//Sources contains the source rules of every connection, checked before the SSH handshake, and the rules of some
+//users checked when they authenticate.
+type Sources struct {
+	SourceRules
+	Users map[string]SourceRules `json:"Users"`
+}
+
+//Audit contains the configuration of the audit log, written to File or to the audit.log file of the logs directory.
+//The records are signed with the HMAC key read from HMACKeyFile if it is set. The head of the log is kept in HeadFile,
+//outside the logs directory, to detect the removal of the last records.
+type Audit struct {
+	File        string `json:"File"`
+	HeadFile    string `json:"HeadFile"`
+	HMACKeyFile string `json:"HMACKeyFile"`
+}
+
+//Recording contains the configuration of the backend session recordings, written to Directory in the asciicast v2
+//format. The client input, passwords typed included, is only recorded if RecordInput is set.
+type Recording struct {
+	Directory   string `json:"Directory"`
+	RecordInput bool   `json:"RecordInput"`
+}
+
+//EgressKeys contains the policy of the user egress keys. Allowed maps each allowed key type (rsa, ecdsa, ed25519)
+//to its allowed sizes in bits, the first size being the default one.
+type EgressKeys struct {
+	DefaultType string           `json:"DefaultType"`
+	Allowed     map[string][]int `json:"Allowed"`
+}
+
+//EgressCA contains the configuration of the egress certificates. When PrivateKeyFile is set, the bastion logs in
+//the backends with ephemeral keys certified by this CA for Validity seconds instead of the egress keys of the users.
+type EgressCA struct {
+	PrivateKeyFile string `json:"PrivateKeyFile"`
+	Validity       int    `json:"Validity"`
+}
+
+//SQL contains the configuration of the SQL data store. ConnMaxLifetime is expressed in seconds, 0 means
+//connections are reused forever. The default sqlite3 driver requires a bastion built with cgo.
+type SQL struct {
+	Driver          string `json:"Driver"`
+	DataSource      string `json:"DataSource"`
+	MaxOpenConns    int    `json:"MaxOpenConns"`
+	MaxIdleConns    int    `json:"MaxIdleConns"`
+	ConnMaxLifetime int    `json:"ConnMaxLifetime"`
+}
+
+//Memory contains the configuration of the in-memory data store. FixtureFile is an optional JSON or YAML file
+//the store is seeded with at startup, changes are never written back.
+type Memory struct {
+	FixtureFile string `json:"FixtureFile"`
+}
+
+//Log contains the logger configuration. The messages are written to the open-bastion.log file of the Path directory,
+//and to the standard error if Console is set or if neither Path, Syslog nor Journald is configured. The file is
+//rotated when it reaches MaxSize megabytes or after MaxAge hours, the MaxBackups most recent rotated files are kept
+//and compressed if Compress is set. The messages are also sent to syslog if Syslog.Network is set and to journald if
+//Journald is set.
 type Log struct {
 	Path         string `json:"Path"`
 	IsJSON       bool   `json:"IsJson"`
 	Level        int    `json:"Level"`
 	ReportCaller bool   `json:"ReportCaller"`
+	Console      bool   `json:"Console"`
+	MaxSize      int    `json:"MaxSize"`
+	MaxAge       int    `json:"MaxAge"`
+	MaxBackups   int    `json:"MaxBackups"`
+	Compress     bool   `json:"Compress"`
+	Syslog       Syslog `json:"Syslog"`
+	Journald     bool   `json:"Journald"`
+}
+
+//Syslog contains the configuration of the syslog output. Network is unix, udp or tcp, Address is the socket path
+//(/dev/log by default) or the host:port of the server. Tag identifies the messages in syslog and journald.
+type Syslog struct {
+	Network  string `json:"Network"`
+	Address  string `json:"Address"`
+	Facility string `json:"Facility"`
+	Tag      string `json:"Tag"`
 }
 
 // ParseConfig try to open and parse the file at the specified path.
@@ -59,7 +226,6 @@ func ParseConfig(path string) (Config, error) {
 	}
 
 	defaultPrivateKey := home + "/.ssh/id_rsa"
-	defaultAuthorizedKeys := home + "/.ssh/authorized_keys"
 	defaultSSHPort := 22
 
 	configPath, err := validateConfigPath(path, defaultConfigPaths)
@@ -100,6 +266,55 @@ func ParseConfig(path string) (Config, error) {
 		return Config{}, errors.New("no authorized login method")
 	}
 
+	if c.Password.Hash == "" {
+		c.Password.Hash = DefaultPasswordHash
+	} else if c.Password.Hash != PasswordHashArgon2id && c.Password.Hash != PasswordHashBcrypt {
+		return Config{}, errors.New("invalid password hash configuration")
+	}
+
+	if c.Password.MaxFailures == 0 {
+		c.Password.MaxFailures = DefaultPasswordMaxFailures
+	}
+
+	if c.Password.LockoutDuration == 0 {
+		c.Password.LockoutDuration = DefaultPasswordLockoutDuration
+	}
+
+	if c.Password.MaxFailures < 0 || c.Password.LockoutDuration < 0 {
+		return Config{}, errors.New("invalid password lockout configuration")
+	}
+
+	if c.Throttle.MaxFailures == 0 {
+		c.Throttle.MaxFailures = DefaultThrottleMaxFailures
+	}
+
+	if c.Throttle.Window == 0 {
+		c.Throttle.Window = DefaultThrottleWindow
+	}
+
+	if c.Throttle.BanDuration == 0 {
+		c.Throttle.BanDuration = DefaultThrottleBanDuration
+	}
+
+	if c.Throttle.MaxBanDuration == 0 {
+		c.Throttle.MaxBanDuration = DefaultThrottleMaxBanDuration
+	}
+
+	if c.Throttle.MaxFailures < 0 || c.Throttle.Window < 0 || c.Throttle.BanDuration < 0 ||
+		c.Throttle.MaxBanDuration < c.Throttle.BanDuration || c.Throttle.TarpitDelay < 0 {
+		return Config{}, errors.New("invalid throttle configuration")
+	}
+
+	if c.EgressCA.Validity == 0 {
+		c.EgressCA.Validity = DefaultEgressCAValidity
+	} else if c.EgressCA.Validity < 0 {
+		return Config{}, errors.New("invalid egress CA validity configuration")
+	}
+
+	if c.MFA.Issuer == "" {
+		c.MFA.Issuer = DefaultMFAIssuer
+	}
+
 	if c.ListenPort == 0 {
 		c.ListenPort = defaultSSHPort
 	} else if c.ListenPort > 65535 || c.ListenPort < 0 {
@@ -127,23 +342,6 @@ func ParseConfig(path string) (Config, error) {
 		}
 	}
 
-	if c.AuthorizedKeysFile == "" {
-		logger.Warnf("no authorized_keys file provided, using default file %v", defaultAuthorizedKeys)
-		c.AuthorizedKeysFile = defaultAuthorizedKeys
-
-		_, err := os.Stat(c.AuthorizedKeysFile)
-
-		if err != nil {
-			return Config{}, errors.New("invalid authorized_keys file path")
-		}
-	} else {
-		_, err := os.Stat(c.AuthorizedKeysFile)
-
-		if err != nil {
-			return Config{}, errors.New("invalid authorized_keys file path")
-		}
-	}
-
 	if c.UserKeysDir == "" {
 		c.UserKeysDir = DefaultUsersDirectory
 
@@ -157,6 +355,28 @@ func ParseConfig(path string) (Config, error) {
 		}
 	}
 
+	if c.KnownHostsFile == "" {
+		logger.Warnf("no known_hosts file provided, using default file %v", DefaultKnownHostsFile)
+		c.KnownHostsFile = DefaultKnownHostsFile
+	}
+
+	if c.HostKeyPolicy == "" {
+		logger.Warnf("no host key policy provided, using default policy %v", DefaultHostKeyPolicy)
+		c.HostKeyPolicy = DefaultHostKeyPolicy
+	} else if c.HostKeyPolicy != HostKeyPolicyStrict && c.HostKeyPolicy != HostKeyPolicyTOFU &&
+		c.HostKeyPolicy != HostKeyPolicyTOFUThenStrict {
+		return Config{}, errors.New("invalid host key policy configuration")
+	}
+
+	if c.HostKeyPolicy == HostKeyPolicyTOFUThenStrict && c.HostKeyTOFUPeriod <= 0 {
+		return Config{}, errors.New("the tofu-then-strict host key policy requires a positive HostKeyTOFUPeriod")
+	}
+
+	//Without any configured output the messages are also written to the standard error, as before the log file
+	if c.Log.Path == "" && c.Log.Syslog.Network == "" && !c.Log.Journald {
+		c.Log.Console = true
+	}
+
 	if c.Log.Path == "" {
 		c.Log.Path = DefaultLogsDirectory
 
@@ -170,19 +390,174 @@ func ParseConfig(path string) (Config, error) {
 		}
 	}
 
+	if c.Audit.File == "" {
+		c.Audit.File = filepath.Join(c.Log.Path, AuditFileName)
+	}
+
+	if c.Audit.HeadFile == "" {
+		c.Audit.HeadFile = DefaultAuditHeadFile
+	}
+
+	if c.Recording.Directory == "" {
+		c.Recording.Directory = DefaultRecordingsDir
+	}
+
+	if c.Log.MaxSize == 0 {
+		c.Log.MaxSize = DefaultLogMaxSize
+	}
+
+	if c.Log.MaxAge == 0 {
+		c.Log.MaxAge = DefaultLogMaxAge
+	}
+
+	if c.Log.MaxBackups == 0 {
+		c.Log.MaxBackups = DefaultLogMaxBackups
+	}
+
+	if c.Log.MaxSize < 0 || c.Log.MaxAge < 0 || c.Log.MaxBackups < 0 {
+		return Config{}, errors.New("invalid log rotation configuration")
+	}
+
+	if c.Log.Syslog.Network == logger.SyslogUnix && c.Log.Syslog.Address == "" {
+		c.Log.Syslog.Address = DefaultSyslogAddress
+	}
+
+	if c.Log.Syslog.Network != "" && c.Log.Syslog.Network != logger.SyslogUnix &&
+		c.Log.Syslog.Network != logger.SyslogUDP && c.Log.Syslog.Network != logger.SyslogTCP {
+		return Config{}, errors.New("invalid syslog network " + c.Log.Syslog.Network)
+	}
+
+	if c.Log.Syslog.Network != "" && c.Log.Syslog.Address == "" {
+		return Config{}, errors.New("no syslog server address provided")
+	}
+
+	if c.Log.Syslog.Facility == "" {
+		c.Log.Syslog.Facility = DefaultSyslogFacility
+	}
+
+	if _, err := logger.SyslogFacility(c.Log.Syslog.Facility); err != nil {
+		return Config{}, err
+	}
+
+	if c.Log.Syslog.Tag == "" {
+		c.Log.Syslog.Tag = DefaultSyslogTag
+	}
+
 	if c.DataStoreType == "" {
 		logger.Warnf("no data store provided, using default storage %v", DefaultStorage)
 		c.DataStoreType = DefaultStorage
 	}
 
+	if c.DataStoreType == "sql" {
+		if c.SQL.Driver == "" {
+			logger.Warnf("no SQL driver provided, using default driver %v", DefaultSQLDriver)
+			c.SQL.Driver = DefaultSQLDriver
+		}
+
+		if c.SQL.DataSource == "" {
+			logger.Warnf("no SQL data source provided, using default data source %v", DefaultSQLDataSource)
+			c.SQL.DataSource = DefaultSQLDataSource
+		}
+
+		//The sqlite3 driver is registered without cgo but every connection then fails
+		if c.SQL.Driver == DefaultSQLDriver && !cgoEnabled {
+			return Config{}, errors.New("the " + DefaultSQLDriver + " SQL driver requires a bastion built with cgo " +
+				"(CGO_ENABLED=1 and a C compiler)")
+		}
+
+		if !sqlDriverAvailable(c.SQL.Driver) {
+			return Config{}, errors.New("unknown SQL driver " + c.SQL.Driver + ", the bastion is built with " +
+				strings.Join(sql.Drivers(), ", "))
+		}
+
+		//SQLite does not handle concurrent writers, serialize the accesses unless told otherwise
+		if c.SQL.Driver == DefaultSQLDriver && c.SQL.MaxOpenConns == 0 {
+			c.SQL.MaxOpenConns = 1
+		}
+
+		if c.SQL.MaxOpenConns < 0 || c.SQL.MaxIdleConns < 0 || c.SQL.ConnMaxLifetime < 0 {
+			return Config{}, errors.New("invalid SQL connection settings")
+		}
+	}
+
 	if c.BackendTimeout < 0 {
 		logger.Warnf("backend timeout provided is negative, setting it to 0 instead (no timeout)")
 		c.BackendTimeout = 0
 	}
 
+	//The active sessions are given DrainPeriod seconds to end when the bastion shuts down, 0 is the default period and
+	//NoDrainPeriod closes them right away
+	if c.DrainPeriod == 0 {
+		c.DrainPeriod = DefaultDrainPeriod
+	} else if c.DrainPeriod < NoDrainPeriod {
+		return Config{}, errors.New("invalid drain period configuration")
+	}
+
 	return c, nil
 }
 
+//sqlDriverAvailable returns whether the bastion is built with the SQL driver
+func sqlDriverAvailable(driver string) bool {
+	for _, d := range sql.Drivers() {
+		if d == driver {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RestartRequired returns the settings changed in n which are only applied when the bastion starts: the listening
+// address, the data store, the log output and the audit log. The other settings, including the log level, can be
+// reloaded.
+func (c Config) RestartRequired(n Config) []string {
+	var changed []string
+
+	if c.ListenAddress != n.ListenAddress || c.ListenPort != n.ListenPort {
+		changed = append(changed, "ListenAddress", "ListenPort")
+	}
+
+	if c.DataStoreType != n.DataStoreType || c.SQL != n.SQL || c.Memory != n.Memory ||
+		c.UserKeysDir != n.UserKeysDir || c.KnownHostsFile != n.KnownHostsFile {
+		changed = append(changed, "DataStoreType", "SQL", "Memory", "UserKeysDir", "KnownHostsFile")
+	}
+
+	if !reflect.DeepEqual(c.EgressKeys, n.EgressKeys) {
+		changed = append(changed, "EgressKeys")
+	}
+
+	//The log level is the only log setting which can be reloaded
+	currentLog, newLog := c.Log, n.Log
+	currentLog.Level, newLog.Level = 0, 0
+
+	if currentLog != newLog {
+		changed = append(changed, "Log")
+	}
+
+	if c.Audit != n.Audit {
+		changed = append(changed, "Audit")
+	}
+
+	return changed
+}
+
+// KeepRestartRequired returns n with the settings which are only applied when the bastion starts taken from c, the
+// configuration the bastion runs with. See RestartRequired.
+func (c Config) KeepRestartRequired(n Config) Config {
+	n.ListenAddress, n.ListenPort = c.ListenAddress, c.ListenPort
+	n.DataStoreType, n.SQL, n.Memory = c.DataStoreType, c.SQL, c.Memory
+	n.UserKeysDir, n.KnownHostsFile = c.UserKeysDir, c.KnownHostsFile
+	n.EgressKeys = c.EgressKeys
+
+	level := n.Log.Level
+	n.Log = c.Log
+	n.Log.Level = level
+
+	n.Audit = c.Audit
+
+	return n
+}
+
 //IsJSON returns the IsJSON field of the log config
 func (c Config) IsJSON() bool {
 	return c.Log.IsJSON
@@ -220,3 +595,41 @@ func validateConfigPath(path string, defaultPaths []string) (string, error) {
 
 	return "", errors.New("could not open any configuration file")
 }
+
+//LogFile returns the path of the log file
+func (c Config) LogFile() string {
+	return filepath.Join(c.Log.Path, LogFileName)
+}
+
+//LogRotation returns the rotation policy of the log file
+func (c Config) LogRotation() logger.Rotation {
+	return logger.Rotation{
+		MaxSize:    int64(c.Log.MaxSize) * 1024 * 1024,
+		MaxAge:     time.Duration(c.Log.MaxAge) * time.Hour,
+		MaxBackups: c.Log.MaxBackups,
+		Compress:   c.Log.Compress,
+	}
+}
+
+//Console returns the Console field of the log config
+func (c Config) Console() bool {
+	return c.Log.Console
+}
+
+//LogSyslog returns the syslog output of the log config, disabled if its network is empty
+func (c Config) LogSyslog() logger.SyslogOutput {
+	//The facility is validated by ParseConfig
+	facility, _ := logger.SyslogFacility(c.Log.Syslog.Facility)
+
+	return logger.SyslogOutput{
+		Network:  c.Log.Syslog.Network,
+		Address:  c.Log.Syslog.Address,
+		Facility: facility,
+		Tag:      c.Log.Syslog.Tag,
+	}
+}
+
+//LogJournald returns the Journald field of the log config
+func (c Config) LogJournald() bool {
+	return c.Log.Journald
+}
@@ -0,0 +1,7 @@
+//go:build !cgo
+// +build !cgo
+
+package config
+
+//cgoEnabled is whether the bastion is built with cgo, which the sqlite3 SQL driver requires
+const cgoEnabled = false
@@ -0,0 +1,106 @@
+package datastore
+
+import (
+	"bytes"
+	"errors"
+	logger "github.com/open-bastion/open-bastion/internal/logger"
+	"golang.org/x/crypto/ssh"
+	"strings"
+)
+
+// AuthorizedKey is an ingress public key allowed to log in as its owner, as found in an authorized_keys file
+type AuthorizedKey struct {
+	Key     ssh.PublicKey
+	Comment string
+	Options []string
+}
+
+//ParseAuthorizedKey parses a single line in the authorized_keys format
+func ParseAuthorizedKey(line []byte) (AuthorizedKey, error) {
+	line = bytes.TrimSpace(line)
+
+	if len(line) == 0 || bytes.IndexByte(line, '\n') >= 0 {
+		return AuthorizedKey{}, errors.New("an authorized key must be a single line")
+	}
+
+	key, comment, options, _, err := ssh.ParseAuthorizedKey(line)
+
+	if err != nil {
+		return AuthorizedKey{}, errors.New("invalid authorized key : " + err.Error())
+	}
+
+	return AuthorizedKey{Key: key, Comment: comment, Options: options}, nil
+}
+
+//Fingerprint returns the SHA256 fingerprint of the key
+func (k AuthorizedKey) Fingerprint() string {
+	return ssh.FingerprintSHA256(k.Key)
+}
+
+//Marshal returns the key as an authorized_keys line, ending with a newline
+func (k AuthorizedKey) Marshal() []byte {
+	var b bytes.Buffer
+
+	if len(k.Options) > 0 {
+		b.WriteString(strings.Join(k.Options, ","))
+		b.WriteByte(' ')
+	}
+
+	b.Write(bytes.TrimSpace(ssh.MarshalAuthorizedKey(k.Key)))
+
+	if k.Comment != "" {
+		b.WriteByte(' ')
+		b.WriteString(k.Comment)
+	}
+
+	b.WriteByte('\n')
+
+	return b.Bytes()
+}
+
+//parseAuthorizedKeys parses the content of an authorized_keys file. Blank lines and comments are ignored, invalid
+//lines are logged and skipped so that a single typo does not lock every key out.
+func parseAuthorizedKeys(content []byte) []AuthorizedKey {
+	var keys []AuthorizedKey
+
+	for i, line := range bytes.Split(content, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		k, err := ParseAuthorizedKey(line)
+
+		if err != nil {
+			logger.WarnfWithErr(err, "error reading key line %v", i+1)
+			continue
+		}
+
+		keys = append(keys, k)
+	}
+
+	return keys
+}
+
+//marshalAuthorizedKeys returns the content of an authorized_keys file containing the keys
+func marshalAuthorizedKeys(keys []AuthorizedKey) []byte {
+	var b bytes.Buffer
+
+	for _, k := range keys {
+		b.Write(k.Marshal())
+	}
+
+	return b.Bytes()
+}
+
+//indexAuthorizedKey returns the index of the key with the fingerprint, -1 if there is none
+func indexAuthorizedKey(keys []AuthorizedKey, fingerprint string) int {
+	for i, k := range keys {
+		if k.Fingerprint() == fingerprint {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -9,22 +9,56 @@ import (
 
 // DataStore is the interface used to access users data
 type DataStore interface {
-	AddUser(string, string) error
+	AddUser(string, string, int) error
 	DeleteUser(string) error
 	GetUserStatus(string) (int, error)
+	IsUserAdmin(string) (bool, error)
+	SetUserStatus(string, int) error
+	SetUserAdmin(string, bool) error
 
 	GetType() string
 
 	GetRawUserEgressPrivateKey(username string) ([]byte, error)
+	GetRawUserEgressPublicKey(username string) ([]byte, error)
 	GetUserEgressPrivateKeySigner(username string) (ssh.Signer, error)
+
+	GetUserAuthorizedKeys(username string) ([]AuthorizedKey, error)
+	AddUserAuthorizedKey(username string, key AuthorizedKey) error
+	RemoveUserAuthorizedKey(username string, fingerprint string) error
+
+	GetUserPasswordHash(username string) (string, error)
+	SetUserPasswordHash(username string, hash string) error
+
+	GetUserTOTPSecret(username string) (string, error)
+	SetUserTOTPSecret(username string, secret string) error
+
+	GetBackendHostKeys(address string) ([]ssh.PublicKey, error)
+	AddBackendHostKey(address string, key ssh.PublicKey) error
+	TrustFirstBackendHostKey(address string, key ssh.PublicKey) ([]ssh.PublicKey, error)
+	ListBackendHostKeys() ([]BackendHostKey, error)
+
+	GetPendingBackendHostKey(address string) (ssh.PublicKey, error)
+	SetPendingBackendHostKey(address string, key ssh.PublicKey) error
+	ListPendingBackendHostKeys() ([]BackendHostKey, error)
+	AcceptPendingBackendHostKey(address string, fingerprint string) error
 }
 
+// ErrPendingHostKeyMismatch is returned when the pending host key to accept does not have the fingerprint the
+// administrator approved, it was replaced in the meantime
+var ErrPendingHostKeyMismatch = errors.New("the pending host key does not match the approved fingerprint")
+
 // UserInfo contains data about a user
 type UserInfo struct {
 	Active bool `json:"active"`
 	Admin  bool `json:"admin"`
 }
 
+// BackendHostKey associates a backend address, as written in a known_hosts file, with one of its host keys
+type BackendHostKey struct {
+	Address string
+	Key     ssh.PublicKey
+}
+
 // Represents a user status
 const (
 	Active = iota
@@ -36,6 +70,7 @@ const (
 const (
 	SystemStoreType = "system"
 	SQLStoreType    = "sql"
+	MemoryStoreType = "memory"
 )
 
 // InitStore return an initialized DataStore
@@ -45,7 +80,15 @@ func InitStore(config config.Config) (DataStore, error) {
 
 		store.storeType = config.DataStoreType
 
-		_, err := os.Stat(config.UserKeysDir)
+		keyPolicy, err := NewKeyPolicy(config.EgressKeys)
+
+		if err != nil {
+			return SystemStore{}, err
+		}
+
+		store.keyPolicy = keyPolicy
+
+		_, err = os.Stat(config.UserKeysDir)
 
 		if os.IsNotExist(err) {
 			err := os.MkdirAll(config.UserKeysDir, 0600)
@@ -56,9 +99,34 @@ func InitStore(config config.Config) (DataStore, error) {
 		}
 
 		store.path = config.UserKeysDir
+		store.knownHostsPath = config.KnownHostsFile
+
+		return store, nil
+	}
+
+	if config.DataStoreType == SQLStoreType {
+		return NewSQLStore(config)
+	}
+
+	if config.DataStoreType == MemoryStoreType {
+		keyPolicy, err := NewKeyPolicy(config.EgressKeys)
+
+		if err != nil {
+			return MemoryStore{}, err
+		}
+
+		store := NewMemoryStore(keyPolicy)
+
+		if config.Memory.FixtureFile != "" {
+			err = store.LoadFixtureFile(config.Memory.FixtureFile)
+
+			if err != nil {
+				return MemoryStore{}, err
+			}
+		}
 
 		return store, nil
 	}
 
-	return nil, errors.New("not implemented")
+	return nil, errors.New("unknown data store type " + config.DataStoreType)
 }
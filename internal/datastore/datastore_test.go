@@ -0,0 +1,393 @@
+package datastore
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/open-bastion/open-bastion/internal/config"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+//testConfig returns a configuration storing everything under dir
+func testConfig(dir string, storeType string) config.Config {
+	return config.Config{
+		UserKeysDir:    dir + "/users/",
+		KnownHostsFile: dir + "/known_hosts",
+		DataStoreType:  storeType,
+		SQL: config.SQL{
+			Driver:       config.DefaultSQLDriver,
+			DataSource:   dir + "/open-bastion.db",
+			MaxOpenConns: 1,
+		},
+		EgressKeys: config.EgressKeys{
+			DefaultType: Ed25519KeyType,
+			Allowed: map[string][]int{
+				Ed25519KeyType: {256},
+				ECDSAKeyType:   {521},
+			},
+		},
+	}
+}
+
+//testDataStore runs the behavior every DataStore implementation must share. The store must be empty.
+func testDataStore(t *testing.T, s DataStore) {
+	//Fixtures: alice is active, bob is inactive, charlie does not exist
+	assert.Nil(t, s.AddUser("alice", "", 0))
+	assert.Nil(t, s.AddUser("bob", ECDSAKeyType, 521))
+	assert.Nil(t, s.SetUserStatus("bob", Inactive))
+
+	t.Run("GetUserStatus", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			username string
+			want     int
+			wantErr  bool
+		}{
+			{name: "test active", username: "alice", want: Active, wantErr: false},
+			{name: "test inactive", username: "bob", want: Inactive, wantErr: false},
+			{name: "test not exist", username: "charlie", want: Error, wantErr: true},
+			{name: "test invalid username", username: "$charlie", want: Error, wantErr: true},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				got, err := s.GetUserStatus(tt.username)
+
+				assert.Equal(t, tt.want, got)
+				assert.Equal(t, tt.wantErr, err != nil)
+			})
+		}
+	})
+
+	t.Run("AddUser", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			username string
+			keyType  string
+			bits     int
+			wantErr  bool
+		}{
+			{name: "already exists", username: "alice", wantErr: true},
+			{name: "type not allowed", username: "charlie", keyType: RSAKeyType, wantErr: true},
+			{name: "size not allowed", username: "charlie", keyType: ECDSAKeyType, bits: 256, wantErr: true},
+			{name: "invalid username", username: "$charlie", wantErr: true},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				err := s.AddUser(tt.username, tt.keyType, tt.bits)
+
+				assert.Equal(t, tt.wantErr, err != nil)
+			})
+		}
+	})
+
+	t.Run("EgressKeys", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			username string
+			wantType string
+			wantErr  bool
+		}{
+			{name: "test ok default type", username: "alice", wantType: ssh.KeyAlgoED25519, wantErr: false},
+			{name: "test ok ecdsa", username: "bob", wantType: ssh.KeyAlgoECDSA521, wantErr: false},
+			{name: "test fail no user", username: "charlie", wantErr: true},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				signer, err := s.GetUserEgressPrivateKeySigner(tt.username)
+				assert.Equal(t, tt.wantErr, err != nil)
+
+				rawPubKey, err := s.GetRawUserEgressPublicKey(tt.username)
+				assert.Equal(t, tt.wantErr, err != nil)
+
+				_, err = s.GetRawUserEgressPrivateKey(tt.username)
+				assert.Equal(t, tt.wantErr, err != nil)
+
+				if tt.wantErr {
+					return
+				}
+
+				pubKey, _, _, _, err := ssh.ParseAuthorizedKey(rawPubKey)
+				assert.Nil(t, err)
+				assert.Equal(t, tt.wantType, pubKey.Type())
+				assert.Equal(t, signer.PublicKey().Marshal(), pubKey.Marshal())
+			})
+		}
+	})
+
+	t.Run("Admin", func(t *testing.T) {
+		admin, err := s.IsUserAdmin("alice")
+		assert.Nil(t, err)
+		assert.False(t, admin)
+
+		assert.Nil(t, s.SetUserAdmin("alice", true))
+
+		admin, err = s.IsUserAdmin("alice")
+		assert.Nil(t, err)
+		assert.True(t, admin)
+
+		status, err := s.GetUserStatus("alice")
+		assert.Nil(t, err)
+		assert.Equal(t, Active, status)
+
+		assert.NotNil(t, s.SetUserAdmin("charlie", true))
+		assert.NotNil(t, s.SetUserStatus("charlie", Active))
+		assert.NotNil(t, s.SetUserStatus("alice", Invalid))
+	})
+
+	t.Run("BackendHostKeys", func(t *testing.T) {
+		var keys []ssh.PublicKey
+
+		for i := 0; i < 2; i++ {
+			pub, _, err := ed25519.GenerateKey(rand.Reader)
+			assert.Nil(t, err)
+
+			key, err := ssh.NewPublicKey(pub)
+			assert.Nil(t, err)
+
+			keys = append(keys, key)
+		}
+
+		known, err := s.GetBackendHostKeys("10.0.0.1:22")
+		assert.Nil(t, err)
+		assert.Empty(t, known)
+
+		assert.Nil(t, s.AddBackendHostKey("10.0.0.1:22", keys[0]))
+		assert.Nil(t, s.AddBackendHostKey("10.0.0.1:22", keys[0]))
+		assert.Nil(t, s.AddBackendHostKey("10.0.0.2:2222", keys[0]))
+
+		known, err = s.GetBackendHostKeys("10.0.0.1:22")
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(known))
+
+		list, err := s.ListBackendHostKeys()
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(list))
+
+		assert.NotNil(t, s.AcceptPendingBackendHostKey("10.0.0.1:22", ssh.FingerprintSHA256(keys[0])))
+		assert.Nil(t, s.SetPendingBackendHostKey("10.0.0.1:22", keys[0]))
+		assert.Nil(t, s.SetPendingBackendHostKey("10.0.0.1:22", keys[1]))
+
+		pending, err := s.ListPendingBackendHostKeys()
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(pending))
+		assert.Equal(t, "10.0.0.1", pending[0].Address)
+		assert.Equal(t, keys[1].Marshal(), pending[0].Key.Marshal())
+
+		//The key replaced after the administrator checked it is not accepted
+		err = s.AcceptPendingBackendHostKey("10.0.0.1:22", ssh.FingerprintSHA256(keys[0]))
+		assert.Equal(t, ErrPendingHostKeyMismatch, err)
+
+		assert.Nil(t, s.AcceptPendingBackendHostKey("10.0.0.1:22", ssh.FingerprintSHA256(keys[1])))
+
+		known, err = s.GetBackendHostKeys("10.0.0.1:22")
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(known))
+		assert.Equal(t, keys[1].Marshal(), known[0].Marshal())
+
+		key, err := s.GetPendingBackendHostKey("10.0.0.1:22")
+		assert.Nil(t, err)
+		assert.Nil(t, key)
+	})
+
+	t.Run("TrustFirstBackendHostKey", func(t *testing.T) {
+		keys := make([]ssh.PublicKey, 8)
+
+		for i := range keys {
+			pub, _, err := ed25519.GenerateKey(rand.Reader)
+			assert.Nil(t, err)
+
+			keys[i], err = ssh.NewPublicKey(pub)
+			assert.Nil(t, err)
+		}
+
+		//Concurrent first connections: a single key is trusted, the others get it back
+		trusted := make(chan ssh.PublicKey, len(keys))
+		var wg sync.WaitGroup
+
+		for _, key := range keys {
+			wg.Add(1)
+
+			go func(key ssh.PublicKey) {
+				defer wg.Done()
+
+				known, err := s.TrustFirstBackendHostKey("10.0.0.3:22", key)
+				assert.Nil(t, err)
+
+				if len(known) == 0 {
+					trusted <- key
+				} else {
+					assert.Equal(t, 1, len(known))
+				}
+			}(key)
+		}
+
+		wg.Wait()
+		close(trusted)
+
+		assert.Equal(t, 1, len(trusted))
+
+		known, err := s.GetBackendHostKeys("10.0.0.3:22")
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(known))
+		assert.Equal(t, (<-trusted).Marshal(), known[0].Marshal())
+	})
+
+	t.Run("AuthorizedKeys", func(t *testing.T) {
+		var keys []AuthorizedKey
+
+		for _, line := range []string{"", `from="10.0.0.0/8",no-port-forwarding `} {
+			pub, _, err := ed25519.GenerateKey(rand.Reader)
+			assert.Nil(t, err)
+
+			sshPub, err := ssh.NewPublicKey(pub)
+			assert.Nil(t, err)
+
+			key, err := ParseAuthorizedKey([]byte(line + string(ssh.MarshalAuthorizedKey(sshPub))))
+			assert.Nil(t, err)
+
+			keys = append(keys, key)
+		}
+
+		known, err := s.GetUserAuthorizedKeys("alice")
+		assert.Nil(t, err)
+		assert.Empty(t, known)
+
+		_, err = s.GetUserAuthorizedKeys("charlie")
+		assert.NotNil(t, err)
+		assert.NotNil(t, s.AddUserAuthorizedKey("charlie", keys[0]))
+
+		assert.Nil(t, s.AddUserAuthorizedKey("alice", keys[0]))
+		assert.Nil(t, s.AddUserAuthorizedKey("alice", keys[1]))
+		assert.NotNil(t, s.AddUserAuthorizedKey("alice", keys[1]))
+		assert.Nil(t, s.AddUserAuthorizedKey("bob", keys[1]))
+
+		known, err = s.GetUserAuthorizedKeys("alice")
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(known))
+		assert.Equal(t, keys[1].Marshal(), known[1].Marshal())
+		assert.Equal(t, []string{`from="10.0.0.0/8"`, "no-port-forwarding"}, known[1].Options)
+
+		assert.NotNil(t, s.RemoveUserAuthorizedKey("alice", "SHA256:unknown"))
+		assert.Nil(t, s.RemoveUserAuthorizedKey("alice", keys[0].Fingerprint()))
+
+		known, err = s.GetUserAuthorizedKeys("alice")
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(known))
+		assert.Equal(t, keys[1].Fingerprint(), known[0].Fingerprint())
+
+		//The keys of a user are not shared with the other ones
+		known, err = s.GetUserAuthorizedKeys("bob")
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(known))
+	})
+
+	t.Run("PasswordHash", func(t *testing.T) {
+		hash, err := s.GetUserPasswordHash("alice")
+		assert.Nil(t, err)
+		assert.Equal(t, "", hash)
+
+		assert.Nil(t, s.SetUserPasswordHash("alice", "$2a$10$first"))
+		assert.Nil(t, s.SetUserPasswordHash("alice", "$2a$10$second"))
+
+		hash, err = s.GetUserPasswordHash("alice")
+		assert.Nil(t, err)
+		assert.Equal(t, "$2a$10$second", hash)
+
+		hash, err = s.GetUserPasswordHash("bob")
+		assert.Nil(t, err)
+		assert.Equal(t, "", hash)
+
+		assert.Nil(t, s.SetUserPasswordHash("alice", ""))
+		assert.Nil(t, s.SetUserPasswordHash("alice", ""))
+
+		hash, err = s.GetUserPasswordHash("alice")
+		assert.Nil(t, err)
+		assert.Equal(t, "", hash)
+
+		assert.Nil(t, s.SetUserPasswordHash("bob", "$2a$10$bob"))
+
+		_, err = s.GetUserPasswordHash("charlie")
+		assert.NotNil(t, err)
+		assert.NotNil(t, s.SetUserPasswordHash("charlie", "$2a$10$charlie"))
+	})
+
+	t.Run("TOTPSecret", func(t *testing.T) {
+		secret, err := s.GetUserTOTPSecret("alice")
+		assert.Nil(t, err)
+		assert.Equal(t, "", secret)
+
+		assert.Nil(t, s.SetUserTOTPSecret("alice", "GEZDGNBVGY3TQOJQ"))
+		assert.Nil(t, s.SetUserTOTPSecret("alice", "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"))
+
+		secret, err = s.GetUserTOTPSecret("alice")
+		assert.Nil(t, err)
+		assert.Equal(t, "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", secret)
+
+		assert.Nil(t, s.SetUserTOTPSecret("alice", ""))
+
+		secret, err = s.GetUserTOTPSecret("alice")
+		assert.Nil(t, err)
+		assert.Equal(t, "", secret)
+
+		assert.Nil(t, s.SetUserTOTPSecret("bob", "GEZDGNBVGY3TQOJQ"))
+
+		_, err = s.GetUserTOTPSecret("charlie")
+		assert.NotNil(t, err)
+		assert.NotNil(t, s.SetUserTOTPSecret("charlie", "GEZDGNBVGY3TQOJQ"))
+	})
+
+	t.Run("DeleteUser", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			username string
+			wantErr  bool
+		}{
+			{name: "test ok", username: "bob", wantErr: false},
+			{name: "ok no user", username: "charlie", wantErr: false},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				err := s.DeleteUser(tt.username)
+				assert.Equal(t, tt.wantErr, err != nil)
+
+				_, err = s.GetUserStatus(tt.username)
+				assert.NotNil(t, err)
+
+				_, err = s.GetRawUserEgressPrivateKey(tt.username)
+				assert.NotNil(t, err)
+
+				_, err = s.GetUserAuthorizedKeys(tt.username)
+				assert.NotNil(t, err)
+
+				_, err = s.GetUserPasswordHash(tt.username)
+				assert.NotNil(t, err)
+
+				_, err = s.GetUserTOTPSecret(tt.username)
+				assert.NotNil(t, err)
+			})
+		}
+	})
+}
+
+func TestSystemStore_DataStore(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "open-bastion-testing")
+
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	s, err := InitStore(testConfig(tempDir, SystemStoreType))
+
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	testDataStore(t, s)
+
+	os.RemoveAll(tempDir)
+}
@@ -0,0 +1,212 @@
+package datastore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"errors"
+	"github.com/open-bastion/open-bastion/internal/config"
+	"golang.org/x/crypto/ssh"
+	"os"
+	"strconv"
+)
+
+// Supported egress key types
+const (
+	RSAKeyType     = "rsa"
+	ECDSAKeyType   = "ecdsa"
+	Ed25519KeyType = "ed25519"
+)
+
+// KeyPolicy lists the egress key types and sizes the users can be created with
+type KeyPolicy struct {
+	DefaultType string
+	//Allowed maps the allowed key types to their allowed sizes in bits, the first size is the default one
+	Allowed map[string][]int
+}
+
+//DefaultKeyPolicy returns the policy used when the configuration does not provide one.
+func DefaultKeyPolicy() KeyPolicy {
+	return KeyPolicy{
+		DefaultType: ECDSAKeyType,
+		Allowed: map[string][]int{
+			RSAKeyType:     {4096},
+			ECDSAKeyType:   {521},
+			Ed25519KeyType: {256},
+		},
+	}
+}
+
+//NewKeyPolicy validates the egress keys configuration and returns the matching KeyPolicy.
+func NewKeyPolicy(c config.EgressKeys) (KeyPolicy, error) {
+	if len(c.Allowed) == 0 {
+		p := DefaultKeyPolicy()
+
+		if c.DefaultType != "" {
+			p.DefaultType = c.DefaultType
+		}
+
+		if _, ok := p.Allowed[p.DefaultType]; !ok {
+			return KeyPolicy{}, errors.New("unknown default egress key type " + p.DefaultType)
+		}
+
+		return p, nil
+	}
+
+	p := KeyPolicy{DefaultType: c.DefaultType, Allowed: make(map[string][]int)}
+
+	for keyType, sizes := range c.Allowed {
+		//ed25519 keys have a single size
+		if keyType == Ed25519KeyType && len(sizes) == 0 {
+			sizes = []int{256}
+		}
+
+		if len(sizes) == 0 {
+			return KeyPolicy{}, errors.New("no egress key size allowed for type " + keyType)
+		}
+
+		for _, bits := range sizes {
+			if err := validateKeySize(keyType, bits); err != nil {
+				return KeyPolicy{}, err
+			}
+		}
+
+		p.Allowed[keyType] = sizes
+	}
+
+	if p.DefaultType == "" {
+		return KeyPolicy{}, errors.New("no default egress key type")
+	}
+
+	if _, ok := p.Allowed[p.DefaultType]; !ok {
+		return KeyPolicy{}, errors.New("the default egress key type " + p.DefaultType + " is not allowed")
+	}
+
+	return p, nil
+}
+
+//Resolve applies the policy to a requested key type and size. An empty type and a zero size select the defaults.
+//It returns an error if the key is not allowed.
+func (p KeyPolicy) Resolve(keyType string, bits int) (string, int, error) {
+	if keyType == "" {
+		keyType = p.DefaultType
+	}
+
+	sizes, ok := p.Allowed[keyType]
+
+	if !ok {
+		return "", 0, errors.New("egress key type " + keyType + " is not allowed")
+	}
+
+	if bits == 0 {
+		return keyType, sizes[0], nil
+	}
+
+	for _, s := range sizes {
+		if s == bits {
+			return keyType, bits, nil
+		}
+	}
+
+	return "", 0, errors.New("egress key size " + strconv.Itoa(bits) + " is not allowed for type " + keyType)
+}
+
+//validateKeySize returns an error if the key type is unknown or if the size cannot be generated for this type.
+func validateKeySize(keyType string, bits int) error {
+	switch keyType {
+	case RSAKeyType:
+		if bits >= 2048 && bits <= 16384 {
+			return nil
+		}
+	case ECDSAKeyType:
+		if bits == 256 || bits == 384 || bits == 521 {
+			return nil
+		}
+	case Ed25519KeyType:
+		if bits == 256 {
+			return nil
+		}
+	default:
+		return errors.New("unknown egress key type " + keyType)
+	}
+
+	return errors.New("invalid egress key size " + strconv.Itoa(bits) + " for type " + keyType)
+}
+
+//newPrivateKey generates a private key of the requested type and size.
+func newPrivateKey(keyType string, bits int) (crypto.Signer, error) {
+	if err := validateKeySize(keyType, bits); err != nil {
+		return nil, err
+	}
+
+	switch keyType {
+	case RSAKeyType:
+		return rsa.GenerateKey(rand.Reader, bits)
+	case ECDSAKeyType:
+		curves := map[int]elliptic.Curve{256: elliptic.P256(), 384: elliptic.P384(), 521: elliptic.P521()}
+		return ecdsa.GenerateKey(curves[bits], rand.Reader)
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+
+	return key, err
+}
+
+//marshalKeyPair returns the private key in the OpenSSH format and the public key in the authorized_keys format.
+func marshalKeyPair(key crypto.Signer, comment string) ([]byte, []byte, error) {
+	block, err := ssh.MarshalPrivateKey(key, comment)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pubKey, err := ssh.NewPublicKey(key.Public())
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	authorizedKey := ssh.MarshalAuthorizedKey(pubKey)
+
+	if comment != "" {
+		//MarshalAuthorizedKey ends the line with a newline, the comment goes before it
+		authorizedKey = append(authorizedKey[:len(authorizedKey)-1], []byte(" "+comment+"\n")...)
+	}
+
+	return pem.EncodeToMemory(block), authorizedKey, nil
+}
+
+//generateKeyPair generates a private key of the requested type and size and atomically writes it to path, readable
+//by its owner only, and its public key to path.pub, like ssh-keygen does.
+func generateKeyPair(keyType string, bits int, path string, comment string) error {
+	key, err := newPrivateKey(keyType, bits)
+
+	if err != nil {
+		return err
+	}
+
+	privateKey, publicKey, err := marshalKeyPair(key, comment)
+
+	if err != nil {
+		return err
+	}
+
+	err = writeFileAtomic(path, privateKey, 0600)
+
+	if err != nil {
+		return err
+	}
+
+	err = writeFileAtomic(path+".pub", publicKey, 0644)
+
+	if err != nil {
+		_ = os.Remove(path)
+		return err
+	}
+
+	return nil
+}
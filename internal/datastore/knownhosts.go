@@ -0,0 +1,169 @@
+package datastore
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+//readKnownHostsFile parses a known_hosts file and returns one entry per host and key.
+//A missing file is considered empty. Hashed hosts and marked lines (@revoked, @cert-authority) are ignored.
+func readKnownHostsFile(path string) ([]BackendHostKey, error) {
+	content, err := ioutil.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, errors.New(ReadKnownHostsErr)
+	}
+
+	var entries []BackendHostKey
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+
+	for scanner.Scan() {
+		marker, hosts, key, _, _, err := ssh.ParseKnownHosts(scanner.Bytes())
+
+		if err != nil || marker != "" {
+			continue
+		}
+
+		for _, h := range hosts {
+			entries = append(entries, BackendHostKey{Address: h, Key: key})
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+//getKnownHostsKeys returns the keys of the known_hosts file matching the backend address (host:port).
+func getKnownHostsKeys(path string, address string) ([]ssh.PublicKey, error) {
+	entries, err := readKnownHostsFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	address = knownhosts.Normalize(address)
+
+	var keys []ssh.PublicKey
+
+	for _, e := range entries {
+		if e.Address == address {
+			keys = append(keys, e.Key)
+		}
+	}
+
+	return keys, nil
+}
+
+//appendKnownHost appends a line for the backend address (host:port) and key to the known_hosts file.
+func appendKnownHost(path string, address string, key ssh.PublicKey) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = f.WriteString(knownhosts.Line([]string{address}, key) + "\n")
+
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+//removeKnownHost atomically rewrites the known_hosts file without the lines matching the backend address (host:port).
+//Other lines, including comments, are kept untouched.
+func removeKnownHost(path string, address string) error {
+	content, err := ioutil.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return errors.New(ReadKnownHostsErr)
+	}
+
+	address = knownhosts.Normalize(address)
+
+	var kept bytes.Buffer
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		_, hosts, _, _, _, err := ssh.ParseKnownHosts(line)
+
+		if err == nil && containsHost(hosts, address) {
+			continue
+		}
+
+		kept.Write(line)
+		kept.WriteByte('\n')
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, kept.Bytes(), 0600)
+}
+
+//containsHost returns true if the address is part of the hosts list.
+func containsHost(hosts []string, address string) bool {
+	for _, h := range hosts {
+		if h == address {
+			return true
+		}
+	}
+
+	return false
+}
+
+//writeFileAtomic writes the data to a temporary file in the same directory then renames it to path,
+//readers thus never see a partially written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+
+	if err != nil {
+		return err
+	}
+
+	tmpPath := f.Name()
+
+	_, err = f.Write(data)
+
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,580 @@
+package datastore
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// MemoryStore represents a datastore kept in memory, it is meant for tests and ephemeral deployments.
+// It is safe for concurrent use.
+type MemoryStore struct {
+	storeType string
+	keyPolicy KeyPolicy
+
+	mu              *sync.RWMutex
+	users           map[string]*memoryUser
+	hostKeys        map[string][]ssh.PublicKey
+	pendingHostKeys map[string]ssh.PublicKey
+}
+
+//memoryUser contains the data of a user of the MemoryStore
+type memoryUser struct {
+	info           UserInfo
+	privateKey     []byte
+	publicKey      []byte
+	authorizedKeys []AuthorizedKey
+	passwordHash   string
+	totpSecret     string
+}
+
+// MemoryFixture is the content of a file seeding a MemoryStore, in JSON or YAML.
+// Users without a private key get one generated with the key policy defaults.
+type MemoryFixture struct {
+	Users           map[string]MemoryFixtureUser `json:"users" yaml:"users"`
+	HostKeys        []MemoryFixtureHostKey       `json:"hostKeys" yaml:"hostKeys"`
+	PendingHostKeys []MemoryFixtureHostKey       `json:"pendingHostKeys" yaml:"pendingHostKeys"`
+}
+
+// MemoryFixtureUser describes a user of a MemoryFixture. PrivateKey is an egress private key in PEM format,
+// AuthorizedKeys are its ingress keys in the authorized_keys format, PasswordHash its argon2id or bcrypt password
+// hash and TOTPSecret its base32 TOTP secret.
+type MemoryFixtureUser struct {
+	Active         bool     `json:"active" yaml:"active"`
+	Admin          bool     `json:"admin" yaml:"admin"`
+	PrivateKey     string   `json:"privateKey" yaml:"privateKey"`
+	AuthorizedKeys []string `json:"authorizedKeys" yaml:"authorizedKeys"`
+	PasswordHash   string   `json:"passwordHash" yaml:"passwordHash"`
+	TOTPSecret     string   `json:"totpSecret" yaml:"totpSecret"`
+}
+
+// MemoryFixtureHostKey describes a backend host key of a MemoryFixture. Key is in the authorized_keys format.
+type MemoryFixtureHostKey struct {
+	Address string `json:"address" yaml:"address"`
+	Key     string `json:"key" yaml:"key"`
+}
+
+//NewMemoryStore returns an empty MemoryStore creating the egress keys according to the key policy.
+func NewMemoryStore(keyPolicy KeyPolicy) MemoryStore {
+	return MemoryStore{
+		storeType:       MemoryStoreType,
+		keyPolicy:       keyPolicy,
+		mu:              &sync.RWMutex{},
+		users:           make(map[string]*memoryUser),
+		hostKeys:        make(map[string][]ssh.PublicKey),
+		pendingHostKeys: make(map[string]ssh.PublicKey),
+	}
+}
+
+//LoadFixtureFile parses a JSON or YAML fixture file, depending on its extension, and loads it in the store.
+func (s MemoryStore) LoadFixtureFile(path string) error {
+	content, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return err
+	}
+
+	var f MemoryFixture
+
+	if ext := filepath.Ext(path); ext == ".yaml" || ext == ".yml" {
+		err = yaml.Unmarshal(content, &f)
+	} else {
+		err = json.Unmarshal(content, &f)
+	}
+
+	if err != nil {
+		return errors.New("invalid fixture file : " + err.Error())
+	}
+
+	return s.LoadFixture(f)
+}
+
+//LoadFixture adds the users and host keys of the fixture to the store.
+func (s MemoryStore) LoadFixture(f MemoryFixture) error {
+	for username, u := range f.Users {
+		if u.PrivateKey == "" {
+			if err := s.AddUser(username, "", 0); err != nil {
+				return err
+			}
+		} else if err := s.addUserWithKey(username, []byte(u.PrivateKey)); err != nil {
+			return errors.New("user " + username + " : " + err.Error())
+		}
+
+		s.mu.Lock()
+		s.users[username].info = UserInfo{Active: u.Active, Admin: u.Admin}
+		s.users[username].passwordHash = u.PasswordHash
+		s.users[username].totpSecret = u.TOTPSecret
+		s.mu.Unlock()
+
+		for _, line := range u.AuthorizedKeys {
+			key, err := ParseAuthorizedKey([]byte(line))
+
+			if err != nil {
+				return errors.New("user " + username + " : " + err.Error())
+			}
+
+			if err := s.AddUserAuthorizedKey(username, key); err != nil {
+				return errors.New("user " + username + " : " + err.Error())
+			}
+		}
+	}
+
+	for _, h := range f.HostKeys {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(h.Key))
+
+		if err != nil {
+			return errors.New("invalid host key for " + h.Address + " : " + err.Error())
+		}
+
+		if err := s.AddBackendHostKey(h.Address, key); err != nil {
+			return err
+		}
+	}
+
+	for _, h := range f.PendingHostKeys {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(h.Key))
+
+		if err != nil {
+			return errors.New("invalid pending host key for " + h.Address + " : " + err.Error())
+		}
+
+		if err := s.SetPendingBackendHostKey(h.Address, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//addUserWithKey adds an active user whose egress private key is provided.
+func (s MemoryStore) addUserWithKey(username string, privateKey []byte) error {
+	if !isUsernameValid(username) {
+		return errors.New(InvalidUsernameErr)
+	}
+
+	signer, err := ssh.ParsePrivateKey(privateKey)
+
+	if err != nil {
+		return err
+	}
+
+	return s.insertUser(username, privateKey, ssh.MarshalAuthorizedKey(signer.PublicKey()))
+}
+
+//insertUser adds an active user if it does not exist yet.
+func (s MemoryStore) insertUser(username string, privateKey []byte, publicKey []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[username]; ok {
+		return errors.New("user " + username + " already exists")
+	}
+
+	s.users[username] = &memoryUser{
+		info:       UserInfo{Active: true},
+		privateKey: privateKey,
+		publicKey:  publicKey,
+	}
+
+	return nil
+}
+
+func (s MemoryStore) GetType() string {
+	return s.storeType
+}
+
+//AddUser add a user to the datastore and create a private key for him. The key type and size must be allowed by
+//the key policy, an empty type and a zero size select its defaults. The user is active and is not an administrator.
+func (s MemoryStore) AddUser(username string, privateKeyType string, bits int) error {
+	if !isUsernameValid(username) {
+		return errors.New(InvalidUsernameErr)
+	}
+
+	privateKeyType, bits, err := s.keyPolicy.Resolve(privateKeyType, bits)
+
+	if err != nil {
+		return err
+	}
+
+	//The key generation can be slow, it is done before locking the store
+	key, err := newPrivateKey(privateKeyType, bits)
+
+	if err != nil {
+		return err
+	}
+
+	privateKey, publicKey, err := marshalKeyPair(key, username+"@open-bastion")
+
+	if err != nil {
+		return err
+	}
+
+	return s.insertUser(username, privateKey, publicKey)
+}
+
+//DeleteUser delete a user if it exists and its egress key
+func (s MemoryStore) DeleteUser(username string) error {
+	if !isUsernameValid(username) {
+		return errors.New(InvalidUsernameErr)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.users, username)
+
+	return nil
+}
+
+//getUser takes a username, validate it and returns a copy of the user data
+func (s MemoryStore) getUser(username string) (memoryUser, error) {
+	if !isUsernameValid(username) {
+		return memoryUser{}, errors.New(InvalidUsernameErr)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, ok := s.users[username]
+
+	if !ok {
+		return memoryUser{}, errors.New("user does not exist")
+	}
+
+	return *u, nil
+}
+
+//GetUserStatus takes a username, validate it and returns the status of the user
+func (s MemoryStore) GetUserStatus(username string) (int, error) {
+	u, err := s.getUser(username)
+
+	if err != nil {
+		return Error, err
+	}
+
+	if u.info.Active {
+		return Active, nil
+	}
+
+	return Inactive, nil
+}
+
+//IsUserAdmin takes a username, validate it and returns whether the user is an administrator of the bastion
+func (s MemoryStore) IsUserAdmin(username string) (bool, error) {
+	u, err := s.getUser(username)
+
+	if err != nil {
+		return false, err
+	}
+
+	return u.info.Admin, nil
+}
+
+//updateUser applies update to an existing user while holding the lock
+func (s MemoryStore) updateUser(username string, update func(u *memoryUser)) error {
+	if !isUsernameValid(username) {
+		return errors.New(InvalidUsernameErr)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[username]
+
+	if !ok {
+		return errors.New("user does not exist")
+	}
+
+	update(u)
+
+	return nil
+}
+
+//SetUserStatus takes a username, validate it and sets the status of the user (Active or Inactive)
+func (s MemoryStore) SetUserStatus(username string, status int) error {
+	if status != Active && status != Inactive {
+		return errors.New("invalid user status")
+	}
+
+	return s.updateUser(username, func(u *memoryUser) {
+		u.info.Active = status == Active
+	})
+}
+
+//SetUserAdmin takes a username, validate it and grants or revokes the administrator rights of the user
+func (s MemoryStore) SetUserAdmin(username string, admin bool) error {
+	return s.updateUser(username, func(u *memoryUser) {
+		u.info.Admin = admin
+	})
+}
+
+//GetRawUserEgressPrivateKey return the user's private key as a string
+func (s MemoryStore) GetRawUserEgressPrivateKey(username string) ([]byte, error) {
+	u, err := s.getUser(username)
+
+	if err != nil {
+		return nil, errors.New(ReadKeyErr)
+	}
+
+	return u.privateKey, nil
+}
+
+//GetRawUserEgressPublicKey return the user's public key as a string
+func (s MemoryStore) GetRawUserEgressPublicKey(username string) ([]byte, error) {
+	u, err := s.getUser(username)
+
+	if err != nil {
+		return nil, errors.New(ReadKeyErr)
+	}
+
+	return u.publicKey, nil
+}
+
+//GetUserEgressPrivateKeySigner returns a signer of the user's egress private key
+func (s MemoryStore) GetUserEgressPrivateKeySigner(username string) (ssh.Signer, error) {
+	key, err := s.GetRawUserEgressPrivateKey(username)
+
+	if err != nil {
+		return nil, err
+	}
+
+	privateSigner, err := ssh.ParsePrivateKey(key)
+
+	if err != nil {
+		return nil, errors.New("user " + username + ": failed to parse private key : " + err.Error())
+	}
+
+	return privateSigner, nil
+}
+
+//GetUserAuthorizedKeys returns the ingress keys allowed to log in as the user.
+//It returns an empty slice if the user has no key.
+func (s MemoryStore) GetUserAuthorizedKeys(username string) ([]AuthorizedKey, error) {
+	u, err := s.getUser(username)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]AuthorizedKey(nil), u.authorizedKeys...), nil
+}
+
+//AddUserAuthorizedKey allows the key to log in as the user
+func (s MemoryStore) AddUserAuthorizedKey(username string, key AuthorizedKey) error {
+	var err error
+
+	updateErr := s.updateUser(username, func(u *memoryUser) {
+		if indexAuthorizedKey(u.authorizedKeys, key.Fingerprint()) >= 0 {
+			err = errors.New("key " + key.Fingerprint() + " is already authorized")
+			return
+		}
+
+		u.authorizedKeys = append(u.authorizedKeys, key)
+	})
+
+	if updateErr != nil {
+		return updateErr
+	}
+
+	return err
+}
+
+//RemoveUserAuthorizedKey removes the key with the fingerprint from the keys allowed to log in as the user
+func (s MemoryStore) RemoveUserAuthorizedKey(username string, fingerprint string) error {
+	var err error
+
+	updateErr := s.updateUser(username, func(u *memoryUser) {
+		i := indexAuthorizedKey(u.authorizedKeys, fingerprint)
+
+		if i < 0 {
+			err = errors.New("key " + fingerprint + " is not authorized")
+			return
+		}
+
+		//The slice may be shared with a copy returned by getUser, never modify it in place
+		keys := append([]AuthorizedKey(nil), u.authorizedKeys[:i]...)
+		u.authorizedKeys = append(keys, u.authorizedKeys[i+1:]...)
+	})
+
+	if updateErr != nil {
+		return updateErr
+	}
+
+	return err
+}
+
+//GetUserPasswordHash returns the password hash of the user.
+//It returns an empty string if password login is not enabled for the user.
+func (s MemoryStore) GetUserPasswordHash(username string) (string, error) {
+	u, err := s.getUser(username)
+
+	if err != nil {
+		return "", err
+	}
+
+	return u.passwordHash, nil
+}
+
+//SetUserPasswordHash replaces the password hash of the user, an empty hash disables password login for the user
+func (s MemoryStore) SetUserPasswordHash(username string, hash string) error {
+	return s.updateUser(username, func(u *memoryUser) {
+		u.passwordHash = hash
+	})
+}
+
+//GetUserTOTPSecret returns the TOTP secret of the user.
+//It returns an empty string if the user has not enrolled.
+func (s MemoryStore) GetUserTOTPSecret(username string) (string, error) {
+	u, err := s.getUser(username)
+
+	if err != nil {
+		return "", err
+	}
+
+	return u.totpSecret, nil
+}
+
+//SetUserTOTPSecret replaces the TOTP secret of the user, an empty secret removes the second factor of the user
+func (s MemoryStore) SetUserTOTPSecret(username string, secret string) error {
+	return s.updateUser(username, func(u *memoryUser) {
+		u.totpSecret = secret
+	})
+}
+
+//GetBackendHostKeys returns the host keys known for the backend address (host:port).
+//It returns an empty slice if the backend is unknown.
+func (s MemoryStore) GetBackendHostKeys(address string) ([]ssh.PublicKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := s.hostKeys[knownhosts.Normalize(address)]
+
+	return append([]ssh.PublicKey(nil), keys...), nil
+}
+
+//AddBackendHostKey records a host key of the backend address (host:port) if it is not already known.
+func (s MemoryStore) AddBackendHostKey(address string, key ssh.PublicKey) error {
+	address = knownhosts.Normalize(address)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, k := range s.hostKeys[address] {
+		if bytes.Equal(k.Marshal(), key.Marshal()) {
+			return nil
+		}
+	}
+
+	s.hostKeys[address] = append(s.hostKeys[address], key)
+
+	return nil
+}
+
+//TrustFirstBackendHostKey records the host key of the backend address (host:port) only if no key is known for the
+//address. It returns the known keys otherwise, the key is then not recorded.
+func (s MemoryStore) TrustFirstBackendHostKey(address string, key ssh.PublicKey) ([]ssh.PublicKey, error) {
+	address = knownhosts.Normalize(address)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if keys := s.hostKeys[address]; len(keys) > 0 {
+		return append([]ssh.PublicKey(nil), keys...), nil
+	}
+
+	s.hostKeys[address] = []ssh.PublicKey{key}
+
+	return nil, nil
+}
+
+//ListBackendHostKeys returns every known host key ordered by address.
+func (s MemoryStore) ListBackendHostKeys() ([]BackendHostKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var entries []BackendHostKey
+
+	for address, keys := range s.hostKeys {
+		for _, k := range keys {
+			entries = append(entries, BackendHostKey{Address: address, Key: k})
+		}
+	}
+
+	sortBackendHostKeys(entries)
+
+	return entries, nil
+}
+
+//GetPendingBackendHostKey returns the host key of the backend address waiting for an administrator approval.
+//It returns nil if there is none.
+func (s MemoryStore) GetPendingBackendHostKey(address string) (ssh.PublicKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.pendingHostKeys[knownhosts.Normalize(address)], nil
+}
+
+//SetPendingBackendHostKey records a host key of the backend address waiting for an administrator approval.
+//It replaces any key already pending for this address.
+func (s MemoryStore) SetPendingBackendHostKey(address string, key ssh.PublicKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pendingHostKeys[knownhosts.Normalize(address)] = key
+
+	return nil
+}
+
+//ListPendingBackendHostKeys returns every host key waiting for an administrator approval ordered by address.
+func (s MemoryStore) ListPendingBackendHostKeys() ([]BackendHostKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var entries []BackendHostKey
+
+	for address, k := range s.pendingHostKeys {
+		entries = append(entries, BackendHostKey{Address: address, Key: k})
+	}
+
+	sortBackendHostKeys(entries)
+
+	return entries, nil
+}
+
+//AcceptPendingBackendHostKey replaces the known host keys of the backend address with its pending key, which must
+//have the SHA256 fingerprint approved by the administrator.
+func (s MemoryStore) AcceptPendingBackendHostKey(address string, fingerprint string) error {
+	normalized := knownhosts.Normalize(address)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.pendingHostKeys[normalized]
+
+	if !ok {
+		return errors.New("no pending host key for " + address)
+	}
+
+	if ssh.FingerprintSHA256(key) != fingerprint {
+		return ErrPendingHostKeyMismatch
+	}
+
+	s.hostKeys[normalized] = []ssh.PublicKey{key}
+	delete(s.pendingHostKeys, normalized)
+
+	return nil
+}
+
+//sortBackendHostKeys orders host keys by address
+func sortBackendHostKeys(entries []BackendHostKey) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Address < entries[j].Address
+	})
+}
@@ -0,0 +1,160 @@
+package datastore
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestMemoryStore_DataStore(t *testing.T) {
+	s, err := InitStore(testConfig("", MemoryStoreType))
+
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	testDataStore(t, s)
+}
+
+func TestMemoryStore_LoadFixtureFile(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "open-bastion-testing")
+
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	defer os.RemoveAll(tempDir)
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	privateKey, _, err := marshalKeyPair(priv, "alice@open-bastion")
+	assert.Nil(t, err)
+
+	hostPub, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	hostKey, err := ssh.NewPublicKey(hostPub)
+	assert.Nil(t, err)
+
+	rawHostKey := string(ssh.MarshalAuthorizedKey(hostKey))
+
+	yamlFixture := "users:\n" +
+		"  alice:\n" +
+		"    active: true\n" +
+		"    admin: true\n" +
+		"    privateKey: |\n"
+
+	for _, line := range strings.Split(strings.TrimSpace(string(privateKey)), "\n") {
+		yamlFixture += "      " + line + "\n"
+	}
+
+	yamlFixture += "  bob:\n" +
+		"    active: false\n" +
+		"hostKeys:\n" +
+		"  - address: 10.0.0.1:22\n" +
+		"    key: " + rawHostKey +
+		"pendingHostKeys:\n" +
+		"  - address: 10.0.0.2:2222\n" +
+		"    key: " + rawHostKey
+
+	jsonFixture := `{"users": {"alice": {"active": true, "admin": true, "privateKey": ` +
+		strconv.Quote(string(privateKey)) + `}, "bob": {"active": false}}, ` +
+		`"hostKeys": [{"address": "10.0.0.1:22", "key": ` + strconv.Quote(rawHostKey) + `}], ` +
+		`"pendingHostKeys": [{"address": "10.0.0.2:2222", "key": ` + strconv.Quote(rawHostKey) + `}]}`
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		wantErr bool
+	}{
+		{name: "test yaml", file: "fixture.yaml", content: yamlFixture, wantErr: false},
+		{name: "test json", file: "fixture.json", content: jsonFixture, wantErr: false},
+		{name: "test invalid", file: "invalid.json", content: yamlFixture, wantErr: true},
+		{name: "test invalid username", file: "username.json", content: `{"users": {"$bob": {}}}`, wantErr: true},
+		{name: "test invalid host key", file: "hostkey.json", content: `{"hostKeys": [{"address": "a", "key": "b"}]}`,
+			wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := tempDir + "/" + tt.file
+			assert.Nil(t, ioutil.WriteFile(path, []byte(tt.content), 0600))
+
+			c := testConfig(tempDir, MemoryStoreType)
+			c.Memory.FixtureFile = path
+
+			s, err := InitStore(c)
+			assert.Equal(t, tt.wantErr, err != nil)
+
+			if tt.wantErr {
+				return
+			}
+
+			admin, err := s.IsUserAdmin("alice")
+			assert.Nil(t, err)
+			assert.True(t, admin)
+
+			raw, err := s.GetRawUserEgressPrivateKey("alice")
+			assert.Nil(t, err)
+			assert.Equal(t, privateKey, raw)
+
+			status, err := s.GetUserStatus("bob")
+			assert.Nil(t, err)
+			assert.Equal(t, Inactive, status)
+
+			_, err = s.GetUserEgressPrivateKeySigner("bob")
+			assert.Nil(t, err)
+
+			known, err := s.GetBackendHostKeys("10.0.0.1:22")
+			assert.Nil(t, err)
+			assert.Equal(t, 1, len(known))
+
+			pending, err := s.GetPendingBackendHostKey("10.0.0.2:2222")
+			assert.Nil(t, err)
+			assert.NotNil(t, pending)
+		})
+	}
+}
+
+func TestMemoryStore_Concurrency(t *testing.T) {
+	s, err := InitStore(testConfig("", MemoryStoreType))
+
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			username := "user" + strconv.Itoa(i)
+
+			assert.Nil(t, s.AddUser(username, "", 0))
+			assert.Nil(t, s.SetUserAdmin(username, true))
+			assert.Nil(t, s.SetUserStatus(username, Inactive))
+
+			_, err := s.GetUserEgressPrivateKeySigner(username)
+			assert.Nil(t, err)
+
+			_, err = s.ListBackendHostKeys()
+			assert.Nil(t, err)
+
+			assert.Nil(t, s.DeleteUser(username))
+		}(i)
+	}
+
+	wg.Wait()
+}
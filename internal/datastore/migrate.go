@@ -0,0 +1,92 @@
+package datastore
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// ImportAuthorizedKeysFile imports the keys of the authorized_keys file shared by every user in the previous
+// versions. Each key is allowed for the user named by its comment, alice or alice@laptop, or for defaultUser if the
+// comment does not name a user of the data store. Nothing is imported if a line is invalid or a key cannot be
+// attributed. The keys already allowed for their user are skipped, it returns the number of imported keys.
+func ImportAuthorizedKeysFile(s DataStore, path string, defaultUser string) (int, error) {
+	if defaultUser != "" {
+		if _, err := s.GetUserStatus(defaultUser); err != nil {
+			return 0, errors.New("unknown default user " + defaultUser + " : " + err.Error())
+		}
+	}
+
+	content, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return 0, errors.New("could not read the authorized keys file : " + err.Error())
+	}
+
+	keys := make(map[string][]AuthorizedKey)
+	var unattributed []string
+
+	for i, line := range bytes.Split(content, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		k, err := ParseAuthorizedKey(line)
+
+		if err != nil {
+			return 0, errors.New("line " + strconv.Itoa(i+1) + " : " + err.Error())
+		}
+
+		username := k.Comment
+
+		if at := strings.IndexByte(username, '@'); at >= 0 {
+			username = username[:at]
+		}
+
+		if _, err := s.GetUserStatus(username); username == "" || err != nil {
+			username = defaultUser
+		}
+
+		if username == "" {
+			unattributed = append(unattributed, "line "+strconv.Itoa(i+1)+" ("+k.Fingerprint()+")")
+			continue
+		}
+
+		keys[username] = append(keys[username], k)
+	}
+
+	if len(unattributed) > 0 {
+		return 0, errors.New("the comment of these keys does not name a user, set it or give a default user : " +
+			strings.Join(unattributed, ", "))
+	}
+
+	imported := 0
+
+	for username, userKeys := range keys {
+		known, err := s.GetUserAuthorizedKeys(username)
+
+		if err != nil {
+			return imported, err
+		}
+
+		for _, k := range userKeys {
+			if indexAuthorizedKey(known, k.Fingerprint()) >= 0 {
+				continue
+			}
+
+			if err := s.AddUserAuthorizedKey(username, k); err != nil {
+				return imported, errors.New("could not import key " + k.Fingerprint() + " of " + username + " : " +
+					err.Error())
+			}
+
+			known = append(known, k)
+			imported++
+		}
+	}
+
+	return imported, nil
+}
@@ -0,0 +1,85 @@
+package datastore
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+//newTestAuthorizedKeyLine returns an authorized_keys line of a new key with the comment
+func newTestAuthorizedKeyLine(t *testing.T, comment string) string {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	key, err := ssh.NewPublicKey(pub)
+	assert.Nil(t, err)
+
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))) + " " + comment
+}
+
+func TestImportAuthorizedKeysFile(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "open-bastion-testing")
+
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	t.Cleanup(func() { _ = os.RemoveAll(tempDir) })
+
+	s := NewMemoryStore(DefaultKeyPolicy())
+	assert.Nil(t, s.AddUser("alice", "", 0))
+	assert.Nil(t, s.AddUser("bob", "", 0))
+
+	path := tempDir + "/authorized_keys"
+	content := "# shared keys\n" + newTestAuthorizedKeyLine(t, "alice@laptop") + "\n\n" +
+		`no-pty ` + newTestAuthorizedKeyLine(t, "bob") + "\n" + newTestAuthorizedKeyLine(t, "ci-runner") + "\n"
+	assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0600))
+
+	tests := []struct {
+		name         string
+		defaultUser  string
+		wantErr      bool
+		wantImported int
+		wantAlice    int
+		wantBob      int
+	}{
+		{name: "test key without user", defaultUser: "", wantErr: true, wantImported: 0, wantAlice: 0, wantBob: 0},
+		{name: "test unknown default user", defaultUser: "charlie", wantErr: true, wantImported: 0, wantAlice: 0,
+			wantBob: 0},
+		{name: "test import", defaultUser: "bob", wantErr: false, wantImported: 3, wantAlice: 1, wantBob: 2},
+		{name: "test imported again", defaultUser: "bob", wantErr: false, wantImported: 0, wantAlice: 1,
+			wantBob: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			imported, err := ImportAuthorizedKeysFile(s, path, tt.defaultUser)
+
+			if tt.wantErr {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+			}
+
+			assert.Equal(t, tt.wantImported, imported)
+
+			keys, err := s.GetUserAuthorizedKeys("alice")
+			assert.Nil(t, err)
+			assert.Len(t, keys, tt.wantAlice)
+
+			keys, err = s.GetUserAuthorizedKeys("bob")
+			assert.Nil(t, err)
+			assert.Len(t, keys, tt.wantBob)
+		})
+	}
+
+	//The options of the keys are kept
+	keys, err := s.GetUserAuthorizedKeys("bob")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"no-pty"}, keys[0].Options)
+}
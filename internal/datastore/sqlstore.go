@@ -0,0 +1,750 @@
+package datastore
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"github.com/open-bastion/open-bastion/internal/config"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"time"
+
+	// SQLite is the reference driver of the SQL store, other database/sql drivers can be added the same way
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLStore represents a datastore backed by a SQL database
+type SQLStore struct {
+	db        *sql.DB
+	storeType string
+	keyPolicy KeyPolicy
+}
+
+//sqlMigrations contains the statements upgrading the schema, the schema version is the index of the last applied
+//migration plus one. Applied migrations must never be modified, add a new one instead.
+var sqlMigrations = [][]string{
+	//1: users and their egress keys
+	{
+		`CREATE TABLE users (
+			name TEXT NOT NULL PRIMARY KEY,
+			active BOOLEAN NOT NULL,
+			admin BOOLEAN NOT NULL
+		)`,
+		`CREATE TABLE egress_keys (
+			username TEXT NOT NULL PRIMARY KEY REFERENCES users(name),
+			private_key BLOB NOT NULL,
+			public_key BLOB NOT NULL
+		)`,
+	},
+	//2: backend host keys, known and waiting for an administrator approval
+	{
+		`CREATE TABLE backend_host_keys (
+			address TEXT NOT NULL,
+			host_key BLOB NOT NULL,
+			PRIMARY KEY (address, host_key)
+		)`,
+		`CREATE TABLE pending_backend_host_keys (
+			address TEXT NOT NULL PRIMARY KEY,
+			host_key BLOB NOT NULL
+		)`,
+	},
+	//3: ingress keys allowed to log in as each user
+	{
+		`CREATE TABLE authorized_keys (
+			username TEXT NOT NULL REFERENCES users(name),
+			fingerprint TEXT NOT NULL,
+			authorized_key TEXT NOT NULL,
+			PRIMARY KEY (username, fingerprint)
+		)`,
+	},
+	//4: password hashes of the users allowed to log in with a password
+	{
+		`CREATE TABLE passwords (
+			username TEXT NOT NULL PRIMARY KEY REFERENCES users(name),
+			hash TEXT NOT NULL
+		)`,
+	},
+	//5: TOTP secrets of the users who enrolled a second factor
+	{
+		`CREATE TABLE totp_secrets (
+			username TEXT NOT NULL PRIMARY KEY REFERENCES users(name),
+			secret TEXT NOT NULL
+		)`,
+	},
+}
+
+//NewSQLStore opens the database described by the configuration and migrates its schema to the latest version.
+func NewSQLStore(c config.Config) (SQLStore, error) {
+	keyPolicy, err := NewKeyPolicy(c.EgressKeys)
+
+	if err != nil {
+		return SQLStore{}, err
+	}
+
+	db, err := sql.Open(c.SQL.Driver, c.SQL.DataSource)
+
+	if err != nil {
+		return SQLStore{}, errors.New("cannot open data store : " + err.Error())
+	}
+
+	db.SetMaxOpenConns(c.SQL.MaxOpenConns)
+	db.SetMaxIdleConns(c.SQL.MaxIdleConns)
+	db.SetConnMaxLifetime(time.Duration(c.SQL.ConnMaxLifetime) * time.Second)
+
+	store := SQLStore{db: db, storeType: SQLStoreType, keyPolicy: keyPolicy}
+
+	err = store.migrate()
+
+	if err != nil {
+		_ = db.Close()
+		return SQLStore{}, errors.New("cannot migrate data store : " + err.Error())
+	}
+
+	return store, nil
+}
+
+//SchemaVersion returns the version of the database schema.
+func (s SQLStore) SchemaVersion() (int, error) {
+	var version int
+
+	err := s.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+
+	return version, err
+}
+
+//migrate applies the migrations newer than the database schema version, each one in its own transaction.
+func (s SQLStore) migrate() error {
+	_, err := s.db.Exec("CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)")
+
+	if err != nil {
+		return err
+	}
+
+	version, err := s.SchemaVersion()
+
+	if err != nil {
+		return err
+	}
+
+	if version > len(sqlMigrations) {
+		return errors.New("the database schema is newer than this version of open-bastion")
+	}
+
+	for v := version; v < len(sqlMigrations); v++ {
+		tx, err := s.db.Begin()
+
+		if err != nil {
+			return err
+		}
+
+		for _, statement := range sqlMigrations[v] {
+			if _, err := tx.Exec(statement); err != nil {
+				_ = tx.Rollback()
+				return err
+			}
+		}
+
+		if _, err := tx.Exec("INSERT INTO schema_version (version) VALUES (?)", v+1); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//Close closes the database.
+func (s SQLStore) Close() error {
+	return s.db.Close()
+}
+
+func (s SQLStore) GetType() string {
+	return s.storeType
+}
+
+//AddUser add a user to the datastore and create a private key for him. The key type and size must be allowed by
+//the key policy, an empty type and a zero size select its defaults. The user is active and is not an administrator.
+func (s SQLStore) AddUser(username string, privateKeyType string, bits int) error {
+	if !isUsernameValid(username) {
+		return errors.New(InvalidUsernameErr)
+	}
+
+	privateKeyType, bits, err := s.keyPolicy.Resolve(privateKeyType, bits)
+
+	if err != nil {
+		return err
+	}
+
+	key, err := newPrivateKey(privateKeyType, bits)
+
+	if err != nil {
+		return err
+	}
+
+	privateKey, publicKey, err := marshalKeyPair(key, username+"@open-bastion")
+
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	var count int
+
+	err = tx.QueryRow("SELECT COUNT(*) FROM users WHERE name = ?", username).Scan(&count)
+
+	if err == nil && count > 0 {
+		err = errors.New("user " + username + " already exists")
+	}
+
+	if err == nil {
+		_, err = tx.Exec("INSERT INTO users (name, active, admin) VALUES (?, ?, ?)", username, true, false)
+	}
+
+	if err == nil {
+		_, err = tx.Exec("INSERT INTO egress_keys (username, private_key, public_key) VALUES (?, ?, ?)",
+			username, privateKey, publicKey)
+	}
+
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//DeleteUser delete a user if it exists and its egress key
+func (s SQLStore) DeleteUser(username string) error {
+	if !isUsernameValid(username) {
+		return errors.New(InvalidUsernameErr)
+	}
+
+	tx, err := s.db.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM egress_keys WHERE username = ?", username)
+
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM authorized_keys WHERE username = ?", username)
+	}
+
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM passwords WHERE username = ?", username)
+	}
+
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM totp_secrets WHERE username = ?", username)
+	}
+
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM users WHERE name = ?", username)
+	}
+
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//getUserInfo takes a username, validate it and returns its status and admin flag
+func (s SQLStore) getUserInfo(username string) (UserInfo, error) {
+	if !isUsernameValid(username) {
+		return UserInfo{}, errors.New(InvalidUsernameErr)
+	}
+
+	var ui UserInfo
+
+	err := s.db.QueryRow("SELECT active, admin FROM users WHERE name = ?", username).Scan(&ui.Active, &ui.Admin)
+
+	if err == sql.ErrNoRows {
+		return UserInfo{}, errors.New("user does not exist")
+	}
+
+	if err != nil {
+		return UserInfo{}, err
+	}
+
+	return ui, nil
+}
+
+//GetUserStatus takes a username, validate it and returns the status of the user
+func (s SQLStore) GetUserStatus(username string) (int, error) {
+	ui, err := s.getUserInfo(username)
+
+	if err != nil {
+		return Error, err
+	}
+
+	if ui.Active {
+		return Active, nil
+	}
+
+	return Inactive, nil
+}
+
+//IsUserAdmin takes a username, validate it and returns whether the user is an administrator of the bastion
+func (s SQLStore) IsUserAdmin(username string) (bool, error) {
+	ui, err := s.getUserInfo(username)
+
+	if err != nil {
+		return false, err
+	}
+
+	return ui.Admin, nil
+}
+
+//updateUser runs an update statement on an existing user
+func (s SQLStore) updateUser(username string, query string, args ...interface{}) error {
+	if !isUsernameValid(username) {
+		return errors.New(InvalidUsernameErr)
+	}
+
+	res, err := s.db.Exec(query, append(args, username)...)
+
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return errors.New("user does not exist")
+	}
+
+	return nil
+}
+
+//SetUserStatus takes a username, validate it and sets the status of the user (Active or Inactive)
+func (s SQLStore) SetUserStatus(username string, status int) error {
+	if status != Active && status != Inactive {
+		return errors.New("invalid user status")
+	}
+
+	return s.updateUser(username, "UPDATE users SET active = ? WHERE name = ?", status == Active)
+}
+
+//SetUserAdmin takes a username, validate it and grants or revokes the administrator rights of the user
+func (s SQLStore) SetUserAdmin(username string, admin bool) error {
+	return s.updateUser(username, "UPDATE users SET admin = ? WHERE name = ?", admin)
+}
+
+//getEgressKey returns the requested column of the user's egress key
+func (s SQLStore) getEgressKey(username string, column string) ([]byte, error) {
+	if !isUsernameValid(username) {
+		return nil, errors.New(InvalidUsernameErr)
+	}
+
+	var key []byte
+
+	//column is never user provided
+	err := s.db.QueryRow("SELECT "+column+" FROM egress_keys WHERE username = ?", username).Scan(&key)
+
+	if err != nil {
+		return nil, errors.New(ReadKeyErr)
+	}
+
+	return key, nil
+}
+
+//GetRawUserEgressPrivateKey return the user's private key as a string
+func (s SQLStore) GetRawUserEgressPrivateKey(username string) ([]byte, error) {
+	return s.getEgressKey(username, "private_key")
+}
+
+//GetRawUserEgressPublicKey return the user's public key as a string
+func (s SQLStore) GetRawUserEgressPublicKey(username string) ([]byte, error) {
+	return s.getEgressKey(username, "public_key")
+}
+
+//GetUserEgressPrivateKeySigner returns a signer of the user's egress private key
+func (s SQLStore) GetUserEgressPrivateKeySigner(username string) (ssh.Signer, error) {
+	key, err := s.GetRawUserEgressPrivateKey(username)
+
+	if err != nil {
+		return nil, err
+	}
+
+	privateSigner, err := ssh.ParsePrivateKey(key)
+
+	if err != nil {
+		return nil, errors.New("user " + username + ": failed to parse private key : " + err.Error())
+	}
+
+	return privateSigner, nil
+}
+
+//GetUserAuthorizedKeys returns the ingress keys allowed to log in as the user.
+//It returns an empty slice if the user has no key.
+func (s SQLStore) GetUserAuthorizedKeys(username string) ([]AuthorizedKey, error) {
+	if _, err := s.getUserInfo(username); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query("SELECT authorized_key FROM authorized_keys WHERE username = ? ORDER BY rowid",
+		username)
+
+	if err != nil {
+		return nil, errors.New(ReadKeyErr)
+	}
+
+	defer rows.Close()
+
+	var keys []AuthorizedKey
+
+	for rows.Next() {
+		var line string
+
+		if err := rows.Scan(&line); err != nil {
+			return nil, err
+		}
+
+		key, err := ParseAuthorizedKey([]byte(line))
+
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+//AddUserAuthorizedKey allows the key to log in as the user
+func (s SQLStore) AddUserAuthorizedKey(username string, key AuthorizedKey) error {
+	keys, err := s.GetUserAuthorizedKeys(username)
+
+	if err != nil {
+		return err
+	}
+
+	if indexAuthorizedKey(keys, key.Fingerprint()) >= 0 {
+		return errors.New("key " + key.Fingerprint() + " is already authorized")
+	}
+
+	_, err = s.db.Exec("INSERT INTO authorized_keys (username, fingerprint, authorized_key) VALUES (?, ?, ?)",
+		username, key.Fingerprint(), string(key.Marshal()))
+
+	return err
+}
+
+//RemoveUserAuthorizedKey removes the key with the fingerprint from the keys allowed to log in as the user
+func (s SQLStore) RemoveUserAuthorizedKey(username string, fingerprint string) error {
+	if _, err := s.getUserInfo(username); err != nil {
+		return err
+	}
+
+	res, err := s.db.Exec("DELETE FROM authorized_keys WHERE username = ? AND fingerprint = ?", username,
+		fingerprint)
+
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return errors.New("key " + fingerprint + " is not authorized")
+	}
+
+	return nil
+}
+
+//getUserSecret returns the secret column of the user in the table, an empty string if there is none
+func (s SQLStore) getUserSecret(username string, table string, column string) (string, error) {
+	if _, err := s.getUserInfo(username); err != nil {
+		return "", err
+	}
+
+	var secret string
+
+	//table and column are never user provided
+	err := s.db.QueryRow("SELECT "+column+" FROM "+table+" WHERE username = ?", username).Scan(&secret)
+
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return secret, err
+}
+
+//setUserSecret replaces the secret column of the user in the table, the row is removed if the secret is empty
+func (s SQLStore) setUserSecret(username string, table string, column string, secret string) error {
+	if _, err := s.getUserInfo(username); err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM "+table+" WHERE username = ?", username)
+
+	if err == nil && secret != "" {
+		_, err = tx.Exec("INSERT INTO "+table+" (username, "+column+") VALUES (?, ?)", username, secret)
+	}
+
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//GetUserPasswordHash returns the password hash of the user.
+//It returns an empty string if password login is not enabled for the user.
+func (s SQLStore) GetUserPasswordHash(username string) (string, error) {
+	return s.getUserSecret(username, "passwords", "hash")
+}
+
+//SetUserPasswordHash replaces the password hash of the user, an empty hash disables password login for the user
+func (s SQLStore) SetUserPasswordHash(username string, hash string) error {
+	return s.setUserSecret(username, "passwords", "hash", hash)
+}
+
+//GetUserTOTPSecret returns the TOTP secret of the user.
+//It returns an empty string if the user has not enrolled.
+func (s SQLStore) GetUserTOTPSecret(username string) (string, error) {
+	return s.getUserSecret(username, "totp_secrets", "secret")
+}
+
+//SetUserTOTPSecret replaces the TOTP secret of the user, an empty secret removes the second factor of the user
+func (s SQLStore) SetUserTOTPSecret(username string, secret string) error {
+	return s.setUserSecret(username, "totp_secrets", "secret", secret)
+}
+
+//queryHostKeys runs a query returning address and host key columns
+func (s SQLStore) queryHostKeys(query string, args ...interface{}) ([]BackendHostKey, error) {
+	rows, err := s.db.Query(query, args...)
+
+	if err != nil {
+		return nil, errors.New(ReadKnownHostsErr)
+	}
+
+	defer rows.Close()
+
+	var keys []BackendHostKey
+
+	for rows.Next() {
+		var address string
+		var rawKey []byte
+
+		if err := rows.Scan(&address, &rawKey); err != nil {
+			return nil, err
+		}
+
+		key, err := ssh.ParsePublicKey(rawKey)
+
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, BackendHostKey{Address: address, Key: key})
+	}
+
+	return keys, rows.Err()
+}
+
+//hostKeysOnly extracts the keys of a BackendHostKey slice
+func hostKeysOnly(entries []BackendHostKey) []ssh.PublicKey {
+	var keys []ssh.PublicKey
+
+	for _, e := range entries {
+		keys = append(keys, e.Key)
+	}
+
+	return keys
+}
+
+//GetBackendHostKeys returns the host keys known for the backend address (host:port).
+//It returns an empty slice if the backend is unknown.
+func (s SQLStore) GetBackendHostKeys(address string) ([]ssh.PublicKey, error) {
+	entries, err := s.queryHostKeys("SELECT address, host_key FROM backend_host_keys WHERE address = ?",
+		knownhosts.Normalize(address))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return hostKeysOnly(entries), nil
+}
+
+//AddBackendHostKey records a host key of the backend address (host:port) if it is not already known.
+func (s SQLStore) AddBackendHostKey(address string, key ssh.PublicKey) error {
+	keys, err := s.GetBackendHostKeys(address)
+
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		if bytes.Equal(k.Marshal(), key.Marshal()) {
+			return nil
+		}
+	}
+
+	_, err = s.db.Exec("INSERT INTO backend_host_keys (address, host_key) VALUES (?, ?)",
+		knownhosts.Normalize(address), key.Marshal())
+
+	return err
+}
+
+//TrustFirstBackendHostKey records the host key of the backend address (host:port) only if no key is known for the
+//address. It returns the known keys otherwise, the key is then not recorded. The check and the insert are a single
+//statement, two first connections cannot both record their key.
+func (s SQLStore) TrustFirstBackendHostKey(address string, key ssh.PublicKey) ([]ssh.PublicKey, error) {
+	address = knownhosts.Normalize(address)
+
+	tx, err := s.db.Begin()
+
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := tx.Exec(`INSERT INTO backend_host_keys (address, host_key) SELECT ?, ?
+		WHERE NOT EXISTS (SELECT 1 FROM backend_host_keys WHERE address = ?)`, address, key.Marshal(), address)
+
+	var n int64
+
+	if err == nil {
+		n, err = res.RowsAffected()
+	}
+
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil || n == 1 {
+		return nil, err
+	}
+
+	return s.GetBackendHostKeys(address)
+}
+
+//ListBackendHostKeys returns every known host key.
+func (s SQLStore) ListBackendHostKeys() ([]BackendHostKey, error) {
+	return s.queryHostKeys("SELECT address, host_key FROM backend_host_keys ORDER BY address")
+}
+
+//GetPendingBackendHostKey returns the host key of the backend address waiting for an administrator approval.
+//It returns nil if there is none.
+func (s SQLStore) GetPendingBackendHostKey(address string) (ssh.PublicKey, error) {
+	entries, err := s.queryHostKeys("SELECT address, host_key FROM pending_backend_host_keys WHERE address = ?",
+		knownhosts.Normalize(address))
+
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+
+	return entries[0].Key, nil
+}
+
+//SetPendingBackendHostKey records a host key of the backend address waiting for an administrator approval.
+//It replaces any key already pending for this address.
+func (s SQLStore) SetPendingBackendHostKey(address string, key ssh.PublicKey) error {
+	address = knownhosts.Normalize(address)
+
+	tx, err := s.db.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM pending_backend_host_keys WHERE address = ?", address)
+
+	if err == nil {
+		_, err = tx.Exec("INSERT INTO pending_backend_host_keys (address, host_key) VALUES (?, ?)",
+			address, key.Marshal())
+	}
+
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//ListPendingBackendHostKeys returns every host key waiting for an administrator approval.
+func (s SQLStore) ListPendingBackendHostKeys() ([]BackendHostKey, error) {
+	return s.queryHostKeys("SELECT address, host_key FROM pending_backend_host_keys ORDER BY address")
+}
+
+//AcceptPendingBackendHostKey replaces the known host keys of the backend address with its pending key, which must
+//have the SHA256 fingerprint approved by the administrator. The pending key is only removed if it is still the
+//checked one, so that a key recorded in the meantime is not accepted.
+func (s SQLStore) AcceptPendingBackendHostKey(address string, fingerprint string) error {
+	key, err := s.GetPendingBackendHostKey(address)
+
+	if err != nil {
+		return err
+	}
+
+	if key == nil {
+		return errors.New("no pending host key for " + address)
+	}
+
+	if ssh.FingerprintSHA256(key) != fingerprint {
+		return ErrPendingHostKeyMismatch
+	}
+
+	address = knownhosts.Normalize(address)
+
+	tx, err := s.db.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec("DELETE FROM pending_backend_host_keys WHERE address = ? AND host_key = ?", address,
+		key.Marshal())
+
+	if err == nil {
+		var n int64
+
+		if n, err = res.RowsAffected(); err == nil && n != 1 {
+			err = ErrPendingHostKeyMismatch
+		}
+	}
+
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM backend_host_keys WHERE address = ?", address)
+	}
+
+	if err == nil {
+		_, err = tx.Exec("INSERT INTO backend_host_keys (address, host_key) VALUES (?, ?)", address, key.Marshal())
+	}
+
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,77 @@
+package datastore
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLStore_DataStore(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "open-bastion-testing")
+
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	s, err := InitStore(testConfig(tempDir, SQLStoreType))
+
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	testDataStore(t, s)
+
+	assert.Nil(t, s.(SQLStore).Close())
+
+	os.RemoveAll(tempDir)
+}
+
+func TestSQLStore_Migrate(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "open-bastion-testing")
+
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	c := testConfig(tempDir, SQLStoreType)
+
+	s, err := NewSQLStore(c)
+
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	version, err := s.SchemaVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, len(sqlMigrations), version)
+
+	assert.Nil(t, s.AddUser("alice", "", 0))
+	assert.Nil(t, s.Close())
+
+	//Opening an up to date database must not reapply the migrations nor lose data
+	s, err = NewSQLStore(c)
+
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	version, err = s.SchemaVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, len(sqlMigrations), version)
+
+	status, err := s.GetUserStatus("alice")
+	assert.Nil(t, err)
+	assert.Equal(t, Active, status)
+
+	//A database created by a newer version must be refused
+	_, err = s.db.Exec("INSERT INTO schema_version (version) VALUES (?)", len(sqlMigrations)+1)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Close())
+
+	_, err = NewSQLStore(c)
+	assert.NotNil(t, err)
+
+	os.RemoveAll(tempDir)
+}
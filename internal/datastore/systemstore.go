@@ -1,75 +1,95 @@
 package datastore
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	logger "github.com/open-bastion/open-bastion/internal/logger"
 	"golang.org/x/crypto/ssh"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"regexp"
+	"sync"
 )
 
 const (
 	InvalidUsernameErr = "invalid username"
 	ReadKeyErr         = "cannot read key"
+	ReadKnownHostsErr  = "cannot read known hosts"
 
 	egressDirectory    = "/egress-keys/"
+	authorizedKeysFile = "/authorized_keys"
+	passwordFile       = "/password"
+	totpFile           = "/totp"
 )
 
+//hostKeysMu serializes the changes of the known and pending host keys. A backend is trusted on first use only once
+//and an accepted key must be the one the administrator approved.
+var hostKeysMu sync.Mutex
+
 // SystemStore represents the datastore storage
 type SystemStore struct {
-	path      string
-	storeType string
+	path           string
+	knownHostsPath string
+	storeType      string
+	keyPolicy      KeyPolicy
 }
 
 func (s SystemStore) GetType() string {
 	return s.storeType
 }
 
-//AddUser add a user to the datastore and create a private key for him
-func (s SystemStore) AddUser(username string, privateKeyType string) error {
+//AddUser add a user to the datastore and create a private key for him. The key type and size must be allowed by
+//the key policy, an empty type and a zero size select its defaults. The user is active and is not an administrator.
+func (s SystemStore) AddUser(username string, privateKeyType string, bits int) error {
 	//Should we validate the username when we parse the input and considere it valid from then on
 	// or should we parse it in this function?
 	if !isUsernameValid(username) {
 		return errors.New(InvalidUsernameErr)
 	}
 
-	us, err := s.GetUserStatus(username)
+	privateKeyType, bits, err := s.keyPolicy.Resolve(privateKeyType, bits)
 
 	if err != nil {
 		return err
 	}
 
-	if us != Invalid {
+	userDir := s.path + "/" + username + "/"
+
+	if _, err := os.Stat(userDir); !os.IsNotExist(err) {
 		return errors.New("user " + username + " already exists")
 	}
 
-	userKeyPath := s.path + username + egressDirectory + username
+	err = os.MkdirAll(userDir+egressDirectory, 0700)
 
-	//TODO should we output the public key on stdout when creating the user?
-	if privateKeyType == "ecdsa" {
-		cmd := exec.Command("ssh-keygen", "-t", "ecdsa", "-b", "521", "-f", userKeyPath)
-		err = cmd.Run()
-	} else if privateKeyType == "rsa" {
-		cmd := exec.Command("ssh-keygen", "-t", "rsa", "-b", "4096", "-f", userKeyPath)
-		err = cmd.Run()
-	} else {
-		return errors.New("unknown key type")
+	if err != nil {
+		return err
 	}
 
+	err = s.createUser(username, privateKeyType, bits)
+
 	if err != nil {
+		if err := os.RemoveAll(userDir); err != nil {
+			logger.WarnfWithErr(err, "could not clean up the partially created user %v", username)
+		}
+
 		return err
 	}
 
-	err = os.Chmod(userKeyPath, 0600)
+	return nil
+}
+
+//createUser generates the egress key of a user and writes its info file.
+func (s SystemStore) createUser(username string, privateKeyType string, bits int) error {
+	userKeyPath := s.path + "/" + username + egressDirectory + username
+
+	err := generateKeyPair(privateKeyType, bits, userKeyPath, username+"@open-bastion")
 
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return s.writeUserInfo(username, UserInfo{Active: true})
 }
 
 //DeleteUser delete a user if it exists and its associated files
@@ -89,50 +109,113 @@ func (s SystemStore) DeleteUser(username string) error {
 
 //GetUserStatus takes a username, validate it and returns the status of the user
 func (s SystemStore) GetUserStatus(username string) (int, error) {
+	ui, err := s.getUserInfo(username)
+
+	if err != nil {
+		return Error, err
+	}
+
+	if ui.Active {
+		return Active, nil
+	}
+
+	return Inactive, nil
+}
+
+//IsUserAdmin takes a username, validate it and returns whether the user is an administrator of the bastion
+func (s SystemStore) IsUserAdmin(username string) (bool, error) {
+	ui, err := s.getUserInfo(username)
+
+	if err != nil {
+		return false, err
+	}
+
+	return ui.Admin, nil
+}
+
+//getUserInfo takes a username, validate it and returns the content of its info.json file
+func (s SystemStore) getUserInfo(username string) (UserInfo, error) {
 	if !isUsernameValid(username) {
-		return Error, errors.New(InvalidUsernameErr)
+		return UserInfo{}, errors.New(InvalidUsernameErr)
 	}
 
 	userDir := s.path + "/" + username + "/"
 
-	if _, err := os.Stat(userDir); !os.IsNotExist(err) {
-		f, err := os.Open(userDir + "info.json")
+	if _, err := os.Stat(userDir); os.IsNotExist(err) {
+		return UserInfo{}, errors.New("user does not exist")
+	}
 
-		if err != nil {
-			return Error, err
-		}
+	f, err := os.Open(userDir + "info.json")
 
-		byteContent, err := ioutil.ReadAll(f)
+	if err != nil {
+		return UserInfo{}, err
+	}
 
-		defer func() {
-			if err := f.Close(); err != nil {
-				logger.WarnfWithErr(err, "could not close info file for user %v", username)
-			}
-		}()
+	byteContent, err := ioutil.ReadAll(f)
 
-		if err != nil {
-			return Error, err
+	defer func() {
+		if err := f.Close(); err != nil {
+			logger.WarnfWithErr(err, "could not close info file for user %v", username)
 		}
+	}()
 
-		if !json.Valid(byteContent) {
-			return Error, errors.New("configuration file is not a valid JSON file")
-		}
+	if err != nil {
+		return UserInfo{}, err
+	}
 
-		var ui UserInfo
-		err = json.Unmarshal([]byte(byteContent), &ui)
+	if !json.Valid(byteContent) {
+		return UserInfo{}, errors.New("configuration file is not a valid JSON file")
+	}
 
-		if err != nil {
-			return Error, err
-		}
+	var ui UserInfo
+	err = json.Unmarshal(byteContent, &ui)
 
-		if ui.Active {
-			return Active, nil
-		}
+	if err != nil {
+		return UserInfo{}, err
+	}
+
+	return ui, nil
+}
+
+//SetUserStatus takes a username, validate it and sets the status of the user (Active or Inactive)
+func (s SystemStore) SetUserStatus(username string, status int) error {
+	if status != Active && status != Inactive {
+		return errors.New("invalid user status")
+	}
+
+	ui, err := s.getUserInfo(username)
 
-		return Inactive, nil
+	if err != nil {
+		return err
+	}
+
+	ui.Active = status == Active
+
+	return s.writeUserInfo(username, ui)
+}
+
+//SetUserAdmin takes a username, validate it and grants or revokes the administrator rights of the user
+func (s SystemStore) SetUserAdmin(username string, admin bool) error {
+	ui, err := s.getUserInfo(username)
+
+	if err != nil {
+		return err
 	}
 
-	return Error, errors.New("user does not exist")
+	ui.Admin = admin
+
+	return s.writeUserInfo(username, ui)
+}
+
+//writeUserInfo atomically replaces the info.json file of the user
+func (s SystemStore) writeUserInfo(username string, ui UserInfo) error {
+	content, err := json.Marshal(ui)
+
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.path+"/"+username+"/info.json", content, 0600)
 }
 
 //GetRawUserEgressPrivateKey return the user's private key as a string
@@ -206,6 +289,122 @@ func (s SystemStore) GetRawUserEgressPublicKey(username string) ([]byte, error)
 	return key, nil
 }
 
+//GetUserAuthorizedKeys returns the ingress keys allowed to log in as the user, read from its authorized_keys file.
+//It returns an empty slice if the user has no key.
+func (s SystemStore) GetUserAuthorizedKeys(username string) ([]AuthorizedKey, error) {
+	if _, err := s.getUserInfo(username); err != nil {
+		return nil, err
+	}
+
+	content, err := ioutil.ReadFile(s.path + "/" + username + authorizedKeysFile)
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, errors.New(ReadKeyErr)
+	}
+
+	return parseAuthorizedKeys(content), nil
+}
+
+//AddUserAuthorizedKey allows the key to log in as the user by appending it to its authorized_keys file
+func (s SystemStore) AddUserAuthorizedKey(username string, key AuthorizedKey) error {
+	keys, err := s.GetUserAuthorizedKeys(username)
+
+	if err != nil {
+		return err
+	}
+
+	if indexAuthorizedKey(keys, key.Fingerprint()) >= 0 {
+		return errors.New("key " + key.Fingerprint() + " is already authorized")
+	}
+
+	keys = append(keys, key)
+
+	return writeFileAtomic(s.path+"/"+username+authorizedKeysFile, marshalAuthorizedKeys(keys), 0600)
+}
+
+//RemoveUserAuthorizedKey removes the key with the fingerprint from the user's authorized_keys file
+func (s SystemStore) RemoveUserAuthorizedKey(username string, fingerprint string) error {
+	keys, err := s.GetUserAuthorizedKeys(username)
+
+	if err != nil {
+		return err
+	}
+
+	i := indexAuthorizedKey(keys, fingerprint)
+
+	if i < 0 {
+		return errors.New("key " + fingerprint + " is not authorized")
+	}
+
+	keys = append(keys[:i], keys[i+1:]...)
+
+	return writeFileAtomic(s.path+"/"+username+authorizedKeysFile, marshalAuthorizedKeys(keys), 0600)
+}
+
+//readUserSecret returns the trimmed content of a file of the user, an empty string if the file does not exist
+func (s SystemStore) readUserSecret(username string, file string) (string, error) {
+	if _, err := s.getUserInfo(username); err != nil {
+		return "", err
+	}
+
+	content, err := ioutil.ReadFile(s.path + "/" + username + file)
+
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(bytes.TrimSpace(content)), nil
+}
+
+//writeUserSecret replaces the content of a file of the user, the file is removed if the content is empty
+func (s SystemStore) writeUserSecret(username string, file string, content string) error {
+	if _, err := s.getUserInfo(username); err != nil {
+		return err
+	}
+
+	path := s.path + "/" + username + file
+
+	if content == "" {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		return nil
+	}
+
+	return writeFileAtomic(path, []byte(content+"\n"), 0600)
+}
+
+//GetUserPasswordHash returns the password hash of the user, read from its password file.
+//It returns an empty string if password login is not enabled for the user.
+func (s SystemStore) GetUserPasswordHash(username string) (string, error) {
+	return s.readUserSecret(username, passwordFile)
+}
+
+//SetUserPasswordHash replaces the password hash of the user, an empty hash disables password login for the user
+func (s SystemStore) SetUserPasswordHash(username string, hash string) error {
+	return s.writeUserSecret(username, passwordFile, hash)
+}
+
+//GetUserTOTPSecret returns the TOTP secret of the user, read from its totp file.
+//It returns an empty string if the user has not enrolled.
+func (s SystemStore) GetUserTOTPSecret(username string) (string, error) {
+	return s.readUserSecret(username, totpFile)
+}
+
+//SetUserTOTPSecret replaces the TOTP secret of the user, an empty secret removes the second factor of the user
+func (s SystemStore) SetUserTOTPSecret(username string, secret string) error {
+	return s.writeUserSecret(username, totpFile, secret)
+}
+
 //isUsernameValid validate a username with the regex [a-z_][a-z0-9_-]*[$]?
 func isUsernameValid(username string) bool {
 	//The man recommends the following rules for a username
@@ -219,3 +418,122 @@ func isUsernameValid(username string) bool {
 
 	return len(reg.Find([]byte(username))) == len(username)
 }
+
+//GetBackendHostKeys returns the host keys recorded in the known_hosts file for the backend address (host:port).
+//It returns an empty slice if the backend is unknown.
+func (s SystemStore) GetBackendHostKeys(address string) ([]ssh.PublicKey, error) {
+	return getKnownHostsKeys(s.knownHostsPath, address)
+}
+
+//AddBackendHostKey appends the host key of the backend address (host:port) to the known_hosts file
+//if it is not already known.
+func (s SystemStore) AddBackendHostKey(address string, key ssh.PublicKey) error {
+	hostKeysMu.Lock()
+	defer hostKeysMu.Unlock()
+
+	keys, err := s.GetBackendHostKeys(address)
+
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		if bytes.Equal(k.Marshal(), key.Marshal()) {
+			return nil
+		}
+	}
+
+	return appendKnownHost(s.knownHostsPath, address, key)
+}
+
+//TrustFirstBackendHostKey appends the host key of the backend address (host:port) to the known_hosts file only if
+//no key is known for the address. It returns the known keys otherwise, the key is then not recorded.
+func (s SystemStore) TrustFirstBackendHostKey(address string, key ssh.PublicKey) ([]ssh.PublicKey, error) {
+	hostKeysMu.Lock()
+	defer hostKeysMu.Unlock()
+
+	keys, err := s.GetBackendHostKeys(address)
+
+	if err != nil || len(keys) > 0 {
+		return keys, err
+	}
+
+	return nil, appendKnownHost(s.knownHostsPath, address, key)
+}
+
+//ListBackendHostKeys returns every host key recorded in the known_hosts file.
+func (s SystemStore) ListBackendHostKeys() ([]BackendHostKey, error) {
+	return readKnownHostsFile(s.knownHostsPath)
+}
+
+//GetPendingBackendHostKey returns the host key of the backend address waiting for an administrator approval.
+//It returns nil if there is none.
+func (s SystemStore) GetPendingBackendHostKey(address string) (ssh.PublicKey, error) {
+	keys, err := getKnownHostsKeys(s.pendingHostsPath(), address)
+
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+
+	return keys[0], nil
+}
+
+//SetPendingBackendHostKey records a host key of the backend address waiting for an administrator approval.
+//It replaces any key already pending for this address.
+func (s SystemStore) SetPendingBackendHostKey(address string, key ssh.PublicKey) error {
+	hostKeysMu.Lock()
+	defer hostKeysMu.Unlock()
+
+	err := removeKnownHost(s.pendingHostsPath(), address)
+
+	if err != nil {
+		return err
+	}
+
+	return appendKnownHost(s.pendingHostsPath(), address, key)
+}
+
+//ListPendingBackendHostKeys returns every host key waiting for an administrator approval.
+func (s SystemStore) ListPendingBackendHostKeys() ([]BackendHostKey, error) {
+	return readKnownHostsFile(s.pendingHostsPath())
+}
+
+//AcceptPendingBackendHostKey replaces the known host keys of the backend address with its pending key, which must
+//have the SHA256 fingerprint approved by the administrator.
+func (s SystemStore) AcceptPendingBackendHostKey(address string, fingerprint string) error {
+	hostKeysMu.Lock()
+	defer hostKeysMu.Unlock()
+
+	key, err := s.GetPendingBackendHostKey(address)
+
+	if err != nil {
+		return err
+	}
+
+	if key == nil {
+		return errors.New("no pending host key for " + address)
+	}
+
+	if ssh.FingerprintSHA256(key) != fingerprint {
+		return ErrPendingHostKeyMismatch
+	}
+
+	err = removeKnownHost(s.knownHostsPath, address)
+
+	if err != nil {
+		return err
+	}
+
+	err = appendKnownHost(s.knownHostsPath, address, key)
+
+	if err != nil {
+		return err
+	}
+
+	return removeKnownHost(s.pendingHostsPath(), address)
+}
+
+//pendingHostsPath returns the path of the file containing the host keys waiting for an administrator approval.
+func (s SystemStore) pendingHostsPath() string {
+	return s.knownHostsPath + ".pending"
+}
@@ -1,11 +1,15 @@
 package datastore
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
 	"io/ioutil"
 	"os"
 	"testing"
 
+	"github.com/open-bastion/open-bastion/internal/config"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
 )
 
 func TestStore_GetUserStatus(t *testing.T) {
@@ -476,3 +480,281 @@ func TestStore_DeleteUser(t *testing.T) {
 
 	os.RemoveAll(tempDir)
 }
+
+func TestStore_BackendHostKeys(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "open-bastion-testing")
+
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	s := SystemStore{
+		path:           tempDir,
+		knownHostsPath: tempDir + "/known_hosts",
+	}
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	key, err := ssh.NewPublicKey(pub)
+
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	//No file yet
+	keys, err := s.GetBackendHostKeys("10.0.0.1:22")
+	assert.Nil(t, err)
+	assert.Empty(t, keys)
+
+	assert.Nil(t, s.AddBackendHostKey("10.0.0.1:22", key))
+	//Adding the same key twice should not duplicate it
+	assert.Nil(t, s.AddBackendHostKey("10.0.0.1:22", key))
+
+	keys, err = s.GetBackendHostKeys("10.0.0.1:22")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(keys))
+	assert.Equal(t, key.Marshal(), keys[0].Marshal())
+
+	//Another port is another backend
+	keys, err = s.GetBackendHostKeys("10.0.0.1:2222")
+	assert.Nil(t, err)
+	assert.Empty(t, keys)
+
+	os.RemoveAll(tempDir)
+}
+
+func TestStore_AcceptPendingBackendHostKey(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "open-bastion-testing")
+
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	s := SystemStore{
+		path:           tempDir,
+		knownHostsPath: tempDir + "/known_hosts",
+	}
+
+	var keys []ssh.PublicKey
+
+	for i := 0; i < 2; i++ {
+		pub, _, err := ed25519.GenerateKey(rand.Reader)
+
+		if err != nil {
+			assert.Fail(t, err.Error())
+		}
+
+		key, err := ssh.NewPublicKey(pub)
+
+		if err != nil {
+			assert.Fail(t, err.Error())
+		}
+
+		keys = append(keys, key)
+	}
+
+	assert.Nil(t, s.AddBackendHostKey("10.0.0.1:22", keys[0]))
+	assert.Nil(t, s.AddBackendHostKey("10.0.0.2:2222", keys[0]))
+
+	//Nothing to accept
+	assert.NotNil(t, s.AcceptPendingBackendHostKey("10.0.0.1:22", ssh.FingerprintSHA256(keys[1])))
+
+	assert.Nil(t, s.SetPendingBackendHostKey("10.0.0.1:22", keys[1]))
+
+	pending, err := s.ListPendingBackendHostKeys()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(pending))
+	assert.Equal(t, "10.0.0.1", pending[0].Address)
+
+	//Only the approved key is accepted
+	assert.Equal(t, ErrPendingHostKeyMismatch, s.AcceptPendingBackendHostKey("10.0.0.1:22",
+		ssh.FingerprintSHA256(keys[0])))
+
+	assert.Nil(t, s.AcceptPendingBackendHostKey("10.0.0.1:22", ssh.FingerprintSHA256(keys[1])))
+
+	known, err := s.GetBackendHostKeys("10.0.0.1:22")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(known))
+	assert.Equal(t, keys[1].Marshal(), known[0].Marshal())
+
+	//Other backends are untouched
+	known, err = s.GetBackendHostKeys("10.0.0.2:2222")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(known))
+	assert.Equal(t, keys[0].Marshal(), known[0].Marshal())
+
+	key, err := s.GetPendingBackendHostKey("10.0.0.1:22")
+	assert.Nil(t, err)
+	assert.Nil(t, key)
+
+	os.RemoveAll(tempDir)
+}
+
+func TestStore_SetUserStatusAndAdmin(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "open-bastion-testing")
+
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	err = os.MkdirAll(tempDir+"/alice", 0777)
+
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	err = ioutil.WriteFile(tempDir+"/alice/info.json", []byte("{\"active\":true}"), 0600)
+
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	s := SystemStore{
+		path: tempDir,
+	}
+
+	assert.Nil(t, s.SetUserStatus("alice", Inactive))
+
+	status, err := s.GetUserStatus("alice")
+	assert.Nil(t, err)
+	assert.Equal(t, Inactive, status)
+
+	admin, err := s.IsUserAdmin("alice")
+	assert.Nil(t, err)
+	assert.False(t, admin)
+
+	assert.Nil(t, s.SetUserAdmin("alice", true))
+
+	admin, err = s.IsUserAdmin("alice")
+	assert.Nil(t, err)
+	assert.True(t, admin)
+
+	//Setting the admin flag must not change the status
+	status, err = s.GetUserStatus("alice")
+	assert.Nil(t, err)
+	assert.Equal(t, Inactive, status)
+
+	assert.NotNil(t, s.SetUserStatus("alice", Invalid))
+	assert.NotNil(t, s.SetUserStatus("charlie", Active))
+
+	os.RemoveAll(tempDir)
+}
+
+func TestStore_AddUser(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "open-bastion-testing")
+
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		keyType  string
+		bits     int
+		wantErr  bool
+	}{
+		{
+			name:     "rsa",
+			username: "alice",
+			keyType:  RSAKeyType,
+			wantErr:  false,
+		},
+		{
+			name:     "ecdsa",
+			username: "bob",
+			keyType:  ECDSAKeyType,
+			wantErr:  false,
+		},
+		{
+			name:     "default type",
+			username: "diane",
+			wantErr:  false,
+		},
+		{
+			name:     "rsa 2048",
+			username: "eve",
+			keyType:  RSAKeyType,
+			bits:     2048,
+			wantErr:  false,
+		},
+		{
+			name:     "ecdsa size not allowed",
+			username: "frank",
+			keyType:  ECDSAKeyType,
+			bits:     384,
+			wantErr:  true,
+		},
+		{
+			name:     "type not allowed",
+			username: "frank",
+			keyType:  Ed25519KeyType,
+			wantErr:  true,
+		},
+		{
+			name:     "unknown key type",
+			username: "frank",
+			keyType:  "dsa",
+			wantErr:  true,
+		},
+		{
+			name:     "already exists",
+			username: "alice",
+			keyType:  RSAKeyType,
+			wantErr:  true,
+		},
+	}
+
+	keyPolicy, err := NewKeyPolicy(config.EgressKeys{
+		DefaultType: ECDSAKeyType,
+		Allowed: map[string][]int{
+			RSAKeyType:   {4096, 2048},
+			ECDSAKeyType: {521},
+		},
+	})
+
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := SystemStore{
+				path:      tempDir,
+				keyPolicy: keyPolicy,
+			}
+
+			err := s.AddUser(tt.username, tt.keyType, tt.bits)
+
+			assert.Equal(t, tt.wantErr, err != nil)
+
+			if tt.wantErr {
+				return
+			}
+
+			status, err := s.GetUserStatus(tt.username)
+			assert.Nil(t, err)
+			assert.Equal(t, Active, status)
+
+			signer, err := s.GetUserEgressPrivateKeySigner(tt.username)
+			assert.Nil(t, err)
+
+			rawPubKey, err := s.GetRawUserEgressPublicKey(tt.username)
+			assert.Nil(t, err)
+
+			pubKey, _, _, _, err := ssh.ParseAuthorizedKey(rawPubKey)
+			assert.Nil(t, err)
+			assert.Equal(t, signer.PublicKey().Marshal(), pubKey.Marshal())
+
+			info, err := os.Stat(tempDir + "/" + tt.username + "/egress-keys/" + tt.username)
+			assert.Nil(t, err)
+			assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		})
+	}
+
+	os.RemoveAll(tempDir)
+}
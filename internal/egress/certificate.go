@@ -0,0 +1,96 @@
+package egress
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"github.com/open-bastion/open-bastion/internal/config"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"time"
+)
+
+//certificateClockSkew is how long before their creation the egress certificates are valid, the backend clocks may
+//be slightly late
+const certificateClockSkew = time.Minute
+
+// CertificateAuthority signs the ephemeral keys the bastion logs in the backends with. The backends trust its public
+// key instead of the egress key of every user.
+type CertificateAuthority struct {
+	Signer   ssh.Signer
+	Validity time.Duration
+}
+
+// NewCertificateAuthority returns the CertificateAuthority of the configured private key, or nil if the egress
+// certificates are disabled and the static egress keys of the users are used.
+func NewCertificateAuthority(c config.Config) (*CertificateAuthority, error) {
+	if c.EgressCA.PrivateKeyFile == "" {
+		return nil, nil
+	}
+
+	raw, err := ioutil.ReadFile(c.EgressCA.PrivateKeyFile)
+
+	if err != nil {
+		return nil, errors.New("could not read the egress CA private key : " + err.Error())
+	}
+
+	signer, err := ssh.ParsePrivateKey(raw)
+
+	if err != nil {
+		return nil, errors.New("could not parse the egress CA private key : " + err.Error())
+	}
+
+	return &CertificateAuthority{
+		Signer:   signer,
+		Validity: time.Duration(c.EgressCA.Validity) * time.Second,
+	}, nil
+}
+
+// PublicKey returns the public key the backends must trust, see TrustedUserCAKeys in sshd_config(5)
+func (ca *CertificateAuthority) PublicKey() ssh.PublicKey {
+	return ca.Signer.PublicKey()
+}
+
+// SessionSigner generates an ephemeral key and returns a signer of its certificate, valid for the backend user only.
+// The key ID contains the bastion user and session so that the backend logs can be traced back to them.
+func (ca *CertificateAuthority) SessionSigner(username, sessionID, backendUser string) (ssh.Signer, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	keySigner, err := ssh.NewSignerFromKey(priv)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var serial [8]byte
+
+	if _, err := rand.Read(serial[:]); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	cert := &ssh.Certificate{
+		Key:             keySigner.PublicKey(),
+		Serial:          binary.BigEndian.Uint64(serial[:]),
+		CertType:        ssh.UserCert,
+		KeyId:           "open-bastion:" + username + ":" + sessionID,
+		ValidPrincipals: []string{backendUser},
+		ValidAfter:      uint64(now.Add(-certificateClockSkew).Unix()),
+		ValidBefore:     uint64(now.Add(ca.Validity).Unix()),
+		Permissions: ssh.Permissions{
+			Extensions: map[string]string{"permit-pty": ""},
+		},
+	}
+
+	if err := cert.SignCert(rand.Reader, ca.Signer); err != nil {
+		return nil, errors.New("could not sign the egress certificate : " + err.Error())
+	}
+
+	return ssh.NewCertSigner(cert, keySigner)
+}
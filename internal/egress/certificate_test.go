@@ -0,0 +1,47 @@
+package egress
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCertificateAuthority_SessionSigner(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	caSigner, err := ssh.NewSignerFromKey(priv)
+	assert.Nil(t, err)
+
+	ca := &CertificateAuthority{Signer: caSigner, Validity: 5 * time.Minute}
+
+	signer, err := ca.SessionSigner("alice", "0123456789abcdef", "deploy")
+	assert.Nil(t, err)
+
+	cert, ok := signer.PublicKey().(*ssh.Certificate)
+	assert.True(t, ok)
+
+	checker := ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			return ssh.FingerprintSHA256(auth) == ssh.FingerprintSHA256(caSigner.PublicKey())
+		},
+	}
+
+	assert.Nil(t, checker.CheckCert("deploy", cert))
+	assert.NotNil(t, checker.CheckCert("root", cert))
+	assert.Equal(t, "open-bastion:alice:0123456789abcdef", cert.KeyId)
+
+	//The certificate expires with the validity of the CA
+	checker.Clock = func() time.Time { return time.Now().Add(6 * time.Minute) }
+	assert.NotNil(t, checker.CheckCert("deploy", cert))
+
+	//Every session gets its own key
+	other, err := ca.SessionSigner("alice", "0123456789abcdef", "deploy")
+	assert.Nil(t, err)
+	assert.NotEqual(t, ssh.FingerprintSHA256(cert.Key),
+		ssh.FingerprintSHA256(other.PublicKey().(*ssh.Certificate).Key))
+}
@@ -3,10 +3,15 @@ package egress
 import (
 	"context"
 	"errors"
+	"fmt"
+	"github.com/open-bastion/open-bastion/internal/auth"
+	"github.com/open-bastion/open-bastion/internal/config"
 	"github.com/open-bastion/open-bastion/internal/datastore"
 	"github.com/open-bastion/open-bastion/internal/obclient"
+	"github.com/open-bastion/open-bastion/internal/recording"
 	"io"
 	"strconv"
+	"sync"
 	"time"
 
 	"golang.org/x/crypto/ssh"
@@ -14,20 +19,57 @@ import (
 	"github.com/open-bastion/open-bastion/internal/logger"
 )
 
+//The terminal requested to the backend if the client did not request one
+const (
+	defaultTerm       = "xterm"
+	defaultTermWidth  = 80
+	defaultTermHeight = 40
+)
+
+//recordingBuffer is the number of reads buffered between the session and its recording
+const recordingBuffer = 64
+
 //EstablishSSHConnection takes a client connected with SSH to the bastion and tries to get its information from the
-//datastore to establish a connection to the backend.
-func EstablishSSHConnection(ctx context.Context, client *obclient.Client, dataStore datastore.DataStore) {
+//datastore to establish a connection to the backend. The client logs in the backend with an ephemeral certificate of
+//the CA if there is one, with its egress key otherwise. The backend session is recorded as configured by rec.
+func EstablishSSHConnection(ctx context.Context, client *obclient.Client, dataStore datastore.DataStore,
+	hostKeys *HostKeyChecker, ca *CertificateAuthority, policy auth.Policy, rec config.Recording) {
 	var err error
 	//The user has already been validated during the ssh handshake and should be good
-	//We use the connecting user to parse its key
-	client.SSHKey, err = dataStore.GetUserEgressPrivateKeySigner(client.User)
+	if ca != nil {
+		client.SSHKey, err = ca.SessionSigner(client.User, client.SessionID, client.BackendUser)
+	} else {
+		//We use the connecting user to parse its key
+		client.SSHKey, err = dataStore.GetUserEgressPrivateKeySigner(client.User)
+	}
 
 	logger.UpdateClientLogCtx(ctx, client)
 
 	if err != nil {
 		_, _ = client.SshCommChan.Write([]byte("error accessing credentials"))
 
-		logger.ErrorWithCtxWithErr(ctx, err, "authenticated user could not access his egress private key")
+		logger.ErrorWithCtxWithErr(ctx, err, "authenticated user could not access his egress credentials")
+	}
+
+	err = policy.CheckBackendUser(client.BackendUser)
+
+	if err != nil {
+		_, _ = client.SshCommChan.Write([]byte("Error : " + err.Error() + "\n"))
+
+		logger.WarnWithCtxWithErr(ctx, err, "backend connection refused by the authentication policy")
+		logger.AuditfWithCtx(ctx, logger.AuditSession, "backend connection refused: %v", err)
+		return
+	}
+
+	//Restricted keys can only reach the backends listed by their permitopen options
+	err = auth.PermitsOpen(client.SSHConnexion.Permissions, client.BackendHost, client.BackendPort)
+
+	if err != nil {
+		_, _ = client.SshCommChan.Write([]byte("Error : " + err.Error() + "\n"))
+
+		logger.WarnWithCtxWithErr(ctx, err, "backend connection refused by the key options")
+		logger.AuditfWithCtx(ctx, logger.AuditSession, "backend connection refused: %v", err)
+		return
 	}
 
 	if client.BackendTimeout > 0 {
@@ -40,7 +82,7 @@ func EstablishSSHConnection(ctx context.Context, client *obclient.Client, dataSt
 		ctx = timeoutCtx
 	}
 
-	err = DialBackend(ctx, client)
+	err = DialBackend(ctx, client, hostKeys, rec)
 
 	if err != nil {
 		logger.WarnWithCtxWithErr(ctx, err, "error dialing backend")
@@ -49,9 +91,9 @@ func EstablishSSHConnection(ctx context.Context, client *obclient.Client, dataSt
 
 //DialBackend takes the context and a client pointer with a already established SSH connection. It then tries to
 //connect to an SSH backend with the client information
-func DialBackend(ctx context.Context, client *obclient.Client) error {
+func DialBackend(ctx context.Context, client *obclient.Client, hostKeys *HostKeyChecker, rec config.Recording) error {
 	// jump to new connection
-	err := DialSSH(ctx, client)
+	err := DialSSH(ctx, client, hostKeys, rec)
 
 	if err != nil {
 		errStr := "Error : " + err.Error() + "\n"
@@ -62,9 +104,11 @@ func DialBackend(ctx context.Context, client *obclient.Client) error {
 	return nil
 }
 
-// DialSSH contact the destination backend server
-//func DialSSH(ctx context.Context, channel ssh.Channel, bc BackendConn, signer ssh.Signer) error {
-func DialSSH(ctx context.Context, client *obclient.Client) error {
+// DialSSH contact the destination backend server, the backend host key is verified by the HostKeyChecker. The
+// bastion logs in the backend as client.BackendUser, the user named by the backend command or the bastion user if the
+// command names none. The session output, and its input if rec.RecordInput is set, is recorded in the rec.Directory asciicast file of the
+// session, the session is refused if it cannot be recorded.
+func DialSSH(ctx context.Context, client *obclient.Client, hostKeys *HostKeyChecker, rec config.Recording) error {
 	pcb := func() (string, error) {
 		return "", nil
 	}
@@ -83,11 +127,9 @@ func DialSSH(ctx context.Context, client *obclient.Client) error {
 	}
 
 	config := &ssh.ClientConfig{
-		User: client.User,
-		Auth: authMethods,
-		//TODO This should be replaced by HostKeyCallBack and use a mechanism to
-		//verify the backend host key
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		User:            client.BackendUser,
+		Auth:            authMethods,
+		HostKeyCallback: hostKeys.Callback(ctx),
 		Timeout:         timeout,
 	}
 
@@ -96,12 +138,50 @@ func DialSSH(ctx context.Context, client *obclient.Client) error {
 		return errors.New("error dialing backend : " + err.Error())
 	}
 
+	logger.AuditfWithCtx(ctx, logger.AuditSession, "backend connection opened to %v@%v:%v", client.BackendUser,
+		client.BackendHost, client.BackendPort)
+
 	defer func() {
 		if err := sshConn.Close(); err != nil {
 			logger.WarnWithCtxWithErr(ctx, err, "error closing SSH connection")
 		}
 	}()
 
+	term, width, height := client.Term, client.TermWidth, client.TermHeight
+
+	if term == "" || width <= 0 || height <= 0 {
+		term, width, height = defaultTerm, defaultTermWidth, defaultTermHeight
+	}
+
+	backend := fmt.Sprintf("%v@%v:%v", client.BackendUser, client.BackendHost, client.BackendPort)
+
+	sessionRec, err := recording.Create(rec.Directory, recording.Header{
+		Width:   width,
+		Height:  height,
+		Title:   client.User + " on " + backend,
+		Env:     map[string]string{"TERM": term},
+		User:    client.User,
+		Session: client.SessionID,
+		Backend: backend,
+	})
+
+	if err != nil {
+		return errors.New("could not record the session : " + err.Error())
+	}
+
+	logger.AuditfWithCtx(ctx, logger.AuditSession, "session recorded to %v", sessionRec.Path)
+
+	//The output is recorded until the backend session is closed
+	var recorders sync.WaitGroup
+
+	defer func() {
+		recorders.Wait()
+
+		if err := sessionRec.Close(); err != nil {
+			logger.WarnWithCtxWithErr(ctx, err, "error closing the session recording")
+		}
+	}()
+
 	// Each ClientConn can support multiple interactive sessions,
 	// represented by a Session.
 	session, err := sshConn.NewSession()
@@ -132,8 +212,20 @@ func DialSSH(ctx context.Context, client *obclient.Client) error {
 		}
 	}()
 
+	var input chan []byte
+
+	if rec.RecordInput {
+		input = make(chan []byte, recordingBuffer)
+
+		go record(ctx, sessionRec, recording.Input, input)
+	}
+
 	go func() {
-		_, _ = copy(stdin, client.SshCommChan, nil)
+		_, _ = copy(stdin, client.SshCommChan, input)
+
+		if input != nil {
+			close(input)
+		}
 	}()
 
 	stdout, err := session.StdoutPipe()
@@ -141,8 +233,20 @@ func DialSSH(ctx context.Context, client *obclient.Client) error {
 		return errors.New("Error getting session stdout : " + err.Error())
 	}
 
+	output := make(chan []byte, recordingBuffer)
+
+	recorders.Add(2)
+
 	go func() {
-		_, _ = copy(client.SshCommChan, stdout, nil)
+		defer recorders.Done()
+		record(ctx, sessionRec, recording.Output, output)
+	}()
+
+	go func() {
+		defer recorders.Done()
+
+		_, _ = copy(client.SshCommChan, stdout, output)
+		close(output)
 	}()
 
 	// Set up terminal modes
@@ -152,7 +256,7 @@ func DialSSH(ctx context.Context, client *obclient.Client) error {
 	}
 
 	// Request pseudo terminal
-	if err := session.RequestPty("xterm", 80, 40, modes); err != nil {
+	if err := session.RequestPty(term, height, width, modes); err != nil {
 		return errors.New("error requesting pseudo terminal : " + err.Error())
 	}
 
@@ -170,11 +274,22 @@ func DialSSH(ctx context.Context, client *obclient.Client) error {
 			logger.Debugf("failed to start command: %v", err)
 		}
 	}
+
+	//The last output is sent to the client before its channel is closed
+	recorders.Wait()
 	logger.InfoWithCtx(ctx, "client disconnected")
 
 	return nil
 }
 
+//record records the data received on log until it is closed, the input received after the end of the recording is
+//ignored
+func record(ctx context.Context, r *recording.Recording, kind string, log <-chan []byte) {
+	if err := r.Record(kind, log); err != nil && err != recording.ErrClosed {
+		logger.ErrorWithCtxWithErr(ctx, err, "error recording the session")
+	}
+}
+
 // copy is a reimplementation of the io.Copy function but takes a chan where it also write
 // the data copied
 //
@@ -190,14 +305,16 @@ func DialSSH(ctx context.Context, client *obclient.Client) error {
 // the copy is implemented by calling src.WriteTo(dst).
 // Otherwise, if dst implements the ReaderFrom interface,
 // the copy is implemented by calling dst.ReadFrom(src).
-func copy(dst io.Writer, src io.Reader, log chan []byte) (written int64, err error) {
+// These shortcuts are not taken if log is not nil, a copy of
+// each read is sent on log before being written to dst.
+func copy(dst io.Writer, src io.Reader, log chan<- []byte) (written int64, err error) {
 	// If the reader has a WriteTo method, use it to do the copy.
 	// Avoids an allocation and a copy.
-	if wt, ok := src.(io.WriterTo); ok {
+	if wt, ok := src.(io.WriterTo); ok && log == nil {
 		return wt.WriteTo(dst)
 	}
 	// Similarly, if the writer has a ReadFrom method, use it to do the copy.
-	if rt, ok := dst.(io.ReaderFrom); ok {
+	if rt, ok := dst.(io.ReaderFrom); ok && log == nil {
 		return rt.ReadFrom(src)
 	}
 
@@ -214,8 +331,9 @@ func copy(dst io.Writer, src io.Reader, log chan []byte) (written int64, err err
 	for {
 		nr, er := src.Read(buf)
 
-		if log != nil {
-			log <- buf[0:nr]
+		// buf is reused by the next read
+		if log != nil && nr > 0 {
+			log <- append([]byte(nil), buf[0:nr]...)
 		}
 
 		if nr > 0 {
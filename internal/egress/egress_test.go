@@ -0,0 +1,86 @@
+package egress
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/open-bastion/open-bastion/internal/config"
+	"github.com/open-bastion/open-bastion/internal/datastore"
+	"github.com/open-bastion/open-bastion/internal/obclient"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+//newTestBackend starts an SSH server refusing every login and returns its port and the users it was asked to log in
+func newTestBackend(t *testing.T) (int, <-chan string) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	signer, err := ssh.NewSignerFromKey(priv)
+	assert.Nil(t, err)
+
+	users := make(chan string, 16)
+	refuse := func(c ssh.ConnMetadata) error {
+		users <- c.User()
+		return errors.New("login refused")
+	}
+
+	serverConfig := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, _ []byte) (*ssh.Permissions, error) {
+			return nil, refuse(c)
+		},
+		PublicKeyCallback: func(c ssh.ConnMetadata, _ ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, refuse(c)
+		},
+	}
+	serverConfig.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			go func() {
+				_, _, _, _ = ssh.NewServerConn(conn, serverConfig)
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port, users
+}
+
+func TestDialSSH_BackendUser(t *testing.T) {
+	port, users := newTestBackend(t)
+	hostKeys := &HostKeyChecker{DataStore: datastore.NewMemoryStore(datastore.DefaultKeyPolicy()),
+		Policy: config.HostKeyPolicyTOFU}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	//The bastion logs in as the user of the backend command, not as the user connected to the bastion
+	client := &obclient.Client{User: "alice", BackendUser: "deploy", BackendHost: "127.0.0.1", BackendPort: port}
+
+	err := DialSSH(ctx, client, hostKeys, config.Recording{})
+	assert.NotNil(t, err)
+
+	select {
+	case user := <-users:
+		assert.Equal(t, "deploy", user)
+	default:
+		assert.Fail(t, "the backend was not asked to log in")
+	}
+}
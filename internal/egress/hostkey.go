@@ -0,0 +1,112 @@
+package egress
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/open-bastion/open-bastion/internal/config"
+	"github.com/open-bastion/open-bastion/internal/datastore"
+	"github.com/open-bastion/open-bastion/internal/logger"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"strings"
+	"time"
+)
+
+// HostKeyChecker verifies the host keys presented by the backends according to the configured policy.
+type HostKeyChecker struct {
+	DataStore datastore.DataStore
+	Policy    string
+	//TOFUDeadline is the moment after which the tofu-then-strict policy stops trusting new backends
+	TOFUDeadline time.Time
+}
+
+// NewHostKeyChecker returns a HostKeyChecker using the host key policy of the configuration.
+// The tofu-then-strict learning period starts when this function is called.
+func NewHostKeyChecker(dataStore datastore.DataStore, c config.Config) *HostKeyChecker {
+	return &HostKeyChecker{
+		DataStore:    dataStore,
+		Policy:       c.HostKeyPolicy,
+		TOFUDeadline: time.Now().Add(time.Duration(c.HostKeyTOFUPeriod) * time.Second),
+	}
+}
+
+//trustsFirstUse returns true if the host key of an unknown backend can be recorded without an administrator.
+func (h *HostKeyChecker) trustsFirstUse() bool {
+	if h.Policy == config.HostKeyPolicyTOFU {
+		return true
+	}
+
+	return h.Policy == config.HostKeyPolicyTOFUThenStrict && time.Now().Before(h.TOFUDeadline)
+}
+
+//Callback returns a callback verifying the host key presented by a backend against the keys known by the data
+//store. Depending on the policy, the key of an unknown backend is either trusted and recorded or rejected.
+//Rejected keys, including changed ones, are recorded as pending until an administrator accepts them.
+func (h *HostKeyChecker) Callback(ctx context.Context) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		presented := ssh.FingerprintSHA256(key)
+
+		knownKeys, err := h.DataStore.GetBackendHostKeys(hostname)
+
+		if err != nil {
+			logger.ErrorWithCtxWithErr(ctx, err, "could not read the backend known host keys")
+			return errors.New("could not verify host key of " + hostname)
+		}
+
+		//A concurrent first connection may record its key first, the presented key is then checked against it
+		if len(knownKeys) == 0 && h.trustsFirstUse() {
+			knownKeys, err = h.DataStore.TrustFirstBackendHostKey(hostname, key)
+
+			if err != nil {
+				logger.ErrorWithCtxWithErr(ctx, err, "could not record the backend host key")
+				return errors.New("could not verify host key of " + hostname)
+			}
+
+			if len(knownKeys) == 0 {
+				logger.AuditfWithCtx(ctx, logger.AuditHostKey, "first connection to backend %v, trusting host key %v",
+					hostname, presented)
+				return nil
+			}
+		}
+
+		if len(knownKeys) == 0 {
+			logger.WarnfWithCtx(ctx, "unknown backend %v, presented host key %v", hostname, presented)
+			logger.AuditfWithCtx(ctx, logger.AuditHostKey, "unknown backend %v refused, host key %v pending",
+				hostname, presented)
+			h.recordPending(ctx, hostname, key)
+
+			return errors.New("host key verification failed: " + hostname + " is not a known backend, " +
+				"the connection is blocked until an administrator accepts its host key")
+		}
+
+		var expected []string
+
+		for _, k := range knownKeys {
+			if bytes.Equal(k.Marshal(), key.Marshal()) {
+				return nil
+			}
+
+			expected = append(expected, ssh.FingerprintSHA256(k))
+		}
+
+		logger.ErrorfWithCtx(ctx, "host key mismatch for backend %v, expected %v, presented %v",
+			hostname, strings.Join(expected, ","), presented)
+		logger.AuditfWithCtx(ctx, logger.AuditHostKey, "backend %v refused, host key %v changed to %v pending",
+			hostname, strings.Join(expected, ","), presented)
+		h.recordPending(ctx, hostname, key)
+
+		return errors.New("host key verification failed: the host key of " + hostname + " has changed (expected " +
+			strings.Join(expected, ",") + ", presented " + presented + "), the connection is blocked until an " +
+			"administrator accepts the new key")
+	}
+}
+
+//recordPending records the presented key so that an administrator can review and accept it.
+func (h *HostKeyChecker) recordPending(ctx context.Context, hostname string, key ssh.PublicKey) {
+	err := h.DataStore.SetPendingBackendHostKey(hostname, key)
+
+	if err != nil {
+		logger.ErrorWithCtxWithErr(ctx, err, "could not record the pending backend host key")
+	}
+}
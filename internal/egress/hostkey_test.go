@@ -0,0 +1,168 @@
+package egress
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/open-bastion/open-bastion/internal/config"
+	"github.com/open-bastion/open-bastion/internal/datastore"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+//testBackend is the address the test backends are dialed at
+const testBackend = "10.0.0.1:22"
+
+//newTestHostKey returns a random ed25519 host key
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	key, err := ssh.NewPublicKey(pub)
+	assert.Nil(t, err)
+
+	return key
+}
+
+//checkHostKey runs the callback of the checker as ssh.Dial would
+func checkHostKey(h *HostKeyChecker, key ssh.PublicKey) error {
+	addr, _ := net.ResolveTCPAddr("tcp", testBackend)
+
+	return h.Callback(context.Background())(testBackend, addr, key)
+}
+
+func TestHostKeyChecker_Mismatch(t *testing.T) {
+	s := datastore.NewMemoryStore(datastore.DefaultKeyPolicy())
+	known, presented := newTestHostKey(t), newTestHostKey(t)
+
+	assert.Nil(t, s.AddBackendHostKey(testBackend, known))
+
+	h := &HostKeyChecker{DataStore: s, Policy: config.HostKeyPolicyStrict}
+
+	assert.Nil(t, checkHostKey(h, known))
+
+	err := checkHostKey(h, presented)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "expected "+ssh.FingerprintSHA256(known))
+	assert.Contains(t, err.Error(), "presented "+ssh.FingerprintSHA256(presented))
+
+	//The known key is kept
+	keys, err := s.GetBackendHostKeys(testBackend)
+	assert.Nil(t, err)
+	assert.Equal(t, []ssh.PublicKey{known}, keys)
+}
+
+func TestHostKeyChecker_UnknownBackend(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      string
+		deadline    time.Duration
+		wantErr     bool
+		wantPending bool
+	}{
+		{name: "test strict", policy: config.HostKeyPolicyStrict, wantErr: true, wantPending: true},
+		{name: "test tofu", policy: config.HostKeyPolicyTOFU, wantErr: false, wantPending: false},
+		{name: "test tofu-then-strict learning", policy: config.HostKeyPolicyTOFUThenStrict, deadline: time.Hour,
+			wantErr: false, wantPending: false},
+		{name: "test tofu-then-strict after the deadline", policy: config.HostKeyPolicyTOFUThenStrict,
+			deadline: -time.Second, wantErr: true, wantPending: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := datastore.NewMemoryStore(datastore.DefaultKeyPolicy())
+			key := newTestHostKey(t)
+
+			h := &HostKeyChecker{DataStore: s, Policy: tt.policy, TOFUDeadline: time.Now().Add(tt.deadline)}
+
+			err := checkHostKey(h, key)
+
+			if tt.wantErr {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+			}
+
+			known, err := s.GetBackendHostKeys(testBackend)
+			assert.Nil(t, err)
+
+			pending, err := s.GetPendingBackendHostKey(testBackend)
+			assert.Nil(t, err)
+
+			//A trusted key is known, a refused one waits for an administrator
+			if tt.wantPending {
+				assert.Empty(t, known)
+				assert.Equal(t, key, pending)
+			} else {
+				assert.Equal(t, []ssh.PublicKey{key}, known)
+				assert.Nil(t, pending)
+			}
+		})
+	}
+}
+
+func TestHostKeyChecker_ChangedKey(t *testing.T) {
+	s := datastore.NewMemoryStore(datastore.DefaultKeyPolicy())
+	first, changed := newTestHostKey(t), newTestHostKey(t)
+
+	//The first key is trusted, even tofu blocks its change
+	h := &HostKeyChecker{DataStore: s, Policy: config.HostKeyPolicyTOFU}
+
+	assert.Nil(t, checkHostKey(h, first))
+	assert.NotNil(t, checkHostKey(h, changed))
+	assert.NotNil(t, checkHostKey(h, changed))
+
+	pending, err := s.GetPendingBackendHostKey(testBackend)
+	assert.Nil(t, err)
+	assert.Equal(t, changed, pending)
+
+	assert.Nil(t, s.AcceptPendingBackendHostKey(testBackend, ssh.FingerprintSHA256(changed)))
+
+	//The accepted key replaces the previous one
+	assert.Nil(t, checkHostKey(h, changed))
+	assert.NotNil(t, checkHostKey(h, first))
+}
+
+func TestHostKeyChecker_ConcurrentFirstConnections(t *testing.T) {
+	s := datastore.NewMemoryStore(datastore.DefaultKeyPolicy())
+	h := &HostKeyChecker{DataStore: s, Policy: config.HostKeyPolicyTOFU}
+
+	//The real backend and a man in the middle race to be the first connection
+	keys := []ssh.PublicKey{newTestHostKey(t), newTestHostKey(t), newTestHostKey(t), newTestHostKey(t)}
+	errs := make([]error, len(keys))
+	start := make(chan struct{})
+
+	var wg sync.WaitGroup
+
+	for i := range keys {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			<-start
+			errs[i] = checkHostKey(h, keys[i])
+		}(i)
+	}
+
+	close(start)
+	wg.Wait()
+
+	known, err := s.GetBackendHostKeys(testBackend)
+	assert.Nil(t, err)
+	assert.Len(t, known, 1)
+
+	//Only the trusted key connects, the others are refused as changed keys
+	for i, key := range keys {
+		assert.Equal(t, bytes.Equal(key.Marshal(), known[0].Marshal()), errs[i] == nil)
+	}
+
+	pending, err := s.GetPendingBackendHostKey(testBackend)
+	assert.Nil(t, err)
+	assert.NotNil(t, pending)
+}
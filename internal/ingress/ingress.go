@@ -3,6 +3,7 @@ package ingress
 import (
 	"context"
 	"errors"
+	"github.com/open-bastion/open-bastion/internal/auth"
 	"github.com/open-bastion/open-bastion/internal/config"
 	"github.com/open-bastion/open-bastion/internal/datastore"
 	"github.com/open-bastion/open-bastion/internal/egress"
@@ -11,62 +12,68 @@ import (
 	"golang.org/x/crypto/ssh"
 	"io/ioutil"
 	"net"
+	"sync"
+	"time"
 )
 
+//clientCloseTimeout is the delay given to a client to disconnect once its session is over
+const clientCloseTimeout = time.Second
+
 // Ingress contains the configuration of the SSH server the bastion runs
 type Ingress struct {
 	TCPListener     net.Listener
 	SSHServerConfig *ssh.ServerConfig
+	Auth            *auth.Auth
+	HostKeyChecker  *egress.HostKeyChecker
+	EgressCA        *egress.CertificateAuthority
+	Throttle        *Throttle
+	Sources         auth.SourceFilter
+	Sessions        *obclient.SessionRegistry
+
+	mu            sync.Mutex
+	closing       bool
+	drainDeadline time.Time
+	active        map[*obclient.Client]ssh.Channel
+	serving       bool
+	config        config.Config
 }
 
 // ConfigSSHServer is used to configure the SSH server the bastion runs
-func (in *Ingress) ConfigSSHServer(ak map[string]bool, privateKeyPath string, dataStore datastore.DataStore) error {
-	in.SSHServerConfig = &ssh.ServerConfig{
-		PublicKeyCallback: func(c ssh.ConnMetadata, pubKey ssh.PublicKey) (*ssh.Permissions, error) {
-			//TODO properly log that
-			s, err := dataStore.GetUserStatus(c.User())
-
-			if err != nil {
-				return nil, err
-			}
+func (in *Ingress) ConfigSSHServer(authInfo *auth.Auth, privateKeyPath string) error {
+	sshConfig, err := newServerConfig(authInfo, privateKeyPath)
 
-			if s == datastore.Inactive {
-				return nil, errors.New("account deactivated")
-			}
+	if err != nil {
+		return err
+	}
 
-			if s != datastore.Active {
-				return nil, errors.New("invalid user")
-			}
+	in.Auth = authInfo
+	in.SSHServerConfig = sshConfig
 
-			if ak[string(pubKey.Marshal())] {
-				return &ssh.Permissions{
-					// Record the public key used for authentication.
-					//TODO test that with multiple keys
-					Extensions: map[string]string{
-						"pubkey-fp": ssh.FingerprintSHA256(pubKey),
-					},
-				}, nil
-			}
-			return nil, errors.New("unknown public key for user " + c.User())
-		},
-		PasswordCallback: nil,
-		MaxAuthTries:     3,
-		AuthLogCallback:  nil,
+	return nil
+}
+
+//newServerConfig returns the configuration of the SSH server authenticating the clients with authInfo
+func newServerConfig(authInfo *auth.Auth, privateKeyPath string) (*ssh.ServerConfig, error) {
+	sshConfig := &ssh.ServerConfig{
+		MaxAuthTries: 3,
 	}
 
+	//Only the authentication methods allowed by the policy are offered, every attempt is logged
+	authInfo.ConfigureServer(sshConfig)
+
 	privateKeyBytes, err := ioutil.ReadFile(privateKeyPath)
 	if err != nil {
-		return errors.New("failed to load private key : " + err.Error())
+		return nil, errors.New("failed to load private key : " + err.Error())
 	}
 
 	privateSigner, err := ssh.ParsePrivateKey(privateKeyBytes)
 	if err != nil {
-		return errors.New("failed to parse private key : " + err.Error())
+		return nil, errors.New("failed to parse private key : " + err.Error())
 	}
 
-	in.SSHServerConfig.AddHostKey(privateSigner)
+	sshConfig.AddHostKey(privateSigner)
 
-	return nil
+	return sshConfig, nil
 }
 
 // ConfigTCPListener initialize TCPListener in the Ingress struct.
@@ -82,32 +89,69 @@ func (in *Ingress) ConfigTCPListener(address string) error {
 	return nil
 }
 
-//ListenAndServe listens forever for incoming SSH connections and tries to handle them
+//ListenAndServe listens for incoming SSH connections and tries to handle them until Shutdown is called. Once it is
+//called, the configuration is only changed by Reload.
 func (in *Ingress) ListenAndServe(ctx context.Context, dataStore datastore.DataStore, config config.Config) {
+	in.mu.Lock()
+	in.serving = true
+	in.config = config
+	in.mu.Unlock()
+
 	logger.Info("listening for new connections...")
 	for {
 		logger.Debug("waiting for a new connection...")
 		client := new(obclient.Client)
-		client.BackendTimeout = config.BackendTimeout
+		client.SessionID = obclient.NewSessionID()
 
 		var err error
 
 		client.TCPConnexion, err = in.TCPListener.Accept()
 
 		if err != nil {
+			if in.isClosing() {
+				logger.Info("stopped listening for new connections")
+				return
+			}
+
 			logger.WarnWithErr(err, "failed to handle the TCP connection")
 			continue
 		}
 
-		go in.handleClient(ctx, client, dataStore)
+		//The connection is handled with the configuration of the moment it is accepted
+		s := in.current()
+		client.BackendTimeout = s.backendTimeout
+
+		//Refused sources never reach the SSH handshake
+		if !s.sources.AllowsAddr(client.TCPConnexion.RemoteAddr()) {
+			logger.Warnf("connection from %v refused by the source rules", client.TCPConnexion.RemoteAddr())
+
+			if err := client.TCPConnexion.Close(); err != nil {
+				logger.WarnWithErr(err, "failed to close the refused TCP connection")
+			}
+
+			continue
+		}
+
+		client.StartTime = time.Now()
+
+		if !in.track(client) {
+			_ = client.TCPConnexion.Close()
+			continue
+		}
+
+		go in.handleClient(ctx, client, dataStore, s)
 	}
 }
 
 //handleClient takes a context, a client with a valid initialized connection and a DataStore, try to establish
 //an SSH connection then execute the client's command (either a bastion operation or a backend connection).
-func (in *Ingress) handleClient(ctx context.Context, c *obclient.Client, dataStore datastore.DataStore) {
-	err := c.HandshakeSSH(in.SSHServerConfig)
+func (in *Ingress) handleClient(ctx context.Context, c *obclient.Client, dataStore datastore.DataStore, s settings) {
+	defer in.untrack(c)
+
+	ctx = logger.WithAuditClient(ctx, c)
+	err := in.handshake(ctx, c, s.sshConfig)
 
+	s.auth.EndHandshake(c.TCPConnexion.RemoteAddr())
 	logger.UpdateClientLogCtx(ctx, c)
 
 	if err != nil {
@@ -124,6 +168,20 @@ func (in *Ingress) handleClient(ctx context.Context, c *obclient.Client, dataSto
 			logger.WarnWithCtxWithErr(ctx, err, "error closing the client communication channel")
 		}
 
+		//Let the client read the last messages (exit status...) and disconnect by itself, closing the connection
+		//first can drop them
+		closed := make(chan struct{})
+
+		go func() {
+			_ = c.SSHConnexion.Wait()
+			close(closed)
+		}()
+
+		select {
+		case <-closed:
+		case <-time.After(clientCloseTimeout):
+		}
+
 		if err := c.SSHConnexion.Close(); err != nil {
 			logger.WarnWithCtxWithErr(ctx, err, "error closing the SSH connection")
 		}
@@ -135,11 +193,37 @@ func (in *Ingress) handleClient(ctx context.Context, c *obclient.Client, dataSto
 	}
 
 	logger.InfoWithCtx(ctx, "client connected")
+	logger.AuditfWithCtx(ctx, logger.AuditSession, "session started: %q", c.GetCommand())
+
+	defer func() {
+		logger.AuditfWithCtx(ctx, logger.AuditSession, "session ended after %v",
+			time.Since(c.StartTime).Round(time.Second))
+	}()
+
+	in.setChannel(c, c.SshCommChan)
+
+	in.Sessions.Add(c.Session())
+	defer in.Sessions.Remove(c.SessionID)
 
 	if c.BackendCommand == "bastion" {
-		_ = c.RunCommand()
+		env := obclient.CommandEnv{DataStore: dataStore, Sessions: in.Sessions, Auth: s.auth,
+			RecordingsDir: s.recording.Directory}
+
+		if in.Throttle != nil {
+			env.Bans = in.Throttle
+		}
+
+		if s.egressCA != nil {
+			env.EgressCAKey = s.egressCA.PublicKey()
+		}
+
+		err = c.RunCommand(ctx, env)
+
+		if err != nil {
+			logger.WarnWithCtxWithErr(ctx, err, "bastion command failed")
+		}
 	} else if c.BackendCommand == "ssh" {
-		egress.EstablishSSHConnection(ctx, c, dataStore)
+		egress.EstablishSSHConnection(ctx, c, dataStore, s.hostKeyChecker, s.egressCA, s.auth.Policy, s.recording)
 	} else if c.BackendCommand == "telnet" {
 		logger.WarnWithCtxWithErr(ctx, err, "method not implemented")
 	}
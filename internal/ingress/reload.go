@@ -0,0 +1,100 @@
+package ingress
+
+import (
+	"errors"
+	"github.com/open-bastion/open-bastion/internal/auth"
+	"github.com/open-bastion/open-bastion/internal/config"
+	"github.com/open-bastion/open-bastion/internal/egress"
+	"github.com/open-bastion/open-bastion/internal/logger"
+	"golang.org/x/crypto/ssh"
+	"strings"
+)
+
+//settings contains the reloadable configuration a connection is handled with, from its acceptance to its end
+type settings struct {
+	sshConfig      *ssh.ServerConfig
+	auth           *auth.Auth
+	sources        auth.SourceFilter
+	hostKeyChecker *egress.HostKeyChecker
+	egressCA       *egress.CertificateAuthority
+	backendTimeout int
+	recording      config.Recording
+}
+
+//current returns the configuration of the new connections
+func (in *Ingress) current() settings {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	return settings{
+		sshConfig:      in.SSHServerConfig,
+		auth:           in.Auth,
+		sources:        in.Sources,
+		hostKeyChecker: in.HostKeyChecker,
+		egressCA:       in.EgressCA,
+		backendTimeout: in.config.BackendTimeout,
+		recording:      in.config.Recording,
+	}
+}
+
+// Reload applies a new configuration to the connections accepted from now on, the active sessions keep the
+// configuration they started with. The trusted user CA keys, the revoked keys and the private key are read again.
+// Nothing is changed if the configuration is invalid. The settings which require a restart keep their current value.
+func (in *Ingress) Reload(c config.Config) error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	if !in.serving {
+		return errors.New("the bastion is not listening yet")
+	}
+
+	if changed := in.config.RestartRequired(c); len(changed) > 0 {
+		logger.Warnf("changes of %v are ignored until the bastion restarts", strings.Join(changed, ", "))
+	}
+
+	c = in.config.KeepRestartRequired(c)
+
+	authInfo, err := in.Auth.Reload(c)
+
+	if err != nil {
+		return errors.New("could not reload the authentication : " + err.Error())
+	}
+
+	sshConfig, err := newServerConfig(authInfo, c.PrivateKeyFile)
+
+	if err != nil {
+		return errors.New("could not reload the SSH server : " + err.Error())
+	}
+
+	sources, err := auth.NewSourceFilter(c.Sources.SourceRules)
+
+	if err != nil {
+		return errors.New("could not reload the source rules : " + err.Error())
+	}
+
+	egressCA, err := egress.NewCertificateAuthority(c)
+
+	if err != nil {
+		return errors.New("could not reload the egress CA : " + err.Error())
+	}
+
+	//The tofu-then-strict learning period only starts again if the host key policy changes
+	hostKeyChecker := in.HostKeyChecker
+
+	if c.HostKeyPolicy != in.config.HostKeyPolicy || c.HostKeyTOFUPeriod != in.config.HostKeyTOFUPeriod {
+		hostKeyChecker = egress.NewHostKeyChecker(hostKeyChecker.DataStore, c)
+	}
+
+	if in.Throttle != nil {
+		in.Throttle.Configure(c)
+	}
+
+	in.Auth = authInfo
+	in.SSHServerConfig = sshConfig
+	in.Sources = sources
+	in.EgressCA = egressCA
+	in.HostKeyChecker = hostKeyChecker
+	in.config = c
+
+	return nil
+}
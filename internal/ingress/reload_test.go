@@ -0,0 +1,159 @@
+package ingress
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/open-bastion/open-bastion/internal/auth"
+	"github.com/open-bastion/open-bastion/internal/config"
+	"github.com/open-bastion/open-bastion/internal/egress"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+//newTestIngress returns a serving Ingress configured by c, with a generated host key
+func newTestIngress(t *testing.T, c *config.Config) *Ingress {
+	tempDir, err := ioutil.TempDir("", "open-bastion-testing")
+
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	t.Cleanup(func() { _ = os.RemoveAll(tempDir) })
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	assert.Nil(t, err)
+
+	c.PrivateKeyFile = tempDir + "/hostkey"
+	err = ioutil.WriteFile(c.PrivateKeyFile, pem.EncodeToMemory(block), 0600)
+	assert.Nil(t, err)
+
+	c.PermitKeyLogin = true
+	c.HostKeyPolicy = config.HostKeyPolicyStrict
+
+	authInfo, err := auth.NewAuth(nil, *c)
+	assert.Nil(t, err)
+
+	in := &Ingress{Throttle: NewThrottle(*c), HostKeyChecker: egress.NewHostKeyChecker(nil, *c)}
+	err = in.ConfigSSHServer(authInfo, c.PrivateKeyFile)
+	assert.Nil(t, err)
+
+	err = in.ConfigTCPListener("127.0.0.1:0")
+	assert.Nil(t, err)
+
+	go in.ListenAndServe(context.Background(), nil, *c)
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		_ = in.Shutdown(ctx)
+	})
+
+	//Wait for ListenAndServe to start
+	for {
+		in.mu.Lock()
+		serving := in.serving
+		in.mu.Unlock()
+
+		if serving {
+			break
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	return in
+}
+
+func TestIngress_Reload(t *testing.T) {
+	c := config.Config{BackendTimeout: 10}
+	in := newTestIngress(t, &c)
+	client := net.ParseIP("192.0.2.1")
+
+	tests := []struct {
+		name        string
+		update      func(c *config.Config)
+		wantErr     bool
+		wantAllowed bool
+		wantTimeout int
+	}{
+		{name: "test source rule and timeout reloaded", update: func(c *config.Config) {
+			c.Sources.Deny = []string{"192.0.2.0/24"}
+			c.BackendTimeout = 20
+		}, wantErr: false, wantAllowed: false, wantTimeout: 20},
+		{name: "test invalid source rule", update: func(c *config.Config) {
+			c.Sources.Deny = []string{"192.0.2.0/33"}
+			c.BackendTimeout = 30
+		}, wantErr: true, wantAllowed: false, wantTimeout: 20},
+		{name: "test missing CA keys file", update: func(c *config.Config) {
+			c.Sources.Deny = nil
+			c.TrustedUserCAKeys = "/nonexistent/ca.pub"
+		}, wantErr: true, wantAllowed: false, wantTimeout: 20},
+		{name: "test rules removed", update: func(c *config.Config) {
+			c.Sources.Deny = nil
+			c.TrustedUserCAKeys = ""
+		}, wantErr: false, wantAllowed: true, wantTimeout: 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.update(&c)
+			err := in.Reload(c)
+
+			if tt.wantErr {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+			}
+
+			s := in.current()
+			assert.Equal(t, tt.wantAllowed, s.sources.Allows(client))
+			assert.Equal(t, tt.wantTimeout, s.backendTimeout)
+		})
+	}
+}
+
+func TestIngress_ReloadBeforeServing(t *testing.T) {
+	in := &Ingress{}
+
+	assert.NotNil(t, in.Reload(config.Config{}))
+}
+
+func TestIngress_ReloadRestartRequired(t *testing.T) {
+	c := config.Config{BackendTimeout: 10, ListenPort: 2222, DataStoreType: "memory"}
+	c.Log.Path = "/var/log/open-bastion.log"
+	c.Audit.File = "/var/log/open-bastion-audit.log"
+	in := newTestIngress(t, &c)
+
+	n := c
+	n.BackendTimeout = 20
+	n.ListenPort = 2223
+	n.DataStoreType = "sql"
+	n.Log.Path = "/tmp/open-bastion.log"
+	n.Log.Level = 3
+	n.Audit.File = "/tmp/open-bastion-audit.log"
+
+	assert.Nil(t, in.Reload(n))
+
+	//The reloadable settings are applied, the others keep the value the bastion started with
+	assert.Equal(t, 20, in.config.BackendTimeout)
+	assert.Equal(t, 3, in.config.Log.Level)
+	assert.Equal(t, 2222, in.config.ListenPort)
+	assert.Equal(t, "memory", in.config.DataStoreType)
+	assert.Equal(t, "/var/log/open-bastion.log", in.config.Log.Path)
+	assert.Equal(t, "/var/log/open-bastion-audit.log", in.config.Audit.File)
+
+	//The next reload still reports the same ignored changes
+	assert.Equal(t, []string{"ListenAddress", "ListenPort", "DataStoreType", "SQL", "Memory", "UserKeysDir",
+		"KnownHostsFile", "Log", "Audit"}, in.config.RestartRequired(n))
+}
@@ -0,0 +1,148 @@
+package ingress
+
+import (
+	"context"
+	"fmt"
+	"github.com/open-bastion/open-bastion/internal/logger"
+	"github.com/open-bastion/open-bastion/internal/obclient"
+	"golang.org/x/crypto/ssh"
+	"time"
+)
+
+//shutdownPollInterval is how often Shutdown checks whether the sessions are over
+const shutdownPollInterval = 100 * time.Millisecond
+
+//shutdownBanner is the warning written to the sessions when the bastion shuts down, with the remaining drain period
+const shutdownBanner = "\r\n[!] open-bastion is shutting down, this session will be closed in %v\r\n"
+
+//track registers a client accepted by the listener. It returns false if the ingress is shutting down, the client
+//must then be closed.
+func (in *Ingress) track(c *obclient.Client) bool {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	if in.closing {
+		return false
+	}
+
+	if in.active == nil {
+		in.active = make(map[*obclient.Client]ssh.Channel)
+	}
+
+	in.active[c] = nil
+
+	return true
+}
+
+//untrack unregisters a client once it is disconnected.
+func (in *Ingress) untrack(c *obclient.Client) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	delete(in.active, c)
+}
+
+//setChannel records the communication channel of a client, the shutdown banner is written to it. A session opened
+//while the ingress is draining gets the banner right away.
+func (in *Ingress) setChannel(c *obclient.Client, channel ssh.Channel) {
+	in.mu.Lock()
+
+	if _, ok := in.active[c]; !ok {
+		in.mu.Unlock()
+		return
+	}
+
+	in.active[c] = channel
+	closing, deadline := in.closing, in.drainDeadline
+
+	in.mu.Unlock()
+
+	//A slow client must not block the ingress, the banner is written without holding the lock
+	if closing {
+		writeShutdownBanner(channel, deadline)
+	}
+}
+
+//writeShutdownBanner warns a session that it will be closed at the deadline.
+func writeShutdownBanner(channel ssh.Channel, deadline time.Time) {
+	remaining := "a moment"
+
+	if !deadline.IsZero() {
+		remaining = time.Until(deadline).Round(time.Second).String()
+	}
+
+	_, _ = fmt.Fprintf(channel, shutdownBanner, remaining)
+}
+
+//activeCount returns the number of connected clients.
+func (in *Ingress) activeCount() int {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	return len(in.active)
+}
+
+// Shutdown stops accepting connections and lets the active sessions end until the context is done, they are warned
+// by a banner. The remaining connections are then closed. It returns the context error if sessions had to be closed.
+func (in *Ingress) Shutdown(ctx context.Context) error {
+	in.mu.Lock()
+	in.closing = true
+	in.drainDeadline, _ = ctx.Deadline()
+	deadline := in.drainDeadline
+
+	var channels []ssh.Channel
+
+	for _, channel := range in.active {
+		if channel != nil {
+			channels = append(channels, channel)
+		}
+	}
+
+	in.mu.Unlock()
+
+	for _, channel := range channels {
+		writeShutdownBanner(channel, deadline)
+	}
+
+	if err := in.TCPListener.Close(); err != nil {
+		logger.WarnWithErr(err, "failed to close the listener")
+	}
+
+	logger.Infof("draining %v connections", in.activeCount())
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for in.activeCount() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			in.closeActive()
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+//closeActive closes the connections of the clients still connected.
+func (in *Ingress) closeActive() {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	logger.Warnf("closing %v connections after the drain period", len(in.active))
+
+	for c := range in.active {
+		if err := c.TCPConnexion.Close(); err != nil {
+			logger.WarnWithErr(err, "failed to close a client connection")
+		}
+	}
+}
+
+//isClosing returns whether Shutdown was called
+func (in *Ingress) isClosing() bool {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	return in.closing
+}
@@ -0,0 +1,133 @@
+package ingress
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/open-bastion/open-bastion/internal/config"
+	"github.com/open-bastion/open-bastion/internal/obclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIngress_Shutdown(t *testing.T) {
+	tests := []struct {
+		name    string
+		active  bool
+		wantErr bool
+	}{
+		{name: "test no active client", active: false, wantErr: false},
+		{name: "test client closed after the drain period", active: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := &Ingress{}
+			err := in.ConfigTCPListener("127.0.0.1:0")
+			assert.Nil(t, err)
+
+			served := make(chan struct{})
+
+			go func() {
+				in.ListenAndServe(context.Background(), nil, config.Config{})
+				close(served)
+			}()
+
+			server, client := net.Pipe()
+			defer client.Close()
+
+			c := &obclient.Client{TCPConnexion: server}
+
+			if tt.active {
+				assert.True(t, in.track(c))
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+
+			err = in.Shutdown(ctx)
+
+			if tt.wantErr {
+				assert.NotNil(t, err)
+
+				//The connection of the remaining client is closed
+				_, err = client.Write([]byte{0})
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+			}
+
+			select {
+			case <-served:
+			case <-time.After(time.Second):
+				t.Fatal("ListenAndServe did not return")
+			}
+
+			assert.False(t, in.track(&obclient.Client{}))
+		})
+	}
+}
+
+//blockingChannel is an SSH channel whose writes block until release is closed
+type blockingChannel struct {
+	writing chan struct{}
+	release chan struct{}
+}
+
+func (ch *blockingChannel) Read([]byte) (int, error) { return 0, nil }
+func (ch *blockingChannel) Write(data []byte) (int, error) {
+	close(ch.writing)
+	<-ch.release
+
+	return len(data), nil
+}
+func (ch *blockingChannel) Close() error      { return nil }
+func (ch *blockingChannel) CloseWrite() error { return nil }
+func (ch *blockingChannel) SendRequest(string, bool, []byte) (bool, error) {
+	return false, nil
+}
+func (ch *blockingChannel) Stderr() io.ReadWriter { return nil }
+
+func TestIngress_ShutdownSlowClient(t *testing.T) {
+	in := &Ingress{}
+	err := in.ConfigTCPListener("127.0.0.1:0")
+	assert.Nil(t, err)
+
+	server, client := net.Pipe()
+	defer client.Close()
+
+	c := &obclient.Client{TCPConnexion: server}
+	channel := &blockingChannel{writing: make(chan struct{}), release: make(chan struct{})}
+
+	assert.True(t, in.track(c))
+	in.setChannel(c, channel)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	shutdown := make(chan error)
+
+	go func() {
+		shutdown <- in.Shutdown(ctx)
+	}()
+
+	<-channel.writing
+
+	//The ingress is not locked while the banner is written to the client
+	untracked := make(chan struct{})
+
+	go func() {
+		in.untrack(c)
+		close(untracked)
+	}()
+
+	select {
+	case <-untracked:
+	case <-time.After(time.Second):
+		t.Fatal("the ingress is locked by the shutdown banner")
+	}
+
+	close(channel.release)
+	assert.Nil(t, <-shutdown)
+}
@@ -0,0 +1,344 @@
+package ingress
+
+import (
+	"context"
+	"errors"
+	"github.com/open-bastion/open-bastion/internal/config"
+	"github.com/open-bastion/open-bastion/internal/logger"
+	"github.com/open-bastion/open-bastion/internal/obclient"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Kinds of the throttled sources
+const (
+	ThrottleIP   = "ip"
+	ThrottleUser = "user"
+)
+
+//maxThrottleSources is the number of sources above which the forgettable ones are pruned at each failure
+const maxThrottleSources = 10000
+
+// Throttle counts the failed authentications of each source IP and of each username over a sliding window. A source
+// reaching MaxFailures in Window is banned for BanDuration, doubled at each new ban up to MaxBanDuration. The failed
+// attempts are slowed down by TarpitDelay. It is safe for concurrent use, the settings are then changed by Configure.
+// The bans are lost when the bastion restarts.
+type Throttle struct {
+	MaxFailures    int
+	Window         time.Duration
+	BanDuration    time.Duration
+	MaxBanDuration time.Duration
+	TarpitDelay    time.Duration
+
+	mu      sync.Mutex
+	sources map[throttleKey]*throttleState
+}
+
+//throttleKey identifies a throttled source
+type throttleKey struct {
+	kind   string
+	target string
+}
+
+//throttleState contains the recent failures and the bans of a source
+type throttleState struct {
+	failures    []time.Time
+	bans        int
+	bannedUntil time.Time
+}
+
+// NewThrottle returns a Throttle configured by the throttle section of the configuration
+func NewThrottle(c config.Config) *Throttle {
+	t := &Throttle{sources: make(map[throttleKey]*throttleState)}
+	t.Configure(c)
+
+	return t
+}
+
+// Configure applies the throttle section of the configuration, the failures and bans are kept
+func (t *Throttle) Configure(c config.Config) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.MaxFailures = c.Throttle.MaxFailures
+	t.Window = time.Duration(c.Throttle.Window) * time.Second
+	t.BanDuration = time.Duration(c.Throttle.BanDuration) * time.Second
+	t.MaxBanDuration = time.Duration(c.Throttle.MaxBanDuration) * time.Second
+	t.TarpitDelay = time.Duration(c.Throttle.TarpitDelay) * time.Millisecond
+}
+
+//tarpitDelay returns the delay of the failed attempts
+func (t *Throttle) tarpitDelay() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.TarpitDelay
+}
+
+//remoteIP returns the IP of a remote address, without the port
+func remoteIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+
+	if err != nil {
+		return addr.String()
+	}
+
+	return host
+}
+
+//banDuration returns the duration of the nth ban of a source
+func (t *Throttle) banDuration(n int) time.Duration {
+	d := t.BanDuration
+
+	for i := 1; i < n && d < t.MaxBanDuration; i++ {
+		d *= 2
+	}
+
+	if d > t.MaxBanDuration {
+		d = t.MaxBanDuration
+	}
+
+	return d
+}
+
+//fail records a failure of the source and returns whether it is now banned
+func (t *Throttle) fail(key throttleKey, now time.Time) bool {
+	s, ok := t.sources[key]
+
+	if !ok {
+		s = &throttleState{}
+		t.sources[key] = s
+	}
+
+	//Only the failures of the sliding window count
+	recent := s.failures[:0]
+
+	for _, f := range s.failures {
+		if now.Sub(f) < t.Window {
+			recent = append(recent, f)
+		}
+	}
+
+	s.failures = append(recent, now)
+
+	if len(s.failures) < t.MaxFailures || now.Before(s.bannedUntil) {
+		return false
+	}
+
+	s.bans++
+	s.bannedUntil = now.Add(t.banDuration(s.bans))
+	s.failures = nil
+
+	return true
+}
+
+// Fail records a failed authentication of the user from the IP and returns the sources it banned
+func (t *Throttle) Fail(ip string, username string, now time.Time) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.sources) > maxThrottleSources {
+		t.prune(now)
+	}
+
+	var banned []string
+
+	if t.fail(throttleKey{kind: ThrottleIP, target: ip}, now) {
+		banned = append(banned, ThrottleIP+" "+ip)
+	}
+
+	if username != "" && t.fail(throttleKey{kind: ThrottleUser, target: username}, now) {
+		banned = append(banned, ThrottleUser+" "+username)
+	}
+
+	return banned
+}
+
+//banned returns whether the source is currently banned
+func (t *Throttle) banned(key throttleKey, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.sources[key]
+
+	return ok && now.Before(s.bannedUntil)
+}
+
+// IPBanned returns whether the IP is currently banned
+func (t *Throttle) IPBanned(ip string, now time.Time) bool {
+	return t.banned(throttleKey{kind: ThrottleIP, target: ip}, now)
+}
+
+// UserBanned returns whether the username is currently banned
+func (t *Throttle) UserBanned(username string, now time.Time) bool {
+	return t.banned(throttleKey{kind: ThrottleUser, target: username}, now)
+}
+
+//prune forgets the sources whose last ban expired for MaxBanDuration and without recent failures, their next ban
+//starts again from BanDuration
+func (t *Throttle) prune(now time.Time) {
+	for key, s := range t.sources {
+		if len(s.failures) > 0 && now.Sub(s.failures[len(s.failures)-1]) < t.Window {
+			continue
+		}
+
+		if now.Sub(s.bannedUntil) >= t.MaxBanDuration {
+			delete(t.sources, key)
+		}
+	}
+}
+
+// Bans returns the current bans ordered by end
+func (t *Throttle) Bans(now time.Time) []obclient.Ban {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.prune(now)
+
+	var bans []obclient.Ban
+
+	for key, s := range t.sources {
+		if now.Before(s.bannedUntil) {
+			bans = append(bans, obclient.Ban{Kind: key.kind, Target: key.target, Count: s.bans, Until: s.bannedUntil})
+		}
+	}
+
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].Until.Before(bans[j].Until)
+	})
+
+	return bans
+}
+
+// ClearBans lifts the bans of the IP or username target, or of every source if target is empty, and returns the
+// number of bans lifted. The failures of the cleared sources are forgotten.
+func (t *Throttle) ClearBans(target string, now time.Time) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cleared := 0
+
+	for key, s := range t.sources {
+		if target != "" && key.target != target {
+			continue
+		}
+
+		if now.Before(s.bannedUntil) {
+			cleared++
+		}
+
+		delete(t.sources, key)
+	}
+
+	return cleared
+}
+
+//connectionAttempts contains the failed authentications of a connection which are not counted yet
+type connectionAttempts struct {
+	user      string
+	keyFailed bool
+}
+
+//fail records a failed authentication of the user from the IP and audits the bans it triggers
+func (in *Ingress) fail(ctx context.Context, ip string, username string) {
+	for _, source := range in.Throttle.Fail(ip, username, time.Now()) {
+		logger.AuditfWithCtx(ctx, logger.AuditBan, "%v banned after too many failed authentications", source)
+	}
+}
+
+//throttledConfig returns the server configuration of a connection from ip. The banned IPs and users are refused and
+//every failed password or verification code attempt counts as a failure and is delayed by the tarpit. The offered
+//keys are not guesses, a client may try each key of its agent: the failed public keys are only recorded in attempts.
+func (in *Ingress) throttledConfig(ctx context.Context, serverConfig *ssh.ServerConfig, ip string,
+	attempts *connectionAttempts) *ssh.ServerConfig {
+	sshConfig := *serverConfig
+	logCallback := sshConfig.AuthLogCallback
+
+	sshConfig.AuthLogCallback = func(c ssh.ConnMetadata, method string, err error) {
+		if logCallback != nil {
+			logCallback(c, method, err)
+		}
+
+		if _, partial := err.(*ssh.PartialSuccessError); method == "none" || err == nil || partial {
+			return
+		}
+
+		if method == "publickey" {
+			attempts.user = c.User()
+			attempts.keyFailed = true
+
+			return
+		}
+
+		in.fail(ctx, ip, c.User())
+
+		if delay := in.Throttle.tarpitDelay(); delay > 0 {
+			time.Sleep(delay)
+		}
+	}
+
+	//The sources banned by the failures of this connection are refused at once
+	refuseBanned := func(c ssh.ConnMetadata) error {
+		if in.Throttle.IPBanned(ip, time.Now()) {
+			return errors.New(ip + " temporarily banned after too many failed authentications")
+		}
+
+		if in.Throttle.UserBanned(c.User(), time.Now()) {
+			return errors.New("user " + c.User() + " temporarily banned after too many failed authentications")
+		}
+
+		return nil
+	}
+
+	if cb := sshConfig.PublicKeyCallback; cb != nil {
+		sshConfig.PublicKeyCallback = func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if err := refuseBanned(c); err != nil {
+				return nil, err
+			}
+
+			return cb(c, key)
+		}
+	}
+
+	if cb := sshConfig.PasswordCallback; cb != nil {
+		sshConfig.PasswordCallback = func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if err := refuseBanned(c); err != nil {
+				return nil, err
+			}
+
+			return cb(c, password)
+		}
+	}
+
+	return &sshConfig
+}
+
+//handshake performs the SSH handshake of the client unless its IP is banned. Each failed password or verification
+//code counts as a failure of the IP and of the username. A connection failing to authenticate after offering only
+//public keys counts as one failure of its IP and of the last username it tried.
+func (in *Ingress) handshake(ctx context.Context, c *obclient.Client, sshConfig *ssh.ServerConfig) error {
+	if in.Throttle == nil {
+		return c.HandshakeSSH(sshConfig)
+	}
+
+	ip := remoteIP(c.TCPConnexion.RemoteAddr())
+
+	if in.Throttle.IPBanned(ip, time.Now()) {
+		time.Sleep(in.Throttle.tarpitDelay())
+		_ = c.TCPConnexion.Close()
+
+		return errors.New("connection refused, " + ip + " is temporarily banned")
+	}
+
+	attempts := &connectionAttempts{}
+	err := c.HandshakeSSH(in.throttledConfig(ctx, sshConfig, ip, attempts))
+
+	if err != nil && attempts.keyFailed {
+		in.fail(ctx, ip, attempts.user)
+	}
+
+	return err
+}
@@ -0,0 +1,151 @@
+package ingress
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestThrottle() *Throttle {
+	return &Throttle{
+		MaxFailures:    3,
+		Window:         time.Minute,
+		BanDuration:    time.Minute,
+		MaxBanDuration: 5 * time.Minute,
+		sources:        make(map[throttleKey]*throttleState),
+	}
+}
+
+func TestThrottle_Fail(t *testing.T) {
+	th := newTestThrottle()
+	now := time.Unix(1600000000, 0)
+
+	tests := []struct {
+		name       string
+		ip         string
+		username   string
+		after      time.Duration
+		wantBanned []string
+	}{
+		{name: "test first failure", ip: "192.0.2.1", username: "alice", wantBanned: nil},
+		{name: "test second failure", ip: "192.0.2.1", username: "bob", wantBanned: nil},
+		{name: "test failures out of the window", ip: "192.0.2.1", username: "alice", after: 2 * time.Minute,
+			wantBanned: nil},
+		{name: "test other ip", ip: "192.0.2.2", username: "alice", wantBanned: nil},
+		{name: "test user banned", ip: "192.0.2.3", username: "alice", wantBanned: []string{"user alice"}},
+		{name: "test ip banned", ip: "192.0.2.1", username: "", wantBanned: nil},
+		{name: "test ip banned after window", ip: "192.0.2.1", username: "", wantBanned: []string{"ip 192.0.2.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = now.Add(tt.after)
+
+			assert.Equal(t, tt.wantBanned, th.Fail(tt.ip, tt.username, now))
+		})
+	}
+
+	assert.True(t, th.UserBanned("alice", now))
+	assert.False(t, th.UserBanned("bob", now))
+	assert.True(t, th.IPBanned("192.0.2.1", now))
+	assert.False(t, th.IPBanned("192.0.2.2", now))
+	assert.False(t, th.IPBanned("192.0.2.1", now.Add(time.Minute)))
+
+	assert.Equal(t, 2, len(th.Bans(now)))
+	assert.Equal(t, 1, th.ClearBans("alice", now))
+	assert.False(t, th.UserBanned("alice", now))
+	assert.Equal(t, 1, len(th.Bans(now)))
+	assert.Equal(t, 1, th.ClearBans("", now))
+	assert.Equal(t, 0, len(th.Bans(now)))
+}
+
+func TestThrottle_Backoff(t *testing.T) {
+	th := newTestThrottle()
+	now := time.Unix(1600000000, 0)
+
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute} {
+		for i := 0; i < th.MaxFailures; i++ {
+			th.Fail("192.0.2.1", "", now)
+		}
+
+		bans := th.Bans(now)
+
+		assert.Equal(t, 1, len(bans))
+		assert.Equal(t, now.Add(want), bans[0].Until)
+
+		now = bans[0].Until
+	}
+
+	//The bans are forgotten once the source behaves for MaxBanDuration
+	now = now.Add(th.MaxBanDuration)
+	assert.Equal(t, 0, len(th.Bans(now)))
+
+	for i := 0; i < th.MaxFailures; i++ {
+		th.Fail("192.0.2.1", "", now)
+	}
+
+	assert.Equal(t, now.Add(time.Minute), th.Bans(now)[0].Until)
+}
+
+//testConnMetadata is the metadata of a connection of user from 192.0.2.1
+type testConnMetadata struct {
+	user string
+}
+
+func (m testConnMetadata) User() string          { return m.user }
+func (m testConnMetadata) SessionID() []byte     { return nil }
+func (m testConnMetadata) ClientVersion() []byte { return nil }
+func (m testConnMetadata) ServerVersion() []byte { return nil }
+func (m testConnMetadata) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 40000}
+}
+func (m testConnMetadata) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 22}
+}
+
+func TestIngress_ThrottledConfig(t *testing.T) {
+	in := &Ingress{Throttle: newTestThrottle()}
+	attempts := &connectionAttempts{}
+	serverConfig := &ssh.ServerConfig{PasswordCallback: func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) {
+		return nil, errors.New("wrong password")
+	}}
+	sshConfig := in.throttledConfig(context.Background(), serverConfig, "192.0.2.1", attempts)
+	alice := testConnMetadata{user: "alice"}
+	failure := errors.New("wrong password")
+
+	tests := []struct {
+		name          string
+		method        string
+		err           error
+		wantKeyFailed bool
+		wantBanned    bool
+	}{
+		{name: "test none", method: "none", err: failure, wantKeyFailed: false, wantBanned: false},
+		{name: "test public key not counted", method: "publickey", err: failure, wantKeyFailed: true,
+			wantBanned: false},
+		{name: "test partial success", method: "publickey", err: &ssh.PartialSuccessError{}, wantKeyFailed: true,
+			wantBanned: false},
+		{name: "test first password", method: "password", err: failure, wantKeyFailed: true, wantBanned: false},
+		{name: "test first code", method: "keyboard-interactive", err: failure, wantKeyFailed: true,
+			wantBanned: false},
+		{name: "test banned in the connection", method: "password", err: failure, wantKeyFailed: true,
+			wantBanned: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sshConfig.AuthLogCallback(alice, tt.method, tt.err)
+
+			assert.Equal(t, tt.wantKeyFailed, attempts.keyFailed)
+			assert.Equal(t, tt.wantBanned, in.Throttle.IPBanned("192.0.2.1", time.Now()))
+			assert.Equal(t, tt.wantBanned, in.Throttle.UserBanned("alice", time.Now()))
+		})
+	}
+
+	//The next attempts of the connection are refused before checking the password
+	_, err := sshConfig.PasswordCallback(alice, []byte("secret"))
+	assert.EqualError(t, err, "192.0.2.1 temporarily banned after too many failed authentications")
+}
@@ -0,0 +1,459 @@
+package logger
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of the audit events
+const (
+	AuditAuth    = "auth"
+	AuditSession = "session"
+	AuditAdmin   = "admin"
+	AuditHostKey = "hostkey"
+	AuditBan     = "ban"
+)
+
+//minAuditKeySize is the minimum size in bytes of the HMAC key of the audit records
+const minAuditKeySize = 32
+
+//auditLog is the audit log of the bastion, the audit events are only written to the operational logs until it is
+//opened
+var auditLog *AuditLog
+
+// AuditRecord is a line of the audit log. Prev is the hash of the previous record, or empty for the first one, and
+// Hash is the SHA-256 of the record encoded without its Hash and MAC. MAC is the HMAC-SHA256 of Hash when the
+// records are signed.
+type AuditRecord struct {
+	Seq     uint64            `json:"seq"`
+	Time    string            `json:"time"`
+	Event   string            `json:"event"`
+	Session string            `json:"session,omitempty"`
+	User    string            `json:"user,omitempty"`
+	IP      string            `json:"ip,omitempty"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+	Prev    string            `json:"prev"`
+	Hash    string            `json:"hash,omitempty"`
+	MAC     string            `json:"mac,omitempty"`
+}
+
+//seal returns the hash and the MAC of the record, its Hash and MAC are ignored
+func (r AuditRecord) seal(key []byte) (string, string, error) {
+	r.Hash, r.MAC = "", ""
+
+	body, err := json.Marshal(r)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
+
+	if key == nil {
+		return hash, "", nil
+	}
+
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(hash))
+
+	return hash, hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// AuditLog is an append-only file of JSON audit records, each one chained to the previous one by its hash so that
+// a modified, removed or reordered record is detected. The records are signed if a key is given, otherwise the whole
+// chain could be recomputed by an attacker. A removal of the last records is only detected against a known head: the
+// head is written to a head file after each record, signed like the records, and it is logged when the audit log is
+// opened and closed. It is safe for concurrent use.
+type AuditLog struct {
+	mu       sync.Mutex
+	file     *os.File
+	headPath string
+	key      []byte
+	seq      uint64
+	prev     string
+}
+
+// OpenAuditLog opens the audit log at path in append mode, it is created if needed. The existing records are
+// verified first against the head of headPath if it exists, new records are not chained to a tampered or truncated
+// log. The head is written to headPath after each record, unless headPath is empty. A last record partially written
+// when the bastion crashed is removed, its removal is audited with the size and hash of the discarded bytes.
+func OpenAuditLog(path string, headPath string, key []byte) (*AuditLog, error) {
+	var expected AuditHead
+	var err error
+
+	if headPath != "" {
+		if err = os.MkdirAll(filepath.Dir(headPath), 0700); err != nil {
+			return nil, errors.New("could not create the audit head directory : " + err.Error())
+		}
+
+		if expected, err = ReadAuditHead(headPath, key); err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0640)
+
+	if err != nil {
+		return nil, errors.New("could not open the audit log : " + err.Error())
+	}
+
+	head, size, tail, err := verifyAuditLog(file, key, expected)
+
+	if err != nil {
+		_ = file.Close()
+		return nil, errors.New("could not verify the audit log " + path + " : " + err.Error())
+	}
+
+	l := &AuditLog{file: file, headPath: headPath, key: key, seq: head.Records, prev: head.Hash}
+
+	if len(tail) == 0 {
+		return l, nil
+	}
+
+	if err := file.Truncate(size); err != nil {
+		_ = file.Close()
+		return nil, errors.New("could not remove the truncated record of the audit log " + path + " : " + err.Error())
+	}
+
+	sum := sha256.Sum256(tail)
+
+	Warnf("removed the truncated record %v of the audit log %v, %v bytes", head.Records+1, path, len(tail))
+
+	err = l.Write(AuditRecord{Event: AuditAdmin, Message: "truncated tail recovered", Fields: map[string]string{
+		"offset": strconv.FormatInt(size, 10),
+		"bytes":  strconv.Itoa(len(tail)),
+		"sha256": hex.EncodeToString(sum[:]),
+	}})
+
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Write appends an event to the audit log, it is chained to the last record
+func (l *AuditLog) Write(r AuditRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return errors.New("the audit log is closed")
+	}
+
+	r.Seq = l.seq + 1
+	r.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	r.Prev = l.prev
+
+	var err error
+
+	if r.Hash, r.MAC, err = r.seal(l.key); err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(r)
+
+	if err != nil {
+		return err
+	}
+
+	//A single write keeps the record whole, the file is opened in append mode
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return errors.New("could not write the audit record : " + err.Error())
+	}
+
+	l.seq, l.prev = r.Seq, r.Hash
+
+	if l.headPath == "" {
+		return nil
+	}
+
+	return writeAuditHead(l.headPath, AuditHead{Records: l.seq, Hash: l.prev}, l.key)
+}
+
+// Head returns the number of records and the hash of the last one
+func (l *AuditLog) Head() AuditHead {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return AuditHead{Records: l.seq, Hash: l.prev}
+}
+
+// Close closes the audit log
+func (l *AuditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+
+	err := l.file.Close()
+	l.file = nil
+
+	return err
+}
+
+// AuditHead identifies the last record of an audit log
+type AuditHead struct {
+	Records uint64
+	Hash    string
+}
+
+// String returns the head as SEQ:HASH, the format ParseAuditHead reads
+func (h AuditHead) String() string {
+	return strconv.FormatUint(h.Records, 10) + ":" + h.Hash
+}
+
+// ParseAuditHead parses a SEQ:HASH head
+func ParseAuditHead(s string) (AuditHead, error) {
+	i := strings.IndexByte(s, ':')
+
+	if i < 0 {
+		return AuditHead{}, errors.New("invalid audit head " + s + ", expected SEQ:HASH")
+	}
+
+	records, err := strconv.ParseUint(s[:i], 10, 64)
+
+	if err != nil || records == 0 || len(s[i+1:]) != hex.EncodedLen(sha256.Size) {
+		return AuditHead{}, errors.New("invalid audit head " + s + ", expected SEQ:HASH")
+	}
+
+	return AuditHead{Records: records, Hash: s[i+1:]}, nil
+}
+
+//headMAC returns the HMAC-SHA256 of the head
+func headMAC(h AuditHead, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(h.String()))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ReadAuditHead reads the head written to path by the audit log, followed by its MAC if the records are signed. It
+// returns an empty head if the file does not exist yet.
+func ReadAuditHead(path string, key []byte) (AuditHead, error) {
+	raw, err := ioutil.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return AuditHead{}, nil
+	}
+
+	if err != nil {
+		return AuditHead{}, errors.New("could not read the audit head : " + err.Error())
+	}
+
+	fields := strings.Fields(string(raw))
+
+	if len(fields) == 0 || len(fields) > 2 {
+		return AuditHead{}, errors.New("invalid audit head file " + path)
+	}
+
+	head, err := ParseAuditHead(fields[0])
+
+	if err != nil {
+		return AuditHead{}, err
+	}
+
+	if key == nil && len(fields) == 2 {
+		return AuditHead{}, errors.New("the audit head is signed, the HMAC key is required to verify it")
+	}
+
+	if key != nil && (len(fields) != 2 || !hmac.Equal([]byte(fields[1]), []byte(headMAC(head, key)))) {
+		return AuditHead{}, errors.New("the audit head " + path + " has an invalid MAC")
+	}
+
+	return head, nil
+}
+
+//writeAuditHead replaces the head file at path, the head is written to a temporary file renamed over it so that a
+//crash leaves either the previous head or the new one
+func writeAuditHead(path string, h AuditHead, key []byte) error {
+	line := h.String()
+
+	if key != nil {
+		line += " " + headMAC(h, key)
+	}
+
+	tmp := path + ".tmp"
+
+	if err := ioutil.WriteFile(tmp, []byte(line+"\n"), 0640); err != nil {
+		return errors.New("could not write the audit head : " + err.Error())
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return errors.New("could not write the audit head : " + err.Error())
+	}
+
+	return nil
+}
+
+// VerifyAuditLog reads the records of an audit log and returns its head. It returns an error at the first record
+// which is malformed, partially written, out of sequence, not chained to the previous record or whose hash does not
+// match its content. If key is set every record must carry a valid MAC, otherwise the log must not be signed. The
+// removal of the last whole records is not detected, VerifyAuditLogHead checks the log against a known head.
+func VerifyAuditLog(r io.Reader, key []byte) (AuditHead, error) {
+	return VerifyAuditLogHead(r, key, AuditHead{})
+}
+
+// VerifyAuditLogHead verifies an audit log like VerifyAuditLog and checks that it contains the expected head, the
+// log is truncated if it ends before it. The records written after the expected head are verified too.
+func VerifyAuditLogHead(r io.Reader, key []byte, expected AuditHead) (AuditHead, error) {
+	head, _, tail, err := verifyAuditLog(r, key, expected)
+
+	if err == nil && len(tail) > 0 {
+		err = errors.New("record " + strconv.FormatUint(head.Records+1, 10) + " is truncated")
+	}
+
+	return head, err
+}
+
+//verifyAuditLog verifies the records of an audit log like VerifyAuditLogHead, a last line without newline is not an
+//error. It returns the head and the size of the verified records, and the truncated last line.
+func verifyAuditLog(r io.Reader, key []byte, expected AuditHead) (AuditHead, int64, []byte, error) {
+	var head AuditHead
+	var size int64
+
+	reader := bufio.NewReader(r)
+
+	for {
+		line, err := reader.ReadBytes('\n')
+
+		if err == io.EOF && head.Records < expected.Records {
+			return head, size, nil, errors.New("the log ends at record " + strconv.FormatUint(head.Records, 10) +
+				" before the expected head " + expected.String() + ", the last records were removed")
+		}
+
+		if err == io.EOF {
+			return head, size, line, nil
+		}
+
+		if err != nil {
+			return head, size, nil, err
+		}
+
+		n := strconv.FormatUint(head.Records+1, 10)
+
+		var record AuditRecord
+
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.DisallowUnknownFields()
+
+		if err := decoder.Decode(&record); err != nil {
+			return head, size, nil, errors.New("record " + n + " is malformed : " + err.Error())
+		}
+
+		if record.Seq != head.Records+1 {
+			return head, size, nil, errors.New("record " + n + " has the sequence number " +
+				strconv.FormatUint(record.Seq, 10))
+		}
+
+		if record.Prev != head.Hash {
+			return head, size, nil, errors.New("record " + n + " is not chained to the previous record")
+		}
+
+		hash, mac, err := record.seal(key)
+
+		if err != nil {
+			return head, size, nil, err
+		}
+
+		if record.Hash != hash {
+			return head, size, nil, errors.New("record " + n + " was modified, its hash does not match")
+		}
+
+		if key == nil && record.MAC != "" {
+			return head, size, nil, errors.New("record " + n + " is signed, the HMAC key is required to verify it")
+		}
+
+		if !hmac.Equal([]byte(record.MAC), []byte(mac)) {
+			return head, size, nil, errors.New("record " + n + " has an invalid MAC")
+		}
+
+		if record.Seq == expected.Records && record.Hash != expected.Hash {
+			return head, size, nil, errors.New("record " + n + " does not match the expected head " +
+				expected.String())
+		}
+
+		head.Records, head.Hash = record.Seq, record.Hash
+		size += int64(len(line))
+	}
+}
+
+// ReadAuditKey reads the HMAC key of the audit records from a file, surrounding whitespace is ignored
+func ReadAuditKey(path string) ([]byte, error) {
+	raw, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, errors.New("could not read the audit key : " + err.Error())
+	}
+
+	key := bytes.TrimSpace(raw)
+
+	if len(key) < minAuditKeySize {
+		return nil, errors.New("the audit key must contain at least " + strconv.Itoa(minAuditKeySize) + " bytes")
+	}
+
+	return key, nil
+}
+
+//InitAuditLog opens the audit log at path and keeps its head in headPath, the records are signed with the key of
+//keyFile if it is set.
+func InitAuditLog(path string, headPath string, keyFile string) error {
+	var key []byte
+	var err error
+
+	if keyFile != "" {
+		if key, err = ReadAuditKey(keyFile); err != nil {
+			return err
+		}
+	}
+
+	if auditLog, err = OpenAuditLog(path, headPath, key); err != nil {
+		return err
+	}
+
+	head := auditLog.Head()
+	Infof("audit log opened with %v records, head %v", head.Records, head.Hash)
+
+	return nil
+}
+
+//CloseAuditLog closes the audit log, the audit events are then only written to the operational logs.
+func CloseAuditLog() error {
+	if auditLog == nil {
+		return nil
+	}
+
+	head := auditLog.Head()
+	Infof("audit log closed with %v records, head %v", head.Records, head.Hash)
+
+	return auditLog.Close()
+}
+
+//writeAuditRecord appends a record to the audit log if it is opened, an error is written to the operational logs
+func writeAuditRecord(r AuditRecord) {
+	if auditLog == nil {
+		return
+	}
+
+	if err := auditLog.Write(r); err != nil {
+		logger.Error().Err(err).Str("event", r.Event).Msgf("could not audit: %v", r.Message)
+	}
+}
@@ -0,0 +1,231 @@
+package logger
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//newTestAuditLog writes n records to a new audit log and returns its lines
+func newTestAuditLog(t *testing.T, key []byte, n int) []string {
+	path := newTestLogDir(t) + "/audit.log"
+
+	l, err := OpenAuditLog(path, "", key)
+	assert.Nil(t, err)
+
+	for i := 0; i < n; i++ {
+		err = l.Write(AuditRecord{Event: AuditAdmin, User: "alice", Message: "admin command succeeded: bastion help"})
+		assert.Nil(t, err)
+	}
+
+	err = l.Close()
+	assert.Nil(t, err)
+
+	content, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+
+	return strings.SplitAfter(string(content), "\n")[:n]
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	key := bytes.Repeat([]byte("k"), minAuditKeySize)
+	otherKey := bytes.Repeat([]byte("o"), minAuditKeySize)
+
+	tests := []struct {
+		name        string
+		signKey     []byte
+		verifyKey   []byte
+		tamper      func(lines []string) []string
+		wantErr     bool
+		wantRecords uint64
+	}{
+		{name: "test intact log", tamper: func(l []string) []string { return l }, wantErr: false, wantRecords: 4},
+		{name: "test intact signed log", signKey: key, verifyKey: key, tamper: func(l []string) []string { return l },
+			wantErr: false, wantRecords: 4},
+		{name: "test modified record", tamper: func(l []string) []string {
+			l[1] = strings.Replace(l[1], "alice", "bob", 1)
+			return l
+		}, wantErr: true, wantRecords: 1},
+		{name: "test removed record", tamper: func(l []string) []string {
+			return append(l[:1], l[2:]...)
+		}, wantErr: true, wantRecords: 1},
+		{name: "test removed first record", tamper: func(l []string) []string {
+			return l[1:]
+		}, wantErr: true, wantRecords: 0},
+		{name: "test swapped records", tamper: func(l []string) []string {
+			l[1], l[2] = l[2], l[1]
+			return l
+		}, wantErr: true, wantRecords: 1},
+		{name: "test truncated record", tamper: func(l []string) []string {
+			l[3] = l[3][:len(l[3])/2]
+			return l
+		}, wantErr: true, wantRecords: 3},
+		{name: "test wrong key", signKey: key, verifyKey: otherKey, tamper: func(l []string) []string { return l },
+			wantErr: true, wantRecords: 0},
+		{name: "test signed log without key", signKey: key, tamper: func(l []string) []string { return l },
+			wantErr: true, wantRecords: 0},
+		{name: "test unsigned log with key", verifyKey: key, tamper: func(l []string) []string { return l },
+			wantErr: true, wantRecords: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := tt.tamper(newTestAuditLog(t, tt.signKey, 4))
+
+			head, err := VerifyAuditLog(strings.NewReader(strings.Join(lines, "")), tt.verifyKey)
+
+			if tt.wantErr {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+			}
+
+			assert.Equal(t, tt.wantRecords, head.Records)
+		})
+	}
+}
+
+func TestOpenAuditLog(t *testing.T) {
+	path := newTestLogDir(t) + "/audit.log"
+
+	//The chain goes on after a restart
+	for i := 0; i < 2; i++ {
+		l, err := OpenAuditLog(path, "", nil)
+		assert.Nil(t, err)
+
+		err = l.Write(AuditRecord{Event: AuditSession, Message: "session started"})
+		assert.Nil(t, err)
+
+		err = l.Close()
+		assert.Nil(t, err)
+	}
+
+	file, err := os.Open(path)
+	assert.Nil(t, err)
+
+	head, err := VerifyAuditLog(file, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), head.Records)
+
+	_ = file.Close()
+
+	//A tampered log is not extended
+	err = ioutil.WriteFile(path, []byte("{}\n"), 0600)
+	assert.Nil(t, err)
+
+	_, err = OpenAuditLog(path, "", nil)
+	assert.NotNil(t, err)
+}
+
+func TestOpenAuditLog_TruncatedTail(t *testing.T) {
+	key := bytes.Repeat([]byte("k"), minAuditKeySize)
+	lines := newTestAuditLog(t, key, 3)
+	path := newTestLogDir(t) + "/audit.log"
+
+	//The bastion crashed while writing the third record
+	partial := lines[2][:len(lines[2])/2]
+	err := ioutil.WriteFile(path, []byte(lines[0]+lines[1]+partial), 0600)
+	assert.Nil(t, err)
+
+	l, err := OpenAuditLog(path, path+".head", key)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), l.Head().Records)
+
+	err = l.Write(AuditRecord{Event: AuditSession, Message: "session started"})
+	assert.Nil(t, err)
+
+	err = l.Close()
+	assert.Nil(t, err)
+
+	content, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+
+	head, err := VerifyAuditLog(bytes.NewReader(content), key)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4), head.Records)
+
+	recovered := strings.SplitAfter(string(content), "\n")[2]
+	assert.Contains(t, recovered, `"message":"truncated tail recovered"`)
+	assert.Contains(t, recovered, `"bytes":"`+strconv.Itoa(len(partial))+`"`)
+	assert.NotContains(t, string(content), partial)
+}
+
+func TestOpenAuditLog_Head(t *testing.T) {
+	key := bytes.Repeat([]byte("k"), minAuditKeySize)
+	dir := newTestLogDir(t)
+	path, headPath := dir+"/audit.log", dir+"/audit.head"
+
+	l, err := OpenAuditLog(path, headPath, key)
+	assert.Nil(t, err)
+
+	for i := 0; i < 3; i++ {
+		err = l.Write(AuditRecord{Event: AuditSession, Message: "session started"})
+		assert.Nil(t, err)
+	}
+
+	written := l.Head()
+	assert.Nil(t, l.Close())
+
+	head, err := ReadAuditHead(headPath, key)
+	assert.Nil(t, err)
+	assert.Equal(t, written, head)
+
+	parsed, err := ParseAuditHead(head.String())
+	assert.Nil(t, err)
+	assert.Equal(t, head, parsed)
+
+	//The last record is removed at a record boundary, the chain alone is still valid
+	content, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+
+	lines := strings.SplitAfter(string(content), "\n")
+	truncated := strings.Join(lines[:2], "")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(truncated), 0600))
+
+	_, err = VerifyAuditLog(strings.NewReader(truncated), key)
+	assert.Nil(t, err)
+
+	_, err = VerifyAuditLogHead(strings.NewReader(truncated), key, head)
+	assert.NotNil(t, err)
+
+	_, err = OpenAuditLog(path, headPath, key)
+	assert.NotNil(t, err)
+
+	//A record replaced at the head is detected too
+	other := AuditHead{Records: head.Records, Hash: strings.Repeat("0", len(head.Hash))}
+	_, err = VerifyAuditLogHead(strings.NewReader(string(content)), key, other)
+	assert.NotNil(t, err)
+
+	//The head file cannot be rewound without the key
+	assert.Nil(t, ioutil.WriteFile(headPath, []byte("2:"+head.Hash+"\n"), 0600))
+
+	_, err = ReadAuditHead(headPath, key)
+	assert.NotNil(t, err)
+}
+
+func TestParseAuditHead(t *testing.T) {
+	hash := strings.Repeat("ab", 32)
+
+	tests := []struct {
+		name    string
+		head    string
+		wantErr bool
+	}{
+		{name: "test head", head: "12:" + hash, wantErr: false},
+		{name: "test missing hash", head: "12", wantErr: true},
+		{name: "test invalid sequence", head: "x:" + hash, wantErr: true},
+		{name: "test empty log", head: "0:" + hash, wantErr: true},
+		{name: "test short hash", head: "12:abcd", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseAuditHead(tt.head)
+
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package logger
+
+//Results of the authentication events
+const (
+	AuthSuccess = "success"
+	AuthPartial = "partial"
+	AuthFailure = "failure"
+)
+
+// AuthEvent is an authentication attempt of a client. Fingerprint is the fingerprint of the public key of the
+// attempt, or of the first factor for the second factor attempts. Reason explains why the attempt did not succeed.
+type AuthEvent struct {
+	User        string
+	IP          string
+	Method      string
+	Fingerprint string
+	Result      string
+	Reason      string
+}
+
+//LogAuthEvent logs an authentication attempt as an audit event, the failed attempts at warn level, and records it in
+//the audit log.
+func LogAuthEvent(e AuthEvent) {
+	event := logger.Info()
+
+	if e.Result == AuthFailure {
+		event = logger.Warn()
+	}
+
+	event.Bool("audit", true).
+		Str("event", AuditAuth).
+		Str("user", e.User).
+		Str("ip", e.IP).
+		Str("method", e.Method).
+		Str("publicKeyFingerprint", e.Fingerprint).
+		Str("result", e.Result).
+		Str("reason", e.Reason).
+		Msg("authentication " + e.Result)
+
+	writeAuditRecord(AuditRecord{
+		Event:   AuditAuth,
+		User:    e.User,
+		IP:      e.IP,
+		Message: "authentication " + e.Result,
+		Fields: map[string]string{
+			"method":               e.Method,
+			"publicKeyFingerprint": e.Fingerprint,
+			"result":               e.Result,
+			"reason":               e.Reason,
+		},
+	})
+}
@@ -2,11 +2,13 @@ package logger
 
 import (
 	"context"
+	"fmt"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
 type ClientInfoGetter interface {
+	GetSessionID() string
 	GetUser() string
 	GetIp() string
 	GetPublicKeyFingerprint() string
@@ -31,7 +33,8 @@ func UpdateClientLogCtx(ctx context.Context, c ClientInfoGetter) context.Context
 	l := log.Ctx(ctx)
 
 	l.UpdateContext(func(zCtx zerolog.Context) zerolog.Context {
-		return zCtx.Str("user", c.GetUser()).
+		return zCtx.Str("session", c.GetSessionID()).
+			Str("user", c.GetUser()).
 			Str("ip", c.GetIp()).
 			Str("backendPublicKeyFingerprint", c.GetPublicKeyFingerprint()).
 			Str("command", c.GetCommand()).
@@ -157,3 +160,29 @@ func PanicfWithCtx(ctx context.Context, msg string, a ...interface{}) {
 func PanicfWithCtxWithErr(ctx context.Context, err error, msg string, a ...interface{}) {
 	log.Ctx(ctx).Panic().Err(err).Msgf(msg, a...)
 }
+
+//Audit logging
+
+//auditClientKey is the context key of the client of the audit events
+type auditClientKey struct{}
+
+//WithAuditClient returns a context carrying the client whose session, user and IP are recorded in the audit events.
+func WithAuditClient(ctx context.Context, c ClientInfoGetter) context.Context {
+	return context.WithValue(ctx, auditClientKey{}, c)
+}
+
+//AuditfWithCtx logs a formatted security event of a kind (auth, session...) at info level and records it in the
+//audit log. The event carries the audit field so that it can be told apart from the operational logs.
+func AuditfWithCtx(ctx context.Context, event string, msg string, a ...interface{}) {
+	message := fmt.Sprintf(msg, a...)
+
+	log.Ctx(ctx).Info().Bool("audit", true).Str("event", event).Msg(message)
+
+	r := AuditRecord{Event: event, Message: message}
+
+	if c, ok := ctx.Value(auditClientKey{}).(ClientInfoGetter); ok {
+		r.Session, r.User, r.IP = c.GetSessionID(), c.GetUser(), c.GetIp()
+	}
+
+	writeAuditRecord(r)
+}
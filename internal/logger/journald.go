@@ -0,0 +1,170 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/rs/zerolog"
+)
+
+// JournaldSocket is the socket of the native journald protocol
+const JournaldSocket = "/run/systemd/journal/socket"
+
+//maxJournalFieldName is the maximum length of the journal field names
+const maxJournalFieldName = 64
+
+// JournaldWriter sends the log events to journald with its native protocol. The fields of the events, such as the
+// client context, are sent as journal fields named in upper snake case (backendUser becomes BACKEND_USER). It is
+// safe for concurrent use.
+type JournaldWriter struct {
+	Path string
+	Tag  string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// DialJournald connects to the journald socket at path, the messages are identified by tag
+func DialJournald(path string, tag string) (*JournaldWriter, error) {
+	w := &JournaldWriter{Path: path, Tag: tag}
+
+	if err := w.connect(); err != nil {
+		return nil, errors.New("could not connect to journald : " + err.Error())
+	}
+
+	return w, nil
+}
+
+//connect opens the connection to the socket, the caller must hold mu
+func (w *JournaldWriter) connect() error {
+	conn, err := net.Dial("unixgram", w.Path)
+
+	if err != nil {
+		return err
+	}
+
+	w.conn = conn
+
+	return nil
+}
+
+// Write sends an event without level at the info priority
+func (w *JournaldWriter) Write(p []byte) (int, error) {
+	return w.WriteLevel(zerolog.NoLevel, p)
+}
+
+// WriteLevel sends an event at the syslog priority of its level. The connection is opened again once if sending
+// fails, the errors are written to the standard error so that the other log outputs still get the event.
+func (w *JournaldWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	message, fields, err := parseEvent(p)
+
+	if err != nil {
+		logOutputError("could not parse the event sent to journald", err)
+		return len(p), nil
+	}
+
+	var b bytes.Buffer
+
+	writeJournalField(&b, "MESSAGE", message)
+	writeJournalField(&b, "PRIORITY", strconv.Itoa(syslogSeverity(level)))
+	writeJournalField(&b, "SYSLOG_IDENTIFIER", w.Tag)
+
+	for _, f := range fields {
+		writeJournalField(&b, journalFieldName(f[0]), f[1])
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.conn == nil {
+		err = errors.New("not connected")
+	} else {
+		_, err = w.conn.Write(b.Bytes())
+	}
+
+	if err != nil {
+		if w.conn != nil {
+			_ = w.conn.Close()
+			w.conn = nil
+		}
+
+		if err = w.connect(); err == nil {
+			_, err = w.conn.Write(b.Bytes())
+		}
+
+		if err != nil {
+			logOutputError("could not send the event to journald", err)
+		}
+	}
+
+	return len(p), nil
+}
+
+//writeJournalField appends a field to a journal entry. The values containing a new line are written with their
+//length as journald requires.
+func writeJournalField(b *bytes.Buffer, name string, value string) {
+	b.WriteString(name)
+
+	if !strings.Contains(value, "\n") {
+		b.WriteString("=" + value + "\n")
+		return
+	}
+
+	var size [8]byte
+
+	binary.LittleEndian.PutUint64(size[:], uint64(len(value)))
+
+	b.WriteString("\n")
+	b.Write(size[:])
+	b.WriteString(value + "\n")
+}
+
+//journalFieldName returns the journal field name of an event field: upper case letters, digits and underscores, not
+//starting with an underscore which is reserved to journald nor with a digit
+func journalFieldName(name string) string {
+	var b strings.Builder
+
+	for i, r := range name {
+		switch {
+		case r >= 'A' && r <= 'Z' && i > 0:
+			b.WriteString("_" + string(r))
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			b.WriteRune(unicode.ToUpper(r))
+		default:
+			b.WriteRune('_')
+		}
+	}
+
+	n := strings.TrimLeft(b.String(), "_0123456789")
+
+	if n == "" {
+		return "FIELD"
+	}
+
+	if len(n) > maxJournalFieldName {
+		n = n[:maxJournalFieldName]
+	}
+
+	return n
+}
+
+// Close closes the connection to the socket
+func (w *JournaldWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.conn == nil {
+		return nil
+	}
+
+	err := w.conn.Close()
+	w.conn = nil
+
+	return err
+}
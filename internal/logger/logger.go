@@ -1,6 +1,7 @@
 package logger
 
 import (
+	"io"
 	"os"
 
 	"github.com/rs/zerolog"
@@ -8,11 +9,22 @@ import (
 
 var logger zerolog.Logger
 
+//logFile is the log file of the configured logger
+var logFile *RotatingFile
+
+//logOutputs are the outputs of the configured logger to close when the bastion stops
+var logOutputs []io.Closer
+
 //LogConfigGetter represents the logger configuration.
 type LogConfigGetter interface {
 	IsJSON() bool
 	Level() int
 	ReportCaller() bool
+	LogFile() string
+	LogRotation() Rotation
+	Console() bool
+	LogSyslog() SyslogOutput
+	LogJournald() bool
 }
 
 //InitDefaultLogger initialize a default logger to display any error before the configurable logger initialization.
@@ -25,32 +37,111 @@ func InitDefaultLogger() {
 	zerolog.SetGlobalLevel(zerolog.Level(1))
 }
 
-//InitLogger initialize the logger with the passed config.
-func InitLogger(config LogConfigGetter) {
+//InitLogger initialize the logger with the passed config. The messages are written to the log file and, if the
+//console output is enabled, to the standard error.
+func InitLogger(config LogConfigGetter) error {
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 
+	file, err := OpenRotatingFile(config.LogFile(), config.LogRotation())
+
+	if err != nil {
+		return err
+	}
+
+	var writers []io.Writer
+
 	if config.IsJSON() {
-		logger = zerolog.New(os.Stderr).With().Timestamp().Logger()
+		writers = append(writers, file)
 	} else {
-		logger = zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).With().Timestamp().Logger()
+		writers = append(writers, zerolog.ConsoleWriter{Out: file, NoColor: true})
 	}
 
-	lvl := config.Level()
+	if config.Console() && config.IsJSON() {
+		writers = append(writers, os.Stderr)
+	} else if config.Console() {
+		writers = append(writers, zerolog.ConsoleWriter{Out: os.Stderr})
+	}
 
-	if lvl < -1 {
-		lvl = -1
+	logOutputs = []io.Closer{file}
+
+	//The syslog and journald outputs receive the JSON events and send their fields as structured data
+	if s := config.LogSyslog(); s.Network != "" {
+		w, err := DialSyslog(s)
+
+		if err != nil {
+			closeLogOutputs()
+			return err
+		}
+
+		writers = append(writers, w)
+		logOutputs = append(logOutputs, w)
 	}
 
-	if lvl > 5 {
-		lvl = 5
+	if config.LogJournald() {
+		w, err := DialJournald(JournaldSocket, config.LogSyslog().Tag)
+
+		if err != nil {
+			closeLogOutputs()
+			return err
+		}
+
+		writers = append(writers, w)
+		logOutputs = append(logOutputs, w)
 	}
 
-	zerolog.SetGlobalLevel(zerolog.Level(lvl))
+	logFile = file
+	logger = zerolog.New(zerolog.MultiLevelWriter(writers...)).With().Timestamp().Logger()
+
+	SetLevel(config.Level())
 
 	if config.ReportCaller() {
 		logger = logger.With().Caller().Logger()
 		zerolog.CallerSkipFrameCount = 3
 	}
+
+	return nil
+}
+
+//ReopenLogFile reopens the log file, after it was moved by an external rotation tool.
+func ReopenLogFile() error {
+	if logFile == nil {
+		return nil
+	}
+
+	return logFile.Reopen()
+}
+
+//CloseLogOutputs closes the log file and the syslog and journald connections, the messages logged after are lost.
+func CloseLogOutputs() error {
+	return closeLogOutputs()
+}
+
+//closeLogOutputs closes the outputs of the configured logger and returns the first error
+func closeLogOutputs() error {
+	var firstErr error
+
+	for _, o := range logOutputs {
+		if err := o.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	logOutputs = nil
+
+	return firstErr
+}
+
+//SetLevel sets the minimum level of the logged messages, from -1 (trace) to 5 (panic). It can be called while logging.
+func SetLevel(lvl int) {
+	if lvl < -1 {
+		lvl = -1
+	}
+
+	if lvl > 5 {
+		lvl = 5
+	}
+
+	zerolog.SetGlobalLevel(zerolog.Level(lvl))
 }
 
 //Basic logging
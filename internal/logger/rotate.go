@@ -0,0 +1,278 @@
+package logger
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//backupTimeFormat is the suffix of the rotated files, sorting their names sorts them by date
+const backupTimeFormat = "20060102-150405.000"
+
+//compressedSuffix is the suffix of the compressed rotated files
+const compressedSuffix = ".gz"
+
+// Rotation contains the rotation policy of a log file. The file is rotated when it reaches MaxSize bytes or when it
+// was opened more than MaxAge ago, a zero value disables the rotation on this criterion. The MaxBackups most recent
+// rotated files are kept, or all of them if it is zero, compressed with gzip if Compress is set.
+type Rotation struct {
+	MaxSize    int64
+	MaxAge     time.Duration
+	MaxBackups int
+	Compress   bool
+}
+
+// RotatingFile is a log file rotated according to its Rotation. The rotated files are renamed with their rotation
+// date. It is safe for concurrent use.
+type RotatingFile struct {
+	Path string
+	Rotation
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+
+	//cleanup compresses and removes the rotated files in the background, one run at a time
+	cleanup sync.WaitGroup
+	cleanMu sync.Mutex
+}
+
+// OpenRotatingFile opens the log file at path in append mode, it is created if needed
+func OpenRotatingFile(path string, r Rotation) (*RotatingFile, error) {
+	f := &RotatingFile{Path: path, Rotation: r}
+
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+//open opens the file at Path, the caller must hold mu
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+
+	if err != nil {
+		return errors.New("could not open the log file : " + err.Error())
+	}
+
+	info, err := file.Stat()
+
+	if err != nil {
+		_ = file.Close()
+		return errors.New("could not open the log file : " + err.Error())
+	}
+
+	f.file = file
+	f.size = info.Size()
+	f.opened = time.Now()
+
+	return nil
+}
+
+// Write appends p to the file, after rotating it if p would exceed MaxSize or if the file is too old
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, errors.New("the log file is closed")
+	}
+
+	//An empty file is never rotated
+	now := time.Now()
+	tooBig := f.MaxSize > 0 && f.size+int64(len(p)) > f.MaxSize
+	tooOld := f.MaxAge > 0 && now.Sub(f.opened) >= f.MaxAge
+
+	//A failed rotation is reported but the messages are still appended to the current file if possible
+	if f.size > 0 && (tooBig || tooOld) {
+		if err := f.rotate(now); err != nil {
+			logOutputError("could not rotate the log file", err)
+		}
+
+		if f.file == nil {
+			return 0, errors.New("the log file is closed")
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+
+	return n, err
+}
+
+//rotate renames the current file with the date and opens a new one, the caller must hold mu
+func (f *RotatingFile) rotate(now time.Time) error {
+	if err := f.file.Close(); err != nil {
+		return errors.New("could not close the log file : " + err.Error())
+	}
+
+	f.file = nil
+
+	renameErr := os.Rename(f.Path, f.Path+"."+now.Format(backupTimeFormat))
+
+	if err := f.open(); err != nil {
+		return err
+	}
+
+	if renameErr != nil {
+		return renameErr
+	}
+
+	f.cleanup.Add(1)
+
+	go func() {
+		defer f.cleanup.Done()
+
+		f.cleanBackups()
+	}()
+
+	return nil
+}
+
+// Reopen closes and opens the file at Path again, after it was moved by an external rotation tool such as logrotate
+func (f *RotatingFile) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file != nil {
+		if err := f.file.Close(); err != nil {
+			return errors.New("could not close the log file : " + err.Error())
+		}
+
+		f.file = nil
+	}
+
+	return f.open()
+}
+
+// Close closes the file once the rotated files are cleaned up
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.cleanup.Wait()
+
+	if f.file == nil {
+		return nil
+	}
+
+	err := f.file.Close()
+	f.file = nil
+
+	return err
+}
+
+//backups returns the paths of the rotated files, from the oldest to the most recent
+func (f *RotatingFile) backups() ([]string, error) {
+	entries, err := ioutil.ReadDir(filepath.Dir(f.Path))
+
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := filepath.Base(f.Path) + "."
+
+	var backups []string
+
+	//The files rotated by other tools are left alone
+	for _, e := range entries {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(e.Name(), prefix), compressedSuffix)
+
+		if _, err := time.Parse(backupTimeFormat, suffix); err != nil || e.IsDir() || suffix == e.Name() {
+			continue
+		}
+
+		backups = append(backups, filepath.Join(filepath.Dir(f.Path), e.Name()))
+	}
+
+	sort.Strings(backups)
+
+	return backups, nil
+}
+
+//cleanBackups removes the rotated files above MaxBackups and compresses the others if needed
+func (f *RotatingFile) cleanBackups() {
+	f.cleanMu.Lock()
+	defer f.cleanMu.Unlock()
+
+	backups, err := f.backups()
+
+	if err != nil {
+		logOutputError("could not list the rotated log files", err)
+		return
+	}
+
+	if f.MaxBackups > 0 && len(backups) > f.MaxBackups {
+		for _, b := range backups[:len(backups)-f.MaxBackups] {
+			if err := os.Remove(b); err != nil {
+				logOutputError("could not remove a rotated log file", err)
+			}
+		}
+
+		backups = backups[len(backups)-f.MaxBackups:]
+	}
+
+	if !f.Compress {
+		return
+	}
+
+	for _, b := range backups {
+		if strings.HasSuffix(b, compressedSuffix) {
+			continue
+		}
+
+		if err := compressFile(b); err != nil {
+			logOutputError("could not compress a rotated log file", err)
+		}
+	}
+}
+
+//logOutputError writes an error of a log output to the standard error, it cannot be logged in the output
+func logOutputError(msg string, err error) {
+	_, _ = os.Stderr.WriteString("open-bastion: " + msg + " : " + err.Error() + "\n")
+}
+
+//compressFile replaces a file by its gzip compressed version
+func compressFile(path string) error {
+	in, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	out, err := os.OpenFile(path+compressedSuffix, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(out)
+
+	_, err = io.Copy(gz, in)
+
+	if err == nil {
+		err = gz.Close()
+	}
+
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(path + compressedSuffix)
+		return err
+	}
+
+	return os.Remove(path)
+}
@@ -0,0 +1,117 @@
+package logger
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//newTestLogDir returns a temporary directory removed at the end of the test
+func newTestLogDir(t *testing.T) string {
+	tempDir, err := ioutil.TempDir("", "open-bastion-testing")
+
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	t.Cleanup(func() { _ = os.RemoveAll(tempDir) })
+
+	return tempDir
+}
+
+func TestRotatingFile_Write(t *testing.T) {
+	tests := []struct {
+		name        string
+		rotation    Rotation
+		writes      int
+		wantBackups int
+		wantSuffix  string
+	}{
+		{name: "test no rotation", rotation: Rotation{MaxSize: 100}, writes: 5, wantBackups: 0},
+		{name: "test rotation by size", rotation: Rotation{MaxSize: 25}, writes: 5, wantBackups: 2},
+		{name: "test rotation by age", rotation: Rotation{MaxAge: time.Nanosecond}, writes: 3, wantBackups: 2},
+		{name: "test retention", rotation: Rotation{MaxSize: 10, MaxBackups: 2}, writes: 5, wantBackups: 2},
+		{name: "test compression", rotation: Rotation{MaxSize: 10, Compress: true}, writes: 3, wantBackups: 2,
+			wantSuffix: compressedSuffix},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newTestLogDir(t)
+			f, err := OpenRotatingFile(dir+"/open-bastion.log", tt.rotation)
+			assert.Nil(t, err)
+
+			//A file rotated by another tool is never removed
+			err = ioutil.WriteFile(dir+"/open-bastion.log.1", []byte("old"), 0600)
+			assert.Nil(t, err)
+
+			for i := 0; i < tt.writes; i++ {
+				_, err = f.Write([]byte("0123456789\n"))
+				assert.Nil(t, err)
+
+				//The rotated files are named after the rotation date, to the millisecond
+				time.Sleep(2 * time.Millisecond)
+			}
+
+			err = f.Close()
+			assert.Nil(t, err)
+
+			backups, err := f.backups()
+			assert.Nil(t, err)
+			assert.Len(t, backups, tt.wantBackups)
+
+			for _, b := range backups {
+				assert.True(t, strings.HasSuffix(b, tt.wantSuffix))
+			}
+
+			_, err = os.Stat(dir + "/open-bastion.log.1")
+			assert.Nil(t, err)
+
+			if tt.wantSuffix == compressedSuffix {
+				compressed, err := os.Open(backups[0])
+				assert.Nil(t, err)
+				defer compressed.Close()
+
+				gz, err := gzip.NewReader(compressed)
+				assert.Nil(t, err)
+
+				content, err := ioutil.ReadAll(gz)
+				assert.Nil(t, err)
+				assert.Equal(t, "0123456789\n", string(content))
+			}
+		})
+	}
+}
+
+func TestRotatingFile_Reopen(t *testing.T) {
+	dir := newTestLogDir(t)
+	f, err := OpenRotatingFile(dir+"/open-bastion.log", Rotation{})
+	assert.Nil(t, err)
+
+	defer f.Close()
+
+	_, err = f.Write([]byte("before\n"))
+	assert.Nil(t, err)
+
+	//An external tool moves the file, the messages go to the moved file until the reopening
+	err = os.Rename(dir+"/open-bastion.log", dir+"/open-bastion.log.1")
+	assert.Nil(t, err)
+
+	err = f.Reopen()
+	assert.Nil(t, err)
+
+	_, err = f.Write([]byte("after\n"))
+	assert.Nil(t, err)
+
+	moved, err := ioutil.ReadFile(dir + "/open-bastion.log.1")
+	assert.Nil(t, err)
+	assert.Equal(t, "before\n", string(moved))
+
+	current, err := ioutil.ReadFile(dir + "/open-bastion.log")
+	assert.Nil(t, err)
+	assert.Equal(t, "after\n", string(current))
+}
@@ -0,0 +1,283 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Networks of the syslog output
+const (
+	SyslogUnix = "unix"
+	SyslogUDP  = "udp"
+	SyslogTCP  = "tcp"
+)
+
+//syslogSDID is the ID of the structured data element of the event fields, 32473 is the enterprise number reserved
+//for documentation by RFC 5612
+const syslogSDID = "open-bastion@32473"
+
+//syslogFacilities maps the syslog facility names to their codes
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7, "uucp": 8,
+	"cron": 9, "authpriv": 10, "ftp": 11, "local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20,
+	"local5": 21, "local6": 22, "local7": 23,
+}
+
+// SyslogFacility returns the code of a syslog facility name
+func SyslogFacility(name string) (int, error) {
+	f, ok := syslogFacilities[name]
+
+	if !ok {
+		return 0, errors.New("unknown syslog facility " + name)
+	}
+
+	return f, nil
+}
+
+//syslogSeverity returns the syslog severity of a zerolog level
+func syslogSeverity(level zerolog.Level) int {
+	switch level {
+	case zerolog.PanicLevel:
+		return 0 //emerg
+	case zerolog.FatalLevel:
+		return 2 //crit
+	case zerolog.ErrorLevel:
+		return 3 //err
+	case zerolog.WarnLevel:
+		return 4 //warning
+	case zerolog.InfoLevel, zerolog.NoLevel:
+		return 6 //info
+	default:
+		return 7 //debug
+	}
+}
+
+// SyslogOutput contains the settings of the syslog output. Network is unix, udp or tcp and Address the socket path
+// or the host:port of the syslog server. Tag is the APP-NAME of the messages.
+type SyslogOutput struct {
+	Network  string
+	Address  string
+	Facility int
+	Tag      string
+}
+
+// SyslogWriter sends the log events to a syslog server in the RFC 5424 format. The fields of the events, such as the
+// client context, are sent as structured data. It is safe for concurrent use.
+type SyslogWriter struct {
+	SyslogOutput
+
+	hostname string
+	mu       sync.Mutex
+	conn     net.Conn
+	stream   bool
+}
+
+// DialSyslog connects to the syslog server
+func DialSyslog(o SyslogOutput) (*SyslogWriter, error) {
+	hostname, err := os.Hostname()
+
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	w := &SyslogWriter{SyslogOutput: o, hostname: hostname}
+
+	if err := w.connect(); err != nil {
+		return nil, errors.New("could not connect to syslog : " + err.Error())
+	}
+
+	return w, nil
+}
+
+//connect opens the connection to the server, the caller must hold mu. A unix socket is tried in datagram mode first,
+//as /dev/log usually is.
+func (w *SyslogWriter) connect() error {
+	var err error
+
+	switch w.Network {
+	case SyslogUnix:
+		if w.conn, err = net.Dial("unixgram", w.Address); err == nil {
+			w.stream = false
+			return nil
+		}
+
+		w.conn, err = net.Dial("unix", w.Address)
+		w.stream = true
+	case SyslogUDP:
+		w.conn, err = net.Dial("udp", w.Address)
+		w.stream = false
+	case SyslogTCP:
+		w.conn, err = net.DialTimeout("tcp", w.Address, 5*time.Second)
+		w.stream = true
+	default:
+		err = errors.New("unknown syslog network " + w.Network)
+	}
+
+	return err
+}
+
+// Write sends an event without level at the info severity
+func (w *SyslogWriter) Write(p []byte) (int, error) {
+	return w.WriteLevel(zerolog.NoLevel, p)
+}
+
+// WriteLevel sends an event at the severity of its level. The connection is opened again once if sending fails, the
+// errors are written to the standard error so that the other log outputs still get the event.
+func (w *SyslogWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	message, fields, err := parseEvent(p)
+
+	if err != nil {
+		logOutputError("could not parse the event sent to syslog", err)
+		return len(p), nil
+	}
+
+	msg := w.format(level, time.Now(), message, fields)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.send(msg); err != nil {
+		if w.conn != nil {
+			_ = w.conn.Close()
+			w.conn = nil
+		}
+
+		if err = w.connect(); err == nil {
+			err = w.send(msg)
+		}
+
+		if err != nil {
+			logOutputError("could not send the event to syslog", err)
+		}
+	}
+
+	return len(p), nil
+}
+
+//send writes a message on the connection, framed by its length on the stream connections (RFC 6587)
+func (w *SyslogWriter) send(msg []byte) error {
+	if w.conn == nil {
+		return errors.New("not connected")
+	}
+
+	if w.stream {
+		msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	}
+
+	_, err := w.conn.Write(msg)
+
+	return err
+}
+
+//format returns the RFC 5424 message of an event
+func (w *SyslogWriter) format(level zerolog.Level, now time.Time, message string, fields [][2]string) []byte {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %d - ", w.Facility*8+syslogSeverity(level),
+		now.Format("2006-01-02T15:04:05.000000Z07:00"), w.hostname, w.Tag, os.Getpid())
+
+	if len(fields) == 0 {
+		b.WriteString("-")
+	} else {
+		b.WriteString("[" + syslogSDID)
+
+		for _, f := range fields {
+			b.WriteString(" " + sdName(f[0]) + "=\"" + sdEscaper.Replace(f[1]) + "\"")
+		}
+
+		b.WriteString("]")
+	}
+
+	if message != "" {
+		b.WriteString(" " + message)
+	}
+
+	return b.Bytes()
+}
+
+//sdEscaper escapes the characters of the structured data parameter values
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+//sdName returns a valid structured data parameter name: at most 32 printable characters except '=', ' ', ']' and '"'
+func sdName(name string) string {
+	n := []byte(name)
+
+	for i, c := range n {
+		if c <= ' ' || c > '~' || c == '=' || c == ']' || c == '"' {
+			n[i] = '_'
+		}
+	}
+
+	if len(n) > 32 {
+		n = n[:32]
+	}
+
+	return string(n)
+}
+
+// Close closes the connection to the server
+func (w *SyslogWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.conn == nil {
+		return nil
+	}
+
+	err := w.conn.Close()
+	w.conn = nil
+
+	return err
+}
+
+//parseEvent returns the message of a JSON event and its other fields sorted by name, the level and time are
+//given by the outputs
+func parseEvent(p []byte) (string, [][2]string, error) {
+	var event map[string]interface{}
+
+	decoder := json.NewDecoder(bytes.NewReader(p))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&event); err != nil {
+		return "", nil, err
+	}
+
+	message, _ := event[zerolog.MessageFieldName].(string)
+
+	var fields [][2]string
+
+	for name, value := range event {
+		if name == zerolog.MessageFieldName || name == zerolog.LevelFieldName || name == zerolog.TimestampFieldName {
+			continue
+		}
+
+		switch v := value.(type) {
+		case string:
+			fields = append(fields, [2]string{name, v})
+		case json.Number, bool:
+			fields = append(fields, [2]string{name, fmt.Sprint(v)})
+		case nil:
+			fields = append(fields, [2]string{name, "null"})
+		default:
+			raw, _ := json.Marshal(v)
+			fields = append(fields, [2]string{name, string(raw)})
+		}
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i][0] < fields[j][0]
+	})
+
+	return message, fields, nil
+}
@@ -0,0 +1,180 @@
+package logger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+//testEvent is a JSON event as zerolog writes it, with a client context field to escape
+const testEvent = `{"level":"warn","session":"1a2b","user":"alice","command":"ssh \"a]\"","port":22,` +
+	`"time":1600000000,"message":"client connected"}` + "\n"
+
+//listenSyslog starts a stand-in syslog server and returns its address and a function reading the next message
+func listenSyslog(t *testing.T, network string) (string, func() string) {
+	switch network {
+	case SyslogUnix:
+		path := newTestLogDir(t) + "/log.sock"
+		conn, err := net.ListenPacket("unixgram", path)
+		assert.Nil(t, err)
+
+		t.Cleanup(func() { _ = conn.Close() })
+
+		return path, func() string { return readPacket(t, conn) }
+	case SyslogUDP:
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		assert.Nil(t, err)
+
+		t.Cleanup(func() { _ = conn.Close() })
+
+		return conn.LocalAddr().String(), func() string { return readPacket(t, conn) }
+	default:
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.Nil(t, err)
+
+		t.Cleanup(func() { _ = l.Close() })
+
+		return l.Addr().String(), func() string {
+			conn, err := l.Accept()
+			assert.Nil(t, err)
+
+			defer conn.Close()
+
+			//The messages are framed by their length
+			_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+			r := bufio.NewReader(conn)
+			size, err := r.ReadString(' ')
+			assert.Nil(t, err)
+
+			n, err := strconv.Atoi(strings.TrimSpace(size))
+			assert.Nil(t, err)
+
+			msg := make([]byte, n)
+			_, err = io.ReadFull(r, msg)
+			assert.Nil(t, err)
+
+			return string(msg)
+		}
+	}
+}
+
+//readPacket reads a datagram
+func readPacket(t *testing.T, conn net.PacketConn) string {
+	buf := make([]byte, 65536)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	n, _, err := conn.ReadFrom(buf)
+	assert.Nil(t, err)
+
+	return string(buf[:n])
+}
+
+func TestSyslogWriter_WriteLevel(t *testing.T) {
+	tests := []struct {
+		name    string
+		network string
+	}{
+		{name: "test unix socket", network: SyslogUnix},
+		{name: "test udp", network: SyslogUDP},
+		{name: "test tcp", network: SyslogTCP},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address, read := listenSyslog(t, tt.network)
+
+			w, err := DialSyslog(SyslogOutput{Network: tt.network, Address: address, Facility: 3, Tag: "open-bastion"})
+			assert.Nil(t, err)
+
+			defer w.Close()
+
+			n, err := w.WriteLevel(zerolog.WarnLevel, []byte(testEvent))
+			assert.Nil(t, err)
+			assert.Equal(t, len(testEvent), n)
+
+			msg := read()
+
+			//daemon (3) * 8 + warning (4)
+			assert.True(t, strings.HasPrefix(msg, "<28>1 "), msg)
+			assert.Contains(t, msg, " open-bastion ")
+			assert.Contains(t, msg, `[open-bastion@32473 command="ssh \"a\]\"" port="22" session="1a2b" user="alice"]`)
+			assert.True(t, strings.HasSuffix(msg, "] client connected"), msg)
+		})
+	}
+}
+
+func TestSyslogSeverity(t *testing.T) {
+	tests := []struct {
+		name  string
+		level zerolog.Level
+		want  int
+	}{
+		{name: "test debug", level: zerolog.DebugLevel, want: 7},
+		{name: "test info", level: zerolog.InfoLevel, want: 6},
+		{name: "test warn", level: zerolog.WarnLevel, want: 4},
+		{name: "test error", level: zerolog.ErrorLevel, want: 3},
+		{name: "test fatal", level: zerolog.FatalLevel, want: 2},
+		{name: "test panic", level: zerolog.PanicLevel, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, syslogSeverity(tt.level))
+		})
+	}
+}
+
+func TestJournaldWriter_WriteLevel(t *testing.T) {
+	path := newTestLogDir(t) + "/journal.sock"
+	conn, err := net.ListenPacket("unixgram", path)
+	assert.Nil(t, err)
+
+	defer conn.Close()
+
+	w, err := DialJournald(path, "open-bastion")
+	assert.Nil(t, err)
+
+	defer w.Close()
+
+	event := `{"level":"error","backendUser":"root","message":"first line\nsecond line"}`
+	_, err = w.WriteLevel(zerolog.ErrorLevel, []byte(event))
+	assert.Nil(t, err)
+
+	entry := []byte(readPacket(t, conn))
+
+	//A value containing a new line is written with its length
+	multiline := "first line\nsecond line"
+	size := make([]byte, 8)
+	binary.LittleEndian.PutUint64(size, uint64(len(multiline)))
+
+	assert.True(t, bytes.HasPrefix(entry, append(append([]byte("MESSAGE\n"), size...), multiline+"\n"...)))
+	assert.Contains(t, string(entry), "\nPRIORITY=3\n")
+	assert.Contains(t, string(entry), "\nSYSLOG_IDENTIFIER=open-bastion\n")
+	assert.Contains(t, string(entry), "\nBACKEND_USER=root\n")
+}
+
+func TestJournalFieldName(t *testing.T) {
+	tests := []struct {
+		name  string
+		field string
+		want  string
+	}{
+		{name: "test lower case", field: "user", want: "USER"},
+		{name: "test camel case", field: "backendPublicKeyFingerprint", want: "BACKEND_PUBLIC_KEY_FINGERPRINT"},
+		{name: "test reserved underscore", field: "_pid", want: "PID"},
+		{name: "test invalid characters", field: "a.b-c", want: "A_B_C"},
+		{name: "test no valid character", field: "_", want: "FIELD"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, journalFieldName(tt.field))
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package obclient
+
+import (
+	"context"
+	"errors"
+	"github.com/open-bastion/open-bastion/internal/logger"
+	"strconv"
+	"time"
+)
+
+// Ban is a source of failed authentications temporarily refused by the bastion. Kind is ip or user, Count is the
+// number of bans of the source in a row.
+type Ban struct {
+	Kind   string    `json:"kind"`
+	Target string    `json:"target"`
+	Count  int       `json:"count"`
+	Until  time.Time `json:"until"`
+}
+
+// BanRegistry lists and lifts the bans of the brute-force protection
+type BanRegistry interface {
+	Bans(now time.Time) []Ban
+	ClearBans(target string, now time.Time) int
+}
+
+//runBanList lists the current bans.
+func runBanList(ctx context.Context, cmd *command) (int, error) {
+	if len(cmd.args) != 0 {
+		return ExitUsage, errors.New("usage: bastion ban list")
+	}
+
+	bans := []Ban{}
+
+	if cmd.env.Bans != nil {
+		bans = append(bans, cmd.env.Bans.Bans(time.Now())...)
+	}
+
+	rows := make([][]string, 0, len(bans))
+
+	for _, b := range bans {
+		rows = append(rows, []string{b.Kind, b.Target, strconv.Itoa(b.Count), b.Until.Format(time.RFC3339)})
+	}
+
+	cmd.print(bans, []string{"KIND", "TARGET", "COUNT", "UNTIL"}, rows)
+
+	return ExitSuccess, nil
+}
+
+//runBanClear lifts the bans of an IP or a username, or every ban with --all.
+func runBanClear(ctx context.Context, cmd *command) (int, error) {
+	args, options, err := parseOptions(cmd.args)
+
+	if err != nil {
+		return ExitUsage, err
+	}
+
+	if (len(args) != 1 || options["all"] != "") && (len(args) != 0 || options["all"] == "") {
+		return ExitUsage, errors.New("usage: bastion ban clear IP|USERNAME|--all")
+	}
+
+	target := ""
+
+	if len(args) == 1 {
+		target = args[0]
+	}
+
+	cleared := 0
+
+	if cmd.env.Bans != nil {
+		cleared = cmd.env.Bans.ClearBans(target, time.Now())
+	}
+
+	logger.InfofWithCtx(ctx, "%v bans cleared", cleared)
+
+	out := struct {
+		Target  string `json:"target"`
+		Cleared int    `json:"cleared"`
+	}{
+		Target:  target,
+		Cleared: cleared,
+	}
+
+	cmd.print(out, nil, [][]string{{strconv.Itoa(cleared) + " bans cleared"}})
+
+	return ExitSuccess, nil
+}
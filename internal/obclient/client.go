@@ -2,11 +2,13 @@ package obclient
 
 import (
 	"errors"
+	"github.com/open-bastion/open-bastion/internal/auth"
 	"github.com/open-bastion/open-bastion/internal/logger"
 	"golang.org/x/crypto/ssh"
 	"net"
 	"strconv"
 	"strings"
+	"time"
 )
 
 //TODO better message?
@@ -26,26 +28,47 @@ var ErrInvalidPayload = errors.New("invalid payload")
 
 //Client represent a user and all the associated resources.
 type Client struct {
+	SessionID    string
+	StartTime    time.Time
 	TCPConnexion net.Conn
 
 	SSHConnexion *ssh.ServerConn
 	sshChan      <-chan ssh.NewChannel
 	SshCommChan  ssh.Channel
 
-	User       string
-	SSHKey     ssh.Signer
-	RawCommand []byte
+	User        string
+	SSHKey      ssh.Signer
+	RawCommand  []byte
+	CommandArgs []string
 
 	BackendCommand string
+	//BackendUser is the user the bastion logs in the backend as, the bastion user unless the command names another
 	BackendUser    string
 	BackendHost    string
 	BackendPort    int
 	BackendTimeout int
+
+	//Term is the terminal the client requested, with its size in characters. It is empty if no terminal was
+	//requested.
+	Term       string
+	TermWidth  int
+	TermHeight int
+}
+
+//ptyRequest is the payload of a pty-req request, see RFC 4254 section 6.2
+type ptyRequest struct {
+	Term     string
+	Columns  uint32
+	Rows     uint32
+	WidthPx  uint32
+	HeightPx uint32
+	Modes    string
 }
 
 // BackendConn contains the information to establish a connection to a backend.
 type BackendConn struct {
 	Command string
+	Args    []string
 	User    string
 	Host    string
 	Port    int
@@ -96,8 +119,23 @@ func (client *Client) HandleSSHConnection() error {
 		break
 	}
 
+	forcedCommand, forced := auth.ForceCommand(client.SSHConnexion.Permissions)
+
 	for req := range requests {
-		if req.Type == "exec" {
+		if req.Type == "pty-req" {
+			//The terminal is requested again to the backend
+			var pty ptyRequest
+
+			ok := ssh.Unmarshal(req.Payload, &pty) == nil
+
+			if ok {
+				client.Term, client.TermWidth, client.TermHeight = pty.Term, int(pty.Columns), int(pty.Rows)
+			}
+
+			if req.WantReply {
+				_ = req.Reply(ok, nil)
+			}
+		} else if req.Type == "exec" {
 			//The request payload is a raw byte array. Its 4 first bytes contain
 			//its length so we need to remove them to correctly get the strings
 			//We limit the command to 512 bytes to avoid attacks
@@ -109,6 +147,11 @@ func (client *Client) HandleSSHConnection() error {
 			client.RawCommand = req.Payload[4:]
 			break
 		} else if req.Type == "shell" {
+			//The command forced by the key runs whatever the client requested
+			if forced {
+				break
+			}
+
 			//A shell should not be requested on the bastion
 			//This is here to prevent the connexion to hang with a badly formed payload
 			_, _ = client.SshCommChan.Write([]byte(sshBadRequestShell))
@@ -116,6 +159,16 @@ func (client *Client) HandleSSHConnection() error {
 		}
 	}
 
+	//As in sshd, the command forced by the key replaces the one requested by the client
+	if forced {
+		if len(client.RawCommand) > 0 {
+			logger.Infof("command %q of user %v replaced by the command forced by its key", client.RawCommand,
+				client.User)
+		}
+
+		client.RawCommand = []byte(forcedCommand)
+	}
+
 	if len(client.RawCommand) > 0 {
 		bc, err := ParseBackendInfo(client.RawCommand)
 
@@ -127,6 +180,7 @@ func (client *Client) HandleSSHConnection() error {
 
 		//TODO return the correct thing, I was just too lazy to change is for now
 		client.BackendCommand = bc.Command
+		client.CommandArgs = bc.Args
 		client.BackendUser = bc.User
 		client.BackendHost = bc.Host
 		client.BackendPort = bc.Port
@@ -157,6 +211,14 @@ func ParseBackendInfo(rawPayload []byte) (bc BackendConn, err error) {
 
 	command := strings.Split(payload, " ")
 
+	//The bastion command has its own arguments, they are parsed when the command is run
+	if command[0] == "bastion" {
+		bc.Command = "bastion"
+		bc.Args = strings.Fields(payload)[1:]
+
+		return bc, nil
+	}
+
 	//The raw payload should at least contain a command and an argument (host...)
 	if command == nil || len(command) < 2 {
 		return bc, ErrInvalidPayload
@@ -170,8 +232,6 @@ func ParseBackendInfo(rawPayload []byte) (bc BackendConn, err error) {
 		bc.Command = "ssh"
 	} else if c == "telnet" {
 		bc.Command = "telnet"
-	} else if c == "bastion" {
-		bc.Command = "bastion"
 	} else {
 		return BackendConn{}, errors.New("command not found")
 	}
@@ -236,10 +296,9 @@ func ParseBackendInfo(rawPayload []byte) (bc BackendConn, err error) {
 	return bc, nil
 }
 
-//RunCommand not implemented.
-func (client *Client) RunCommand() error {
-
-	return errors.New("not implemented")
+//GetSessionID implements the ClientInfoGetter. It returns the client's session identifier.
+func (client Client) GetSessionID() string {
+	return client.SessionID
 }
 
 //GetUser implements the ClientInfoGetter. It returns the client's User.
@@ -252,6 +311,21 @@ func (client Client) GetIp() string {
 	return client.TCPConnexion.RemoteAddr().String()
 }
 
+//GetIngressKeyFingerprint returns the fingerprint of the public key the client authenticated with on the bastion
+//or an empty string if the handshake has not been performed.
+func (client Client) GetIngressKeyFingerprint() string {
+	if client.SSHConnexion != nil && client.SSHConnexion.Permissions != nil {
+		return client.SSHConnexion.Permissions.Extensions["pubkey-fp"]
+	}
+
+	return ""
+}
+
+//restrictedKey returns whether the client authenticated with a key restricted by its options
+func (client Client) restrictedKey() bool {
+	return client.SSHConnexion != nil && auth.Restricted(client.SSHConnexion.Permissions)
+}
+
 //GetPublicKeyFingerprint implements the ClientInfoGetter. It returns the client's public key fingerprint or
 //an empty string if it is not initialized.
 func (client Client) GetPublicKeyFingerprint() string {
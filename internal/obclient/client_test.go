@@ -0,0 +1,94 @@
+package obclient
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBackendInfo(t *testing.T) {
+	type args struct {
+		rawPayload []byte
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantBc  BackendConn
+		wantErr bool
+	}{
+		{
+			name: "ssh default port",
+			args: args{
+				rawPayload: []byte("ssh alice@10.0.0.1"),
+			},
+			wantBc: BackendConn{
+				Command: "ssh",
+				User:    "alice",
+				Host:    "10.0.0.1",
+				Port:    22,
+			},
+			wantErr: false,
+		},
+		{
+			name: "ssh with port",
+			args: args{
+				rawPayload: []byte("ssh 10.0.0.1 -p 2222"),
+			},
+			wantBc: BackendConn{
+				Command: "ssh",
+				Host:    "10.0.0.1",
+				Port:    2222,
+			},
+			wantErr: false,
+		},
+		{
+			name: "bastion command",
+			args: args{
+				rawPayload: []byte("bastion  hosts list --json"),
+			},
+			wantBc: BackendConn{
+				Command: "bastion",
+				Args:    []string{"hosts", "list", "--json"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "bastion without argument",
+			args: args{
+				rawPayload: []byte("bastion"),
+			},
+			wantBc: BackendConn{
+				Command: "bastion",
+				Args:    []string{},
+			},
+			wantErr: false,
+		},
+		{
+			name: "unknown command",
+			args: args{
+				rawPayload: []byte("scp 10.0.0.1"),
+			},
+			wantBc:  BackendConn{},
+			wantErr: true,
+		},
+		{
+			name: "invalid port",
+			args: args{
+				rawPayload: []byte("ssh 10.0.0.1 -p 70000"),
+			},
+			wantBc: BackendConn{
+				Command: "ssh",
+				Host:    "10.0.0.1",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotBc, err := ParseBackendInfo(tt.args.rawPayload)
+
+			assert.Equal(t, tt.wantBc, gotBc)
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
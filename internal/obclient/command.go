@@ -0,0 +1,432 @@
+package obclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/open-bastion/open-bastion/internal/auth"
+	"github.com/open-bastion/open-bastion/internal/datastore"
+	"github.com/open-bastion/open-bastion/internal/logger"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Exit statuses of the bastion commands
+const (
+	ExitSuccess = iota
+	ExitFailure
+	ExitUsage
+	ExitPermissionDenied
+)
+
+var ErrPermissionDenied = errors.New("permission denied")
+var ErrUnknownCommand = errors.New("unknown command, run 'bastion help' to list the available commands")
+var ErrRestrictedKey = errors.New("this command is not allowed with a restricted key")
+
+// CommandEnv contains the bastion resources the commands can access. EgressCAKey is nil unless the backend
+// connections use certificates of the egress CA. RecordingsDir is the directory of the session recordings.
+type CommandEnv struct {
+	DataStore     datastore.DataStore
+	Sessions      *SessionRegistry
+	Auth          *auth.Auth
+	EgressCAKey   ssh.PublicKey
+	Bans          BanRegistry
+	RecordingsDir string
+}
+
+//command represents a parsed invocation of a bastion command
+type command struct {
+	client *Client
+	env    CommandEnv
+	args   []string
+	json   bool
+}
+
+//commandSpec describes a bastion command. Path contains the command and subcommand names. Account is set for the
+//commands changing the credentials of the client account, they are refused to the restricted keys like the
+//administration commands.
+type commandSpec struct {
+	Path    string
+	Usage   string
+	Help    string
+	Admin   bool
+	Account bool
+	Run     func(ctx context.Context, cmd *command) (int, error)
+}
+
+var commandTable []commandSpec
+
+func init() {
+	commandTable = []commandSpec{
+		{Path: "help", Help: "display this help", Run: runHelp},
+		{Path: "whoami", Help: "display information about your account and session", Run: runWhoami},
+		{Path: "egress-key show", Help: "display the public key used to connect to the backends", Run: runEgressKeyShow},
+		{Path: "egress-ca show", Help: "display the CA key the backends must trust for the bastion certificates",
+			Run: runEgressCAShow},
+		{Path: "keys list", Help: "list the keys allowed to log in as you", Run: runKeysList},
+		{Path: "keys add", Usage: "AUTHORIZED_KEY", Help: "allow a key to log in as you", Account: true,
+			Run: runKeysAdd},
+		{Path: "keys remove", Usage: "FINGERPRINT", Help: "remove one of your keys", Account: true,
+			Run: runKeysRemove},
+		{Path: "password set", Help: "set your password, read from the standard input", Account: true,
+			Run: runPasswordSet},
+		{Path: "password disable", Help: "disable the password login of your account",
+			Account: true, Run: runPasswordDisable},
+		{Path: "mfa enroll", Help: "enroll a TOTP second factor, confirmed with a code read from the standard input",
+			Account: true, Run: runMFAEnroll},
+		{Path: "hosts list", Help: "list the known backend host keys", Run: runHostsList},
+		{Path: "hosts pending", Help: "list the backend host keys waiting for approval", Admin: true, Run: runHostsPending},
+		{Path: "hosts accept", Usage: "HOST[:PORT] SHA256:FINGERPRINT",
+			Help: "accept the pending host key of a backend", Admin: true, Run: runHostsAccept},
+		{Path: "sessions list", Help: "list your active sessions (every session for administrators)",
+			Run: runSessionsList},
+		{Path: "recordings list", Help: "list your recorded sessions (every recorded session for administrators)",
+			Run: runRecordingsList},
+		{Path: "replay", Usage: "SESSION_ID [--speed FACTOR]",
+			Help: "replay one of your recorded sessions (any session for administrators)", Run: runReplay},
+		{Path: "ban list", Help: "list the sources banned after too many failed authentications", Admin: true,
+			Run: runBanList},
+		{Path: "ban clear", Usage: "IP|USERNAME|--all", Help: "lift the bans of an IP or a user, or every ban",
+			Admin: true, Run: runBanClear},
+		{Path: "user add", Usage: "USERNAME [--key-type TYPE] [--key-size BITS] [--admin]",
+			Help: "create a user and its egress key", Admin: true, Run: runUserAdd},
+		{Path: "user delete", Usage: "USERNAME", Help: "delete a user and its keys", Admin: true, Run: runUserDelete},
+		{Path: "user activate", Usage: "USERNAME", Help: "allow a user to log in", Admin: true, Run: runUserActivate},
+		{Path: "user deactivate", Usage: "USERNAME", Help: "prevent a user from logging in", Admin: true,
+			Run: runUserDeactivate},
+		{Path: "user promote", Usage: "USERNAME", Help: "grant the administrator rights to a user", Admin: true,
+			Run: runUserPromote},
+		{Path: "user password set", Usage: "USERNAME",
+			Help: "set the password of a user, read from the standard input", Admin: true, Run: runUserPasswordSet},
+		{Path: "user password disable", Usage: "USERNAME", Help: "disable the password login of a user", Admin: true,
+			Run: runUserPasswordDisable},
+		{Path: "user mfa reset", Usage: "USERNAME", Help: "remove the second factor of a user", Admin: true,
+			Run: runUserMFAReset},
+		{Path: "user keys list", Usage: "USERNAME", Help: "list the keys allowed to log in as a user", Admin: true,
+			Run: runUserKeysList},
+		{Path: "user keys add", Usage: "USERNAME AUTHORIZED_KEY", Help: "allow a key to log in as a user",
+			Admin: true, Run: runUserKeysAdd},
+		{Path: "user keys remove", Usage: "USERNAME FINGERPRINT", Help: "remove a key of a user", Admin: true,
+			Run: runUserKeysRemove},
+	}
+}
+
+//RunCommand runs the bastion command requested by the client. The output is written on the client communication
+//channel, as a table or as JSON with --json, and the exit status is sent back so that scripts can rely on it.
+func (client *Client) RunCommand(ctx context.Context, env CommandEnv) error {
+	cmd := &command{client: client, env: env}
+
+	for _, a := range client.CommandArgs {
+		if a == "--json" {
+			cmd.json = true
+		} else {
+			cmd.args = append(cmd.args, a)
+		}
+	}
+
+	status, err := cmd.dispatch(ctx)
+
+	if err != nil {
+		_, _ = client.SshCommChan.Write([]byte("Error : " + err.Error() + "\n"))
+	}
+
+	client.sendExitStatus(ctx, status)
+
+	return err
+}
+
+//dispatch finds the command matching the longest path and runs it.
+func (cmd *command) dispatch(ctx context.Context) (int, error) {
+	if len(cmd.args) == 0 {
+		_, _ = runHelp(ctx, cmd)
+		return ExitUsage, nil
+	}
+
+	var spec *commandSpec
+	best := 0
+
+	for i := range commandTable {
+		path := strings.Fields(commandTable[i].Path)
+
+		if len(path) <= best || len(path) > len(cmd.args) {
+			continue
+		}
+
+		match := true
+
+		for j := range path {
+			if path[j] != cmd.args[j] {
+				match = false
+				break
+			}
+		}
+
+		if match {
+			spec = &commandTable[i]
+			best = len(path)
+		}
+	}
+
+	if spec == nil {
+		return ExitUsage, ErrUnknownCommand
+	}
+
+	cmd.args = cmd.args[best:]
+	invocation := "bastion " + strings.Join(cmd.client.CommandArgs, " ")
+
+	//A key issued for some backends or a command cannot change the account credentials nor administer the bastion,
+	//it would add an unrestricted key otherwise
+	if (spec.Admin || spec.Account) && cmd.client.restrictedKey() {
+		logger.AuditfWithCtx(ctx, logger.AuditAdmin, "command refused to a restricted key: %v", invocation)
+		return ExitPermissionDenied, ErrRestrictedKey
+	}
+
+	if !spec.Admin {
+		return spec.Run(ctx, cmd)
+	}
+
+	//Every attempt to run an administration command is audited, whatever its outcome
+
+	if err := cmd.requireAdmin(ctx); err != nil {
+		logger.AuditfWithCtx(ctx, logger.AuditAdmin, "admin command refused: %v: %v", invocation, err)
+		return ExitPermissionDenied, err
+	}
+
+	status, err := spec.Run(ctx, cmd)
+
+	if err != nil {
+		logger.AuditfWithCtx(ctx, logger.AuditAdmin, "admin command failed: %v: %v", invocation, err)
+	} else {
+		logger.AuditfWithCtx(ctx, logger.AuditAdmin, "admin command succeeded: %v", invocation)
+	}
+
+	return status, err
+}
+
+//requireAdmin returns an error if the client is not an administrator of the bastion.
+func (cmd *command) requireAdmin(ctx context.Context) error {
+	admin, err := cmd.env.DataStore.IsUserAdmin(cmd.client.User)
+
+	if err != nil {
+		logger.ErrorWithCtxWithErr(ctx, err, "could not verify the user permissions")
+		return errors.New("could not verify permissions")
+	}
+
+	if !admin {
+		return ErrPermissionDenied
+	}
+
+	return nil
+}
+
+//print writes v as JSON if requested, the header and rows as a table otherwise.
+func (cmd *command) print(v interface{}, header []string, rows [][]string) {
+	w := cmd.client.SshCommChan
+
+	if cmd.json {
+		_ = json.NewEncoder(w).Encode(v)
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	if header != nil {
+		_, _ = fmt.Fprintln(tw, strings.Join(header, "\t"))
+	}
+
+	for _, r := range rows {
+		_, _ = fmt.Fprintln(tw, strings.Join(r, "\t"))
+	}
+
+	_ = tw.Flush()
+}
+
+//sendExitStatus sends the exit-status request on the client communication channel.
+func (client *Client) sendExitStatus(ctx context.Context, status int) {
+	payload := ssh.Marshal(struct{ Status uint32 }{uint32(status)})
+
+	_, err := client.SshCommChan.SendRequest("exit-status", false, payload)
+
+	if err != nil {
+		logger.WarnWithCtxWithErr(ctx, err, "could not send the exit status")
+	}
+}
+
+//runHelp lists the available commands.
+func runHelp(ctx context.Context, cmd *command) (int, error) {
+	type helpEntry struct {
+		Command string `json:"command"`
+		Help    string `json:"help"`
+		Admin   bool   `json:"admin"`
+	}
+
+	var entries []helpEntry
+	var rows [][]string
+
+	for _, spec := range commandTable {
+		c := strings.TrimSpace("bastion " + spec.Path + " " + spec.Usage)
+		h := spec.Help
+
+		if spec.Admin {
+			h += " (admin)"
+		}
+
+		entries = append(entries, helpEntry{Command: c, Help: spec.Help, Admin: spec.Admin})
+		rows = append(rows, []string{c, h})
+	}
+
+	if !cmd.json {
+		_, _ = fmt.Fprintln(cmd.client.SshCommChan, "usage: ssh BASTION_IP -- bastion COMMAND [--json]")
+		_, _ = fmt.Fprintln(cmd.client.SshCommChan)
+	}
+
+	cmd.print(entries, nil, rows)
+
+	return ExitSuccess, nil
+}
+
+//runWhoami displays the client account and session information.
+func runWhoami(ctx context.Context, cmd *command) (int, error) {
+	ds := cmd.env.DataStore
+	c := cmd.client
+
+	status, err := ds.GetUserStatus(c.User)
+
+	if err != nil {
+		logger.ErrorWithCtxWithErr(ctx, err, "could not read the user status")
+		return ExitFailure, errors.New("could not read your account information")
+	}
+
+	admin, err := ds.IsUserAdmin(c.User)
+
+	if err != nil {
+		logger.ErrorWithCtxWithErr(ctx, err, "could not read the user permissions")
+		return ExitFailure, errors.New("could not read your account information")
+	}
+
+	out := struct {
+		User           string `json:"user"`
+		Active         bool   `json:"active"`
+		Admin          bool   `json:"admin"`
+		IP             string `json:"ip"`
+		KeyFingerprint string `json:"keyFingerprint"`
+		Session        string `json:"session"`
+	}{
+		User:           c.User,
+		Active:         status == datastore.Active,
+		Admin:          admin,
+		IP:             c.GetIp(),
+		KeyFingerprint: c.GetIngressKeyFingerprint(),
+		Session:        c.SessionID,
+	}
+
+	cmd.print(out, nil, [][]string{
+		{"user:", out.User},
+		{"active:", fmt.Sprint(out.Active)},
+		{"admin:", fmt.Sprint(out.Admin)},
+		{"ip:", out.IP},
+		{"key:", out.KeyFingerprint},
+		{"session:", out.Session},
+	})
+
+	return ExitSuccess, nil
+}
+
+//runEgressKeyShow displays the public key the bastion uses to connect to the backends on behalf of the client.
+func runEgressKeyShow(ctx context.Context, cmd *command) (int, error) {
+	raw, err := cmd.env.DataStore.GetRawUserEgressPublicKey(cmd.client.User)
+
+	if err != nil {
+		logger.ErrorWithCtxWithErr(ctx, err, "could not read the user egress public key")
+		return ExitFailure, errors.New("could not read your egress public key")
+	}
+
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey(raw)
+
+	if err != nil {
+		logger.ErrorWithCtxWithErr(ctx, err, "could not parse the user egress public key")
+		return ExitFailure, errors.New("could not read your egress public key")
+	}
+
+	out := struct {
+		Type        string `json:"type"`
+		Fingerprint string `json:"fingerprint"`
+		PublicKey   string `json:"publicKey"`
+	}{
+		Type:        pubKey.Type(),
+		Fingerprint: ssh.FingerprintSHA256(pubKey),
+		PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pubKey))),
+	}
+
+	if cmd.json {
+		cmd.print(out, nil, nil)
+	} else {
+		_, _ = fmt.Fprintln(cmd.client.SshCommChan, out.PublicKey)
+	}
+
+	return ExitSuccess, nil
+}
+
+//runEgressCAShow displays the public key of the egress CA, to be added to the TrustedUserCAKeys of the backends.
+func runEgressCAShow(ctx context.Context, cmd *command) (int, error) {
+	if cmd.env.EgressCAKey == nil {
+		return ExitFailure, errors.New("the backend connections do not use certificates, see 'bastion egress-key show'")
+	}
+
+	out := struct {
+		Type        string `json:"type"`
+		Fingerprint string `json:"fingerprint"`
+		PublicKey   string `json:"publicKey"`
+	}{
+		Type:        cmd.env.EgressCAKey.Type(),
+		Fingerprint: ssh.FingerprintSHA256(cmd.env.EgressCAKey),
+		PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cmd.env.EgressCAKey))),
+	}
+
+	if cmd.json {
+		cmd.print(out, nil, nil)
+	} else {
+		_, _ = fmt.Fprintln(cmd.client.SshCommChan, out.PublicKey)
+	}
+
+	return ExitSuccess, nil
+}
+
+//runSessionsList lists the client active sessions, or every active session for an administrator.
+func runSessionsList(ctx context.Context, cmd *command) (int, error) {
+	admin, err := cmd.env.DataStore.IsUserAdmin(cmd.client.User)
+
+	if err != nil {
+		logger.ErrorWithCtxWithErr(ctx, err, "could not read the user permissions")
+		return ExitFailure, errors.New("could not list the sessions")
+	}
+
+	sessions := []Session{}
+	var rows [][]string
+
+	for _, s := range cmd.env.Sessions.List() {
+		if !admin && s.User != cmd.client.User {
+			continue
+		}
+
+		sessions = append(sessions, s)
+		rows = append(rows, []string{s.ID, s.User, s.IP, s.Start.Format(time.RFC3339), s.Command})
+	}
+
+	cmd.print(sessions, []string{"ID", "USER", "IP", "START", "COMMAND"}, rows)
+
+	return ExitSuccess, nil
+}
+
+//backendAddress returns the host:port address of a backend, the port defaults to 22.
+func backendAddress(hostport string) string {
+	if _, _, err := net.SplitHostPort(hostport); err == nil {
+		return hostport
+	}
+
+	return net.JoinHostPort(hostport, "22")
+}
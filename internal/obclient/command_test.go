@@ -0,0 +1,60 @@
+package obclient
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/open-bastion/open-bastion/internal/auth"
+	"github.com/open-bastion/open-bastion/internal/datastore"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCommand_RestrictedKey(t *testing.T) {
+	s := datastore.NewMemoryStore(datastore.DefaultKeyPolicy())
+	assert.Nil(t, s.AddUser("alice", "", 0))
+	assert.Nil(t, s.SetUserAdmin("alice", true))
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	key, err := ssh.NewPublicKey(pub)
+	assert.Nil(t, err)
+
+	newKey := string(ssh.MarshalAuthorizedKey(key))
+
+	tests := []struct {
+		name    string
+		options []string
+		args    []string
+	}{
+		{name: "test restrict with permitopen adding a key", options: []string{"restrict", `permitopen="db:22"`},
+			args: []string{"keys", "add", newKey}},
+		{name: "test no-port-forwarding setting a password", options: []string{"no-port-forwarding"},
+			args: []string{"password", "set"}},
+		{name: "test forced command enrolling a second factor", options: []string{`command="bastion mfa enroll"`},
+			args: []string{"mfa", "enroll"}},
+		{name: "test restricted key of an administrator", options: []string{"restrict", `permitopen="db:22"`},
+			args: []string{"user", "promote", "bob"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options, err := auth.ParseKeyOptions(tt.options)
+			assert.Nil(t, err)
+
+			client := &Client{User: "alice", CommandArgs: tt.args,
+				SSHConnexion: &ssh.ServerConn{Permissions: &ssh.Permissions{Extensions: options.Extensions()}}}
+			cmd := &command{client: client, env: CommandEnv{DataStore: s}, args: tt.args}
+
+			status, err := cmd.dispatch(context.Background())
+			assert.Equal(t, ErrRestrictedKey, err)
+			assert.Equal(t, ExitPermissionDenied, status)
+		})
+	}
+
+	keys, err := s.GetUserAuthorizedKeys("alice")
+	assert.Nil(t, err)
+	assert.Len(t, keys, 0)
+}
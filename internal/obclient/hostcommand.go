@@ -0,0 +1,106 @@
+package obclient
+
+import (
+	"context"
+	"errors"
+	"github.com/open-bastion/open-bastion/internal/datastore"
+	"github.com/open-bastion/open-bastion/internal/logger"
+	"golang.org/x/crypto/ssh"
+	"strings"
+)
+
+//hostKeyEntry is the output format of a backend host key
+type hostKeyEntry struct {
+	Address     string `json:"address"`
+	Type        string `json:"type"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+//printHostKeys writes the list of backend host keys.
+func (cmd *command) printHostKeys(keys []datastore.BackendHostKey) {
+	entries := []hostKeyEntry{}
+	var rows [][]string
+
+	for _, k := range keys {
+		e := hostKeyEntry{Address: k.Address, Type: k.Key.Type(), Fingerprint: ssh.FingerprintSHA256(k.Key)}
+		entries = append(entries, e)
+		rows = append(rows, []string{e.Address, e.Type, e.Fingerprint})
+	}
+
+	cmd.print(entries, []string{"ADDRESS", "TYPE", "FINGERPRINT"}, rows)
+}
+
+//runHostsList lists the known backend host keys.
+func runHostsList(ctx context.Context, cmd *command) (int, error) {
+	keys, err := cmd.env.DataStore.ListBackendHostKeys()
+
+	if err != nil {
+		logger.ErrorWithCtxWithErr(ctx, err, "could not list the known host keys")
+		return ExitFailure, errors.New("could not list the known host keys")
+	}
+
+	cmd.printHostKeys(keys)
+
+	return ExitSuccess, nil
+}
+
+//runHostsPending lists the backend host keys waiting for an administrator approval.
+func runHostsPending(ctx context.Context, cmd *command) (int, error) {
+	keys, err := cmd.env.DataStore.ListPendingBackendHostKeys()
+
+	if err != nil {
+		logger.ErrorWithCtxWithErr(ctx, err, "could not list the pending host keys")
+		return ExitFailure, errors.New("could not list the pending host keys")
+	}
+
+	cmd.printHostKeys(keys)
+
+	return ExitSuccess, nil
+}
+
+//runHostsAccept replaces the known host keys of a backend with its pending key. The fingerprint displayed by hosts
+//pending is required, a key recorded after the administrator checked it is not accepted.
+func runHostsAccept(ctx context.Context, cmd *command) (int, error) {
+	if len(cmd.args) != 2 || !strings.HasPrefix(cmd.args[1], "SHA256:") {
+		return ExitUsage, errors.New("usage: bastion hosts accept HOST[:PORT] SHA256:FINGERPRINT")
+	}
+
+	ds := cmd.env.DataStore
+	address := backendAddress(cmd.args[0])
+	fingerprint := cmd.args[1]
+
+	key, err := ds.GetPendingBackendHostKey(address)
+
+	if err != nil {
+		logger.ErrorWithCtxWithErr(ctx, err, "could not read the pending host key")
+		return ExitFailure, errors.New("could not read the pending host key of " + address)
+	}
+
+	if key == nil {
+		return ExitFailure, errors.New("no pending host key for " + address)
+	}
+
+	if ssh.FingerprintSHA256(key) != fingerprint {
+		return ExitFailure, errors.New("the pending host key of " + address + " is " + ssh.FingerprintSHA256(key) +
+			", not " + fingerprint)
+	}
+
+	err = ds.AcceptPendingBackendHostKey(address, fingerprint)
+
+	if err == datastore.ErrPendingHostKeyMismatch {
+		return ExitFailure, errors.New("the pending host key of " + address + " changed, check it again")
+	}
+
+	if err != nil {
+		logger.ErrorWithCtxWithErr(ctx, err, "could not accept the pending host key")
+		return ExitFailure, errors.New("could not accept the pending host key of " + address)
+	}
+
+	fp := ssh.FingerprintSHA256(key)
+	logger.InfofWithCtx(ctx, "host key %v accepted for backend %v", fp, address)
+
+	cmd.print(hostKeyEntry{Address: address, Type: key.Type(), Fingerprint: fp}, nil,
+		[][]string{{"host key " + fp + " accepted for " + address}})
+
+	return ExitSuccess, nil
+}
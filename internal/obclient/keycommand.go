@@ -0,0 +1,164 @@
+package obclient
+
+import (
+	"context"
+	"errors"
+	"github.com/open-bastion/open-bastion/internal/auth"
+	"github.com/open-bastion/open-bastion/internal/datastore"
+	"github.com/open-bastion/open-bastion/internal/logger"
+	"strings"
+)
+
+//authorizedKeyEntry is the output format of an ingress authorized key
+type authorizedKeyEntry struct {
+	Fingerprint string   `json:"fingerprint"`
+	Type        string   `json:"type"`
+	Comment     string   `json:"comment"`
+	Options     []string `json:"options"`
+}
+
+//runKeysList lists the ingress keys of the client.
+func runKeysList(ctx context.Context, cmd *command) (int, error) {
+	if len(cmd.args) != 0 {
+		return ExitUsage, errors.New("usage: bastion keys list")
+	}
+
+	return cmd.listAuthorizedKeys(ctx, cmd.client.User)
+}
+
+//runKeysAdd allows a new ingress key to log in as the client.
+func runKeysAdd(ctx context.Context, cmd *command) (int, error) {
+	if len(cmd.args) == 0 {
+		return ExitUsage, errors.New("usage: bastion keys add AUTHORIZED_KEY")
+	}
+
+	return cmd.addAuthorizedKey(ctx, cmd.client.User, cmd.args)
+}
+
+//runKeysRemove removes an ingress key of the client.
+func runKeysRemove(ctx context.Context, cmd *command) (int, error) {
+	if len(cmd.args) != 1 {
+		return ExitUsage, errors.New("usage: bastion keys remove FINGERPRINT")
+	}
+
+	return cmd.removeAuthorizedKey(ctx, cmd.client.User, cmd.args[0])
+}
+
+//runUserKeysList lists the ingress keys of a user.
+func runUserKeysList(ctx context.Context, cmd *command) (int, error) {
+	if len(cmd.args) != 1 {
+		return ExitUsage, errors.New("usage: bastion user keys list USERNAME")
+	}
+
+	return cmd.listAuthorizedKeys(ctx, cmd.args[0])
+}
+
+//runUserKeysAdd allows a new ingress key to log in as a user.
+func runUserKeysAdd(ctx context.Context, cmd *command) (int, error) {
+	if len(cmd.args) < 2 {
+		return ExitUsage, errors.New("usage: bastion user keys add USERNAME AUTHORIZED_KEY")
+	}
+
+	return cmd.addAuthorizedKey(ctx, cmd.args[0], cmd.args[1:])
+}
+
+//runUserKeysRemove removes an ingress key of a user.
+func runUserKeysRemove(ctx context.Context, cmd *command) (int, error) {
+	if len(cmd.args) != 2 {
+		return ExitUsage, errors.New("usage: bastion user keys remove USERNAME FINGERPRINT")
+	}
+
+	return cmd.removeAuthorizedKey(ctx, cmd.args[0], cmd.args[1])
+}
+
+//listAuthorizedKeys writes the list of the ingress keys of the user.
+func (cmd *command) listAuthorizedKeys(ctx context.Context, username string) (int, error) {
+	keys, err := cmd.env.DataStore.GetUserAuthorizedKeys(username)
+
+	if err != nil {
+		logger.WarnfWithCtxWithErr(ctx, err, "could not list the authorized keys of %v", username)
+		return ExitFailure, errors.New("could not list the authorized keys of " + username)
+	}
+
+	entries := []authorizedKeyEntry{}
+	var rows [][]string
+
+	for _, k := range keys {
+		e := authorizedKeyEntry{Fingerprint: k.Fingerprint(), Type: k.Key.Type(), Comment: k.Comment,
+			Options: k.Options}
+
+		if e.Options == nil {
+			e.Options = []string{}
+		}
+
+		entries = append(entries, e)
+		rows = append(rows, []string{e.Fingerprint, e.Type, e.Comment, strings.Join(e.Options, ",")})
+	}
+
+	cmd.print(entries, []string{"FINGERPRINT", "TYPE", "COMMENT", "OPTIONS"}, rows)
+
+	return ExitSuccess, nil
+}
+
+//addAuthorizedKey parses the arguments as an authorized_keys line and allows the key to log in as the user.
+func (cmd *command) addAuthorizedKey(ctx context.Context, username string, args []string) (int, error) {
+	key, err := datastore.ParseAuthorizedKey([]byte(strings.Join(args, " ")))
+
+	if err != nil {
+		return ExitUsage, err
+	}
+
+	//A key with options the bastion cannot enforce would be refused at login
+	if _, err := auth.ParseKeyOptions(key.Options); err != nil {
+		return ExitUsage, err
+	}
+
+	err = cmd.env.DataStore.AddUserAuthorizedKey(username, key)
+
+	if err != nil {
+		logger.WarnfWithCtxWithErr(ctx, err, "could not add an authorized key to %v", username)
+		return ExitFailure, errors.New("could not add the key to " + username + " : " + err.Error())
+	}
+
+	logger.InfofWithCtx(ctx, "authorized key %v added to %v", key.Fingerprint(), username)
+
+	cmd.printKeyResult(username, key.Fingerprint(), "added")
+
+	return ExitSuccess, nil
+}
+
+//removeAuthorizedKey removes an ingress key of the user. The key used by the current session cannot be removed so
+//that a client cannot lock itself out by mistake.
+func (cmd *command) removeAuthorizedKey(ctx context.Context, username string, fingerprint string) (int, error) {
+	if username == cmd.client.User && fingerprint == cmd.client.GetIngressKeyFingerprint() {
+		return ExitFailure, errors.New("you cannot remove the key used by the current session")
+	}
+
+	err := cmd.env.DataStore.RemoveUserAuthorizedKey(username, fingerprint)
+
+	if err != nil {
+		logger.WarnfWithCtxWithErr(ctx, err, "could not remove an authorized key of %v", username)
+		return ExitFailure, errors.New("could not remove the key of " + username + " : " + err.Error())
+	}
+
+	logger.InfofWithCtx(ctx, "authorized key %v removed from %v", fingerprint, username)
+
+	cmd.printKeyResult(username, fingerprint, "removed")
+
+	return ExitSuccess, nil
+}
+
+//printKeyResult writes the outcome of a key command.
+func (cmd *command) printKeyResult(username string, fingerprint string, result string) {
+	out := struct {
+		User        string `json:"user"`
+		Fingerprint string `json:"fingerprint"`
+		Result      string `json:"result"`
+	}{
+		User:        username,
+		Fingerprint: fingerprint,
+		Result:      result,
+	}
+
+	cmd.print(out, nil, [][]string{{"key " + fingerprint + " of " + username + " " + result}})
+}
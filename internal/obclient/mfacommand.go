@@ -0,0 +1,150 @@
+package obclient
+
+import (
+	"context"
+	"errors"
+	"github.com/open-bastion/open-bastion/internal/auth"
+	"github.com/open-bastion/open-bastion/internal/logger"
+	"rsc.io/qr"
+	"strings"
+)
+
+//qrQuietZone is the number of light modules around the QR codes
+const qrQuietZone = 2
+
+//renderQRCode draws the QR code of the text with half block characters, two modules rows per line. The light modules
+//are drawn, so the code reads on the dark background of the terminals.
+func renderQRCode(text string) ([]string, error) {
+	code, err := qr.Encode(text, qr.M)
+
+	if err != nil {
+		return nil, err
+	}
+
+	light := func(x, y int) bool {
+		if x < 0 || y < 0 || x >= code.Size || y >= code.Size {
+			return true
+		}
+
+		return !code.Black(x, y)
+	}
+
+	var lines []string
+
+	for y := -qrQuietZone; y < code.Size+qrQuietZone; y += 2 {
+		var line strings.Builder
+
+		for x := -qrQuietZone; x < code.Size+qrQuietZone; x++ {
+			top, bottom := light(x, y), light(x, y+1)
+
+			switch {
+			case top && bottom:
+				line.WriteString("█")
+			case top:
+				line.WriteString("▀")
+			case bottom:
+				line.WriteString("▄")
+			default:
+				line.WriteString(" ")
+			}
+		}
+
+		lines = append(lines, line.String())
+	}
+
+	return lines, nil
+}
+
+//runMFAEnroll generates a TOTP secret for the client, displays its otpauth URI and QR code, and enables it once the
+//client confirms it with a valid code read from the standard input.
+func runMFAEnroll(ctx context.Context, cmd *command) (int, error) {
+	if len(cmd.args) != 0 {
+		return ExitUsage, errors.New("usage: bastion mfa enroll")
+	}
+
+	username := cmd.client.User
+
+	current, err := cmd.env.DataStore.GetUserTOTPSecret(username)
+
+	if err != nil {
+		logger.WarnfWithCtxWithErr(ctx, err, "could not get the TOTP secret of %v", username)
+		return ExitFailure, errors.New("could not get your second factor")
+	}
+
+	if current != "" {
+		return ExitFailure, errors.New("a second factor is already enrolled, ask an administrator to reset it")
+	}
+
+	secret, err := auth.NewTOTPSecret()
+
+	if err != nil {
+		logger.WarnWithCtxWithErr(ctx, err, "could not generate a TOTP secret")
+		return ExitFailure, errors.New("could not generate a secret")
+	}
+
+	uri := auth.TOTPURI(cmd.env.Auth.MFAIssuer, username, secret)
+	qrCode, err := renderQRCode(uri)
+
+	if err != nil {
+		logger.WarnWithCtxWithErr(ctx, err, "could not render the QR code")
+		return ExitFailure, errors.New("could not render the QR code")
+	}
+
+	out := struct {
+		User string `json:"user"`
+		URI  string `json:"uri"`
+	}{
+		User: username,
+		URI:  uri,
+	}
+
+	rows := [][]string{{uri}, {""}}
+
+	for _, l := range qrCode {
+		rows = append(rows, []string{l})
+	}
+
+	cmd.print(out, nil, rows)
+
+	code, err := cmd.readSecret("verification code: ")
+
+	if err != nil {
+		logger.WarnWithCtxWithErr(ctx, err, "could not read the verification code")
+		return ExitFailure, errors.New("could not read the verification code")
+	}
+
+	err = cmd.env.Auth.EnrollTOTP(username, secret, code)
+
+	if err != nil {
+		logger.WarnfWithCtxWithErr(ctx, err, "could not enroll the second factor of %v", username)
+		return ExitFailure, errors.New("could not enroll the second factor : " + err.Error())
+	}
+
+	logger.InfofWithCtx(ctx, "second factor of %v enrolled", username)
+
+	cmd.printUserResult(username, "second factor enrolled")
+
+	return ExitSuccess, nil
+}
+
+//runUserMFAReset removes the second factor of a user, who can then enroll again.
+func runUserMFAReset(ctx context.Context, cmd *command) (int, error) {
+	username, err := userCommandTarget(cmd, "user mfa reset USERNAME")
+
+	if err != nil {
+		return ExitUsage, err
+	}
+
+	err = cmd.env.DataStore.SetUserTOTPSecret(username, "")
+
+	if err != nil {
+		logger.WarnfWithCtxWithErr(ctx, err, "could not reset the second factor of %v", username)
+		return ExitFailure, errors.New("could not reset the second factor of " + username + " : " + err.Error())
+	}
+
+	logger.InfofWithCtx(ctx, "second factor of %v reset", username)
+
+	cmd.printUserResult(username, "second factor reset")
+
+	return ExitSuccess, nil
+}
@@ -0,0 +1,114 @@
+package obclient
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"github.com/open-bastion/open-bastion/internal/logger"
+	"io"
+	"strings"
+)
+
+//maxSecretInput is the maximum length of the line containing a password or a verification code
+const maxSecretInput = 1024
+
+//readSecret prompts on the standard error and reads the first line of the standard input of the client. The secrets
+//are never passed as argument, the commands are logged.
+func (cmd *command) readSecret(prompt string) (string, error) {
+	_, _ = io.WriteString(cmd.client.SshCommChan.Stderr(), prompt)
+
+	line, err := bufio.NewReader(io.LimitReader(cmd.client.SshCommChan, maxSecretInput)).ReadString('\n')
+
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	if err == io.EOF && len(line) == maxSecretInput {
+		return "", errors.New("the input is too long")
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+//runPasswordSet sets the password of the client.
+func runPasswordSet(ctx context.Context, cmd *command) (int, error) {
+	if len(cmd.args) != 0 {
+		return ExitUsage, errors.New("usage: bastion password set")
+	}
+
+	return cmd.setPassword(ctx, cmd.client.User)
+}
+
+//runPasswordDisable disables the password login of the client.
+func runPasswordDisable(ctx context.Context, cmd *command) (int, error) {
+	if len(cmd.args) != 0 {
+		return ExitUsage, errors.New("usage: bastion password disable")
+	}
+
+	return cmd.disablePassword(ctx, cmd.client.User)
+}
+
+//runUserPasswordSet sets the password of a user.
+func runUserPasswordSet(ctx context.Context, cmd *command) (int, error) {
+	username, err := userCommandTarget(cmd, "user password set USERNAME")
+
+	if err != nil {
+		return ExitUsage, err
+	}
+
+	return cmd.setPassword(ctx, username)
+}
+
+//runUserPasswordDisable disables the password login of a user.
+func runUserPasswordDisable(ctx context.Context, cmd *command) (int, error) {
+	username, err := userCommandTarget(cmd, "user password disable USERNAME")
+
+	if err != nil {
+		return ExitUsage, err
+	}
+
+	return cmd.disablePassword(ctx, username)
+}
+
+//setPassword reads a password on the standard input and enables the password login of the user with it.
+func (cmd *command) setPassword(ctx context.Context, username string) (int, error) {
+	if _, err := cmd.env.DataStore.GetUserStatus(username); err != nil {
+		return ExitFailure, errors.New("unknown user " + username)
+	}
+
+	password, err := cmd.readSecret("password: ")
+
+	if err != nil {
+		logger.WarnWithCtxWithErr(ctx, err, "could not read the password")
+		return ExitFailure, errors.New("could not read the password")
+	}
+
+	err = cmd.env.Auth.SetUserPassword(username, password)
+
+	if err != nil {
+		logger.WarnfWithCtxWithErr(ctx, err, "could not set the password of %v", username)
+		return ExitFailure, errors.New("could not set the password of " + username + " : " + err.Error())
+	}
+
+	logger.InfofWithCtx(ctx, "password of %v set", username)
+
+	cmd.printUserResult(username, "password set")
+
+	return ExitSuccess, nil
+}
+
+//disablePassword removes the password of the user.
+func (cmd *command) disablePassword(ctx context.Context, username string) (int, error) {
+	err := cmd.env.DataStore.SetUserPasswordHash(username, "")
+
+	if err != nil {
+		logger.WarnfWithCtxWithErr(ctx, err, "could not disable the password of %v", username)
+		return ExitFailure, errors.New("could not disable the password of " + username + " : " + err.Error())
+	}
+
+	logger.InfofWithCtx(ctx, "password of %v disabled", username)
+
+	cmd.printUserResult(username, "password disabled")
+
+	return ExitSuccess, nil
+}
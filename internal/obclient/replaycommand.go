@@ -0,0 +1,343 @@
+package obclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/open-bastion/open-bastion/internal/logger"
+	"github.com/open-bastion/open-bastion/internal/recording"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+//seekStep is how far in a recording the seek keys move, in seconds of the recording
+const seekStep = 5.0
+
+//terminalReset resets the client terminal before a recording is redrawn from its start
+const terminalReset = "\x1bc"
+
+//replayHelp describes the replay keys
+const replayHelp = "space: pause/resume, left/right arrows: seek 5s backward/forward, q: quit"
+
+//replayAction is an action of the client during a replay
+type replayAction int
+
+const (
+	replayPause replayAction = iota
+	replayBackward
+	replayForward
+	replayQuit
+)
+
+//recordingEntry is the output format of a recording
+type recordingEntry struct {
+	Session string    `json:"session"`
+	User    string    `json:"user"`
+	Backend string    `json:"backend"`
+	Start   time.Time `json:"start"`
+	Width   int       `json:"width"`
+	Height  int       `json:"height"`
+}
+
+//isAdmin returns whether the client is an administrator, the commands filtering their output by user use it.
+func (cmd *command) isAdmin(ctx context.Context) (bool, error) {
+	admin, err := cmd.env.DataStore.IsUserAdmin(cmd.client.User)
+
+	if err != nil {
+		logger.ErrorWithCtxWithErr(ctx, err, "could not read the user permissions")
+		return false, errors.New("could not verify permissions")
+	}
+
+	return admin, nil
+}
+
+//runRecordingsList lists the recorded sessions of the client, or every recorded session for an administrator.
+func runRecordingsList(ctx context.Context, cmd *command) (int, error) {
+	if len(cmd.args) != 0 {
+		return ExitUsage, errors.New("usage: bastion recordings list")
+	}
+
+	admin, err := cmd.isAdmin(ctx)
+
+	if err != nil {
+		return ExitFailure, err
+	}
+
+	headers, err := recording.List(cmd.env.RecordingsDir)
+
+	if err != nil {
+		logger.ErrorWithCtxWithErr(ctx, err, "could not list the recordings")
+		return ExitFailure, errors.New("could not list the recordings")
+	}
+
+	entries := []recordingEntry{}
+	var rows [][]string
+
+	for _, h := range headers {
+		if !admin && h.User != cmd.client.User {
+			continue
+		}
+
+		e := recordingEntry{Session: h.Session, User: h.User, Backend: h.Backend, Start: time.Unix(h.Timestamp, 0),
+			Width: h.Width, Height: h.Height}
+		entries = append(entries, e)
+		rows = append(rows, []string{e.Session, e.User, e.Backend, e.Start.Format(time.RFC3339),
+			fmt.Sprintf("%vx%v", e.Width, e.Height)})
+	}
+
+	cmd.print(entries, []string{"SESSION", "USER", "BACKEND", "START", "SIZE"}, rows)
+
+	return ExitSuccess, nil
+}
+
+//runReplay streams a recorded session to the client terminal at its original speed or at --speed times it. The
+//client pauses, seeks and quits with keys. The users can only replay their own sessions, except the administrators.
+func runReplay(ctx context.Context, cmd *command) (int, error) {
+	usage := errors.New("usage: bastion replay SESSION_ID [--speed FACTOR]")
+	args, options, err := parseOptions(cmd.args, "speed")
+
+	if err != nil {
+		return ExitUsage, err
+	}
+
+	if len(args) != 1 {
+		return ExitUsage, usage
+	}
+
+	speed := 1.0
+
+	if options["speed"] != "" {
+		speed, err = strconv.ParseFloat(options["speed"], 64)
+
+		if err != nil || speed <= 0 || speed > 1000 {
+			return ExitUsage, errors.New("the speed must be a factor between 0 and 1000")
+		}
+	}
+
+	admin, err := cmd.isAdmin(ctx)
+
+	if err != nil {
+		return ExitFailure, err
+	}
+
+	session := args[0]
+	header, events, err := recording.ReadFile(recording.Path(cmd.env.RecordingsDir, session))
+
+	//The sessions of the other users are not disclosed
+	if os.IsNotExist(err) || (err == nil && !admin && header.User != cmd.client.User) {
+		return ExitFailure, errors.New("no recording of session " + session)
+	}
+
+	if err != nil {
+		logger.ErrorWithCtxWithErr(ctx, err, "could not read the recording")
+		return ExitFailure, errors.New("could not read the recording of session " + session)
+	}
+
+	logger.AuditfWithCtx(ctx, logger.AuditSession, "recording of session %v of %v on %v replayed", session,
+		header.User, header.Backend)
+
+	stderr := cmd.client.SshCommChan.Stderr()
+
+	_, _ = fmt.Fprintf(stderr, "session %v of %v on %v, recorded on %v in a %vx%v terminal\r\n%v\r\n", session,
+		header.User, header.Backend, time.Unix(header.Timestamp, 0).Format(time.RFC3339), header.Width,
+		header.Height, replayHelp)
+
+	actions := make(chan replayAction)
+	done := make(chan struct{})
+	defer close(done)
+
+	go readReplayKeys(cmd.client.SshCommChan, actions, done)
+
+	p := newReplayer(cmd.client.SshCommChan, events, speed)
+
+	if err := p.play(ctx, actions); err != nil {
+		return ExitFailure, errors.New("the replay was interrupted : " + err.Error())
+	}
+
+	_, _ = fmt.Fprint(stderr, "\r\nend of the replay\r\n")
+
+	return ExitSuccess, nil
+}
+
+//readReplayKeys reads the keys of the client and sends their actions until the client input ends, actions is
+//then closed, or done is closed.
+func readReplayKeys(r io.Reader, actions chan<- replayAction, done <-chan struct{}) {
+	defer close(actions)
+
+	buf := make([]byte, 256)
+	escape := 0
+
+	for {
+		n, err := r.Read(buf)
+
+		for _, b := range buf[:n] {
+			action := replayAction(-1)
+
+			//The arrows are the ESC [ C and ESC [ D sequences
+			switch {
+			case escape == 1 && b == '[':
+				escape = 2
+				continue
+			case escape == 2 && b == 'C':
+				action = replayForward
+			case escape == 2 && b == 'D':
+				action = replayBackward
+			case b == 0x1b:
+				escape = 1
+				continue
+			case b == ' ':
+				action = replayPause
+			case b == 'q' || b == 0x03 || b == 0x04:
+				action = replayQuit
+			}
+
+			escape = 0
+
+			if action < 0 {
+				continue
+			}
+
+			select {
+			case actions <- action:
+			case <-done:
+				return
+			}
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+//replayer writes the output events of a recording to a terminal
+type replayer struct {
+	w      io.Writer
+	events []recording.Event
+	speed  float64
+
+	//next is the index of the next event to write and pos the time reached in the recording
+	next int
+	pos  float64
+}
+
+//newReplayer returns a replayer of the output events, at speed times their original speed
+func newReplayer(w io.Writer, events []recording.Event, speed float64) *replayer {
+	p := &replayer{w: w, speed: speed}
+
+	for _, e := range events {
+		if e.Kind == recording.Output {
+			p.events = append(p.events, e)
+		}
+	}
+
+	return p
+}
+
+//play writes the events at their time until the end of the recording, the client quits or ctx is done
+func (p *replayer) play(ctx context.Context, actions <-chan replayAction) error {
+	paused := false
+
+	for p.next < len(p.events) {
+		var timer *time.Timer
+		var fire <-chan time.Time
+
+		if !paused {
+			wait := time.Duration((p.events[p.next].Time - p.pos) / p.speed * float64(time.Second))
+			timer = time.NewTimer(wait)
+			fire = timer.C
+		}
+
+		started := time.Now()
+
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+
+			return ctx.Err()
+		case <-fire:
+			if err := p.write(); err != nil {
+				return err
+			}
+		case action, ok := <-actions:
+			if timer != nil {
+				timer.Stop()
+				p.pos += time.Since(started).Seconds() * p.speed
+
+				if p.pos > p.events[p.next].Time {
+					p.pos = p.events[p.next].Time
+				}
+			}
+
+			//The replay goes on without the keys if the client input ends, the client disconnecting ends it too
+			if !ok {
+				actions = nil
+				paused = false
+				continue
+			}
+
+			var err error
+
+			switch action {
+			case replayPause:
+				paused = !paused
+			case replayBackward:
+				err = p.seek(p.pos - seekStep)
+			case replayForward:
+				err = p.seek(p.pos + seekStep)
+			case replayQuit:
+				return nil
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+//write writes the next event and moves to its time
+func (p *replayer) write() error {
+	e := p.events[p.next]
+
+	if _, err := io.WriteString(p.w, e.Data); err != nil {
+		return err
+	}
+
+	p.next++
+	p.pos = e.Time
+
+	return nil
+}
+
+//seek moves to the time t of the recording. The events up to t are written at once, the terminal is reset and the
+//recording redrawn from its start to go backward.
+func (p *replayer) seek(t float64) error {
+	if t < 0 {
+		t = 0
+	}
+
+	if t < p.pos {
+		if _, err := io.WriteString(p.w, terminalReset); err != nil {
+			return err
+		}
+
+		p.next = 0
+	}
+
+	for p.next < len(p.events) && p.events[p.next].Time <= t {
+		if err := p.write(); err != nil {
+			return err
+		}
+	}
+
+	p.pos = t
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package obclient
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/open-bastion/open-bastion/internal/recording"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayer_Seek(t *testing.T) {
+	events := []recording.Event{
+		{Time: 1, Kind: recording.Output, Data: "a"},
+		{Time: 2, Kind: recording.Input, Data: "typed"},
+		{Time: 7, Kind: recording.Output, Data: "b"},
+		{Time: 13, Kind: recording.Output, Data: "c"},
+	}
+
+	tests := []struct {
+		name  string
+		seeks []float64
+		want  string
+	}{
+		{name: "test forward", seeks: []float64{8}, want: "ab"},
+		{name: "test forward twice", seeks: []float64{5, 10}, want: "ab"},
+		{name: "test backward", seeks: []float64{8, 3}, want: "ab" + terminalReset + "a"},
+		{name: "test before the start", seeks: []float64{8, -5}, want: "ab" + terminalReset},
+		{name: "test after the end", seeks: []float64{20}, want: "abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+
+			p := newReplayer(&out, events, 1)
+
+			for _, s := range tt.seeks {
+				assert.Nil(t, p.seek(s))
+			}
+
+			assert.Equal(t, tt.want, out.String())
+		})
+	}
+}
+
+func TestReplayer_Play(t *testing.T) {
+	events := []recording.Event{
+		{Time: 0.5, Kind: recording.Output, Data: "a"},
+		{Time: 1, Kind: recording.Output, Data: "b"},
+		{Time: 20, Kind: recording.Output, Data: "c"},
+	}
+
+	var out bytes.Buffer
+
+	//The replay goes on to the end once the client input is closed
+	actions := make(chan replayAction)
+	done := make(chan struct{})
+	defer close(done)
+
+	go readReplayKeys(strings.NewReader(""), actions, done)
+
+	err := newReplayer(&out, events, 100).play(context.Background(), actions)
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", out.String())
+}
+
+func TestReadReplayKeys(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []replayAction
+	}{
+		{name: "test pause", input: "  ", want: []replayAction{replayPause, replayPause}},
+		{name: "test arrows", input: "\x1b[C\x1b[D", want: []replayAction{replayForward, replayBackward}},
+		{name: "test quit", input: "q\x03", want: []replayAction{replayQuit, replayQuit}},
+		{name: "test other keys", input: "x\x1b[A\x1bC", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actions := make(chan replayAction)
+			done := make(chan struct{})
+			defer close(done)
+
+			go readReplayKeys(strings.NewReader(tt.input), actions, done)
+
+			var got []replayAction
+
+			for a := range actions {
+				got = append(got, a)
+			}
+
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
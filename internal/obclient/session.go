@@ -0,0 +1,87 @@
+package obclient
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Session describes a client connected to the bastion
+type Session struct {
+	ID      string    `json:"id"`
+	User    string    `json:"user"`
+	IP      string    `json:"ip"`
+	Command string    `json:"command"`
+	Start   time.Time `json:"start"`
+}
+
+// SessionRegistry keeps track of the active sessions. It is safe for concurrent use.
+type SessionRegistry struct {
+	mu       sync.Mutex
+	sessions map[string]Session
+}
+
+//NewSessionRegistry returns an empty SessionRegistry.
+func NewSessionRegistry() *SessionRegistry {
+	return &SessionRegistry{sessions: make(map[string]Session)}
+}
+
+//Add registers an active session.
+func (r *SessionRegistry) Add(s Session) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sessions[s.ID] = s
+}
+
+//Remove unregisters a session, it does nothing if the session is unknown.
+func (r *SessionRegistry) Remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.sessions, id)
+}
+
+//List returns the active sessions ordered by start time.
+func (r *SessionRegistry) List() []Session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	list := make([]Session, 0, len(r.sessions))
+
+	for _, s := range r.sessions {
+		list = append(list, s)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Start.Before(list[j].Start)
+	})
+
+	return list
+}
+
+//NewSessionID returns a random session identifier.
+func NewSessionID() string {
+	b := make([]byte, 8)
+
+	//crypto/rand only fails if the system source is unavailable, the timestamp is a good enough fallback
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+//Session returns the description of the client session.
+func (client *Client) Session() Session {
+	return Session{
+		ID:      client.SessionID,
+		User:    client.User,
+		IP:      client.GetIp(),
+		Command: string(client.RawCommand),
+		Start:   client.StartTime,
+	}
+}
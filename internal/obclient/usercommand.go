@@ -0,0 +1,224 @@
+package obclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/open-bastion/open-bastion/internal/datastore"
+	"github.com/open-bastion/open-bastion/internal/logger"
+	"strconv"
+	"strings"
+)
+
+//parseOptions splits the arguments into positional arguments and --options. The options listed in withValue take
+//the next argument as value, the other ones are boolean and set to "true".
+func parseOptions(args []string, withValue ...string) ([]string, map[string]string, error) {
+	var positional []string
+	options := make(map[string]string)
+
+	for i := 0; i < len(args); i++ {
+		if !strings.HasPrefix(args[i], "--") {
+			positional = append(positional, args[i])
+			continue
+		}
+
+		name := strings.TrimPrefix(args[i], "--")
+		options[name] = "true"
+
+		for _, v := range withValue {
+			if v != name {
+				continue
+			}
+
+			if i+1 >= len(args) {
+				return nil, nil, errors.New("missing value for option --" + name)
+			}
+
+			options[name] = args[i+1]
+			i++
+		}
+	}
+
+	return positional, options, nil
+}
+
+//userCommandTarget returns the single username argument of a user command.
+func userCommandTarget(cmd *command, usage string) (string, error) {
+	args, _, err := parseOptions(cmd.args)
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(args) != 1 {
+		return "", errors.New("usage: bastion " + usage)
+	}
+
+	return args[0], nil
+}
+
+//runUserAdd creates a user, its egress key and displays the egress public key. The key type and size default to the
+//ones of the egress key policy.
+func runUserAdd(ctx context.Context, cmd *command) (int, error) {
+	usage := errors.New("usage: bastion user add USERNAME [--key-type TYPE] [--key-size BITS] [--admin]")
+	args, options, err := parseOptions(cmd.args, "key-type", "key-size")
+
+	if err != nil {
+		return ExitUsage, err
+	}
+
+	if len(args) != 1 {
+		return ExitUsage, usage
+	}
+
+	username := args[0]
+	bits := 0
+
+	if options["key-size"] != "" {
+		bits, err = strconv.Atoi(options["key-size"])
+
+		if err != nil || bits <= 0 {
+			return ExitUsage, usage
+		}
+	}
+
+	ds := cmd.env.DataStore
+
+	//The key type and size are validated against the key policy by the data store
+	err = ds.AddUser(username, options["key-type"], bits)
+
+	if err != nil {
+		logger.WarnfWithCtxWithErr(ctx, err, "could not create user %v", username)
+		return ExitFailure, errors.New("could not create user " + username + " : " + err.Error())
+	}
+
+	if options["admin"] != "" {
+		err = ds.SetUserAdmin(username, true)
+
+		if err != nil {
+			logger.ErrorfWithCtxWithErr(ctx, err, "could not grant the administrator rights to %v", username)
+			return ExitFailure, errors.New("user " + username + " created but could not be promoted")
+		}
+	}
+
+	pubKey, err := ds.GetRawUserEgressPublicKey(username)
+
+	if err != nil {
+		logger.ErrorfWithCtxWithErr(ctx, err, "could not read the egress public key of %v", username)
+		return ExitFailure, errors.New("user " + username + " created but its egress public key could not be read")
+	}
+
+	out := struct {
+		User            string `json:"user"`
+		Admin           bool   `json:"admin"`
+		EgressPublicKey string `json:"egressPublicKey"`
+	}{
+		User:            username,
+		Admin:           options["admin"] != "",
+		EgressPublicKey: strings.TrimSpace(string(pubKey)),
+	}
+
+	if cmd.json {
+		cmd.print(out, nil, nil)
+	} else {
+		_, _ = fmt.Fprintf(cmd.client.SshCommChan, "user %v created, egress public key:\n%v\n", username,
+			out.EgressPublicKey)
+	}
+
+	return ExitSuccess, nil
+}
+
+//runUserDelete deletes a user and its keys. Administrators cannot delete their own account.
+func runUserDelete(ctx context.Context, cmd *command) (int, error) {
+	username, err := userCommandTarget(cmd, "user delete USERNAME")
+
+	if err != nil {
+		return ExitUsage, err
+	}
+
+	if username == cmd.client.User {
+		return ExitFailure, errors.New("you cannot delete your own account")
+	}
+
+	if _, err := cmd.env.DataStore.GetUserStatus(username); err != nil {
+		return ExitFailure, errors.New("unknown user " + username)
+	}
+
+	err = cmd.env.DataStore.DeleteUser(username)
+
+	if err != nil {
+		logger.ErrorfWithCtxWithErr(ctx, err, "could not delete user %v", username)
+		return ExitFailure, errors.New("could not delete user " + username)
+	}
+
+	cmd.printUserResult(username, "deleted")
+
+	return ExitSuccess, nil
+}
+
+//runUserActivate allows a user to log in.
+func runUserActivate(ctx context.Context, cmd *command) (int, error) {
+	return cmd.setUserStatus(ctx, "user activate USERNAME", datastore.Active, "activated")
+}
+
+//runUserDeactivate prevents a user from logging in. Administrators cannot deactivate their own account.
+func runUserDeactivate(ctx context.Context, cmd *command) (int, error) {
+	return cmd.setUserStatus(ctx, "user deactivate USERNAME", datastore.Inactive, "deactivated")
+}
+
+//setUserStatus sets the status of the user passed as argument.
+func (cmd *command) setUserStatus(ctx context.Context, usage string, status int, result string) (int, error) {
+	username, err := userCommandTarget(cmd, usage)
+
+	if err != nil {
+		return ExitUsage, err
+	}
+
+	if username == cmd.client.User && status != datastore.Active {
+		return ExitFailure, errors.New("you cannot deactivate your own account")
+	}
+
+	err = cmd.env.DataStore.SetUserStatus(username, status)
+
+	if err != nil {
+		logger.WarnfWithCtxWithErr(ctx, err, "could not set the status of user %v", username)
+		return ExitFailure, errors.New("could not update user " + username + " : " + err.Error())
+	}
+
+	cmd.printUserResult(username, result)
+
+	return ExitSuccess, nil
+}
+
+//runUserPromote grants the administrator rights to a user.
+func runUserPromote(ctx context.Context, cmd *command) (int, error) {
+	username, err := userCommandTarget(cmd, "user promote USERNAME")
+
+	if err != nil {
+		return ExitUsage, err
+	}
+
+	err = cmd.env.DataStore.SetUserAdmin(username, true)
+
+	if err != nil {
+		logger.WarnfWithCtxWithErr(ctx, err, "could not promote user %v", username)
+		return ExitFailure, errors.New("could not promote user " + username + " : " + err.Error())
+	}
+
+	cmd.printUserResult(username, "promoted")
+
+	return ExitSuccess, nil
+}
+
+//printUserResult writes the outcome of a user command.
+func (cmd *command) printUserResult(username string, result string) {
+	out := struct {
+		User   string `json:"user"`
+		Result string `json:"result"`
+	}{
+		User:   username,
+		Result: result,
+	}
+
+	cmd.print(out, nil, [][]string{{"user " + username + " " + result}})
+}
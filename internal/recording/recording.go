@@ -0,0 +1,318 @@
+package recording
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Kinds of the recorded events
+const (
+	Output = "o"
+	Input  = "i"
+)
+
+// FileExtension is the extension of the recording files, named after their session
+const FileExtension = ".cast"
+
+//maxLine is the maximum length of a recording line, an event of a 32kB read is at most 6 times longer once escaped
+const maxLine = 1024 * 1024
+
+// ErrClosed is returned by the writes to a closed recording
+var ErrClosed = errors.New("the recording is closed")
+
+// Header is the first line of an asciicast v2 file, see https://docs.asciinema.org/manual/asciicast/v2/. The
+// bastion user, session and backend are added to the standard fields, the players ignore them.
+type Header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+	User      string            `json:"user"`
+	Session   string            `json:"session"`
+	Backend   string            `json:"backend"`
+}
+
+// Recording writes the terminal I/O of a session to an asciicast v2 file, each event with its time since the start
+// of the session. It is safe for concurrent use.
+type Recording struct {
+	Path string
+
+	mu      sync.Mutex
+	file    *os.File
+	start   time.Time
+	pending map[string][]byte
+}
+
+// Path returns the path of the recording of a session in dir
+func Path(dir string, session string) string {
+	return filepath.Join(dir, filepath.Base(session)+FileExtension)
+}
+
+// Create creates the recording of the session of the header in dir and writes the header. The start time of the
+// header is the current time. An existing recording is never overwritten.
+func Create(dir string, h Header) (*Recording, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.New("could not create the recordings directory : " + err.Error())
+	}
+
+	r := &Recording{Path: Path(dir, h.Session), start: time.Now(), pending: map[string][]byte{}}
+
+	file, err := os.OpenFile(r.Path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+
+	if err != nil {
+		return nil, errors.New("could not create the recording : " + err.Error())
+	}
+
+	h.Version = 2
+	h.Timestamp = r.start.Unix()
+
+	line, err := json.Marshal(h)
+
+	if err == nil {
+		_, err = file.Write(append(line, '\n'))
+	}
+
+	if err != nil {
+		_ = file.Close()
+		return nil, errors.New("could not write the recording header : " + err.Error())
+	}
+
+	r.file = file
+
+	return r, nil
+}
+
+// Write records data of a kind of event. A multi-byte character split between two writes is recorded with the
+// second one, the asciicast events being UTF-8 strings.
+func (r *Recording) Write(kind string, data []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return ErrClosed
+	}
+
+	data = append(r.pending[kind], data...)
+	complete := completeRunes(data)
+	r.pending[kind] = append([]byte(nil), data[complete:]...)
+
+	if complete == 0 {
+		return nil
+	}
+
+	line, err := json.Marshal([]interface{}{time.Since(r.start).Seconds(), kind, string(data[:complete])})
+
+	if err != nil {
+		return err
+	}
+
+	_, err = r.file.Write(append(line, '\n'))
+
+	return err
+}
+
+// Record records the data received on log as a kind of event until log is closed. The data is still received after
+// an error so that the session goes on, the first error is returned.
+func (r *Recording) Record(kind string, log <-chan []byte) error {
+	var err error
+
+	for data := range log {
+		if err == nil {
+			err = r.Write(kind, data)
+		}
+	}
+
+	return err
+}
+
+// Close closes the recording file, the data received afterwards is not recorded
+func (r *Recording) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+
+	err := r.file.Close()
+	r.file = nil
+
+	return err
+}
+
+//completeRunes returns the length of data without its last character if it is incomplete
+func completeRunes(data []byte) int {
+	//A character is at most utf8.UTFMax bytes long, look for the start of the last one
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				return i
+			}
+
+			break
+		}
+	}
+
+	return len(data)
+}
+
+// Event is an event of a recording, Time is its time in seconds since the start of the session
+type Event struct {
+	Time float64
+	Kind string
+	Data string
+}
+
+// Read reads a recording, its header and events. The events of a recording still being written are read up to the
+// last complete one.
+func Read(r io.Reader) (Header, []Event, error) {
+	var h Header
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLine)
+
+	if !scanner.Scan() {
+		if scanner.Err() != nil {
+			return h, nil, errors.New("could not read the recording header : " + scanner.Err().Error())
+		}
+
+		return h, nil, errors.New("the recording is empty")
+	}
+
+	if err := json.Unmarshal(scanner.Bytes(), &h); err != nil {
+		return h, nil, errors.New("could not parse the recording header : " + err.Error())
+	}
+
+	if h.Version != 2 {
+		return h, nil, errors.New("unsupported recording version " + strconv.Itoa(h.Version))
+	}
+
+	var events []Event
+
+	for scanner.Scan() {
+		var fields []interface{}
+
+		if err := json.Unmarshal(scanner.Bytes(), &fields); err != nil {
+			//The last line can be partially written
+			break
+		}
+
+		e, err := parseEvent(fields)
+
+		if err != nil {
+			return h, events, errors.New("invalid event " + strconv.Itoa(len(events)+1) + " : " + err.Error())
+		}
+
+		events = append(events, e)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return h, events, errors.New("could not read the recording : " + err.Error())
+	}
+
+	return h, events, nil
+}
+
+//parseEvent returns the event of a [time, kind, data] line
+func parseEvent(fields []interface{}) (Event, error) {
+	if len(fields) != 3 {
+		return Event{}, errors.New("an event has 3 fields")
+	}
+
+	t, okTime := fields[0].(float64)
+	kind, okKind := fields[1].(string)
+	data, okData := fields[2].(string)
+
+	if !okTime || !okKind || !okData || t < 0 {
+		return Event{}, errors.New("an event is a time, a kind and data")
+	}
+
+	return Event{Time: t, Kind: kind, Data: data}, nil
+}
+
+// ReadFile reads the recording at path
+func ReadFile(path string) (Header, []Event, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return Header{}, nil, err
+	}
+
+	defer file.Close()
+
+	return Read(file)
+}
+
+// List returns the headers of the recordings in dir, the oldest first. The files which are not recordings are
+// ignored.
+func List(dir string) ([]Header, error) {
+	files, err := ioutil.ReadDir(dir)
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, errors.New("could not list the recordings : " + err.Error())
+	}
+
+	var headers []Header
+
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), FileExtension) {
+			continue
+		}
+
+		h, err := readHeader(filepath.Join(dir, f.Name()))
+
+		if err != nil {
+			continue
+		}
+
+		headers = append(headers, h)
+	}
+
+	sort.SliceStable(headers, func(i, j int) bool {
+		return headers[i].Timestamp < headers[j].Timestamp
+	})
+
+	return headers, nil
+}
+
+//readHeader reads the header of the recording at path only
+func readHeader(path string) (Header, error) {
+	var h Header
+
+	file, err := os.Open(path)
+
+	if err != nil {
+		return h, err
+	}
+
+	defer file.Close()
+
+	line, err := bufio.NewReader(io.LimitReader(file, maxLine)).ReadBytes('\n')
+
+	if err != nil {
+		return h, err
+	}
+
+	if err = json.Unmarshal(line, &h); err == nil && h.Version != 2 {
+		err = errors.New("unsupported recording version " + strconv.Itoa(h.Version))
+	}
+
+	return h, err
+}
@@ -0,0 +1,134 @@
+package recording
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//newTestRecordingsDir returns a temporary directory removed at the end of the test
+func newTestRecordingsDir(t *testing.T) string {
+	tempDir, err := ioutil.TempDir("", "open-bastion-testing")
+
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	t.Cleanup(func() { _ = os.RemoveAll(tempDir) })
+
+	return tempDir
+}
+
+func TestRecording(t *testing.T) {
+	dir := newTestRecordingsDir(t)
+
+	r, err := Create(dir, Header{Width: 120, Height: 30, User: "alice", Session: "0123456789abcdef",
+		Backend: "root@db1:22"})
+	assert.Nil(t, err)
+	assert.Equal(t, dir+"/0123456789abcdef.cast", r.Path)
+
+	output := make(chan []byte, 4)
+	output <- []byte("$ ")
+	//The euro sign is split between two reads
+	output <- []byte("ls \xe2\x82")
+	output <- []byte("\xac\r\n")
+	close(output)
+
+	assert.Nil(t, r.Record(Output, output))
+	assert.Nil(t, r.Write(Input, []byte("exit\r")))
+	assert.Nil(t, r.Close())
+
+	assert.Equal(t, ErrClosed, r.Write(Output, []byte("late")))
+
+	//An existing recording is not overwritten
+	_, err = Create(dir, Header{Session: "0123456789abcdef"})
+	assert.NotNil(t, err)
+
+	content, err := ioutil.ReadFile(r.Path)
+	assert.Nil(t, err)
+
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	assert.Len(t, lines, 5)
+
+	var h Header
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), &h))
+	assert.Equal(t, 2, h.Version)
+	assert.Equal(t, 120, h.Width)
+	assert.Equal(t, 30, h.Height)
+	assert.NotZero(t, h.Timestamp)
+	assert.Equal(t, "alice", h.User)
+	assert.Equal(t, "root@db1:22", h.Backend)
+
+	var events []string
+
+	for _, line := range lines[1:] {
+		var e []interface{}
+		assert.Nil(t, json.Unmarshal([]byte(line), &e))
+		assert.Len(t, e, 3)
+		assert.IsType(t, float64(0), e[0])
+
+		events = append(events, e[1].(string)+" "+e[2].(string))
+	}
+
+	assert.Equal(t, []string{"o $ ", "o ls ", "o €\r\n", "i exit\r"}, events)
+}
+
+func TestRead(t *testing.T) {
+	header := `{"version":2,"width":80,"height":24,"timestamp":1600000000,"user":"alice"}` + "\n"
+
+	tests := []struct {
+		name       string
+		content    string
+		wantErr    bool
+		wantEvents int
+	}{
+		{name: "test recording", content: header + `[0.5,"o","a"]` + "\n" + `[1.5,"i","b"]` + "\n", wantErr: false,
+			wantEvents: 2},
+		{name: "test recording in progress", content: header + `[0.5,"o","a"]` + "\n" + `[1.5,"o"`, wantErr: false,
+			wantEvents: 1},
+		{name: "test empty", content: "", wantErr: true},
+		{name: "test other version", content: `{"version":1}` + "\n", wantErr: true},
+		{name: "test invalid event", content: header + `[0.5,"o",1]` + "\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, events, err := Read(strings.NewReader(tt.content))
+
+			if tt.wantErr {
+				assert.NotNil(t, err)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, "alice", h.User)
+			assert.Len(t, events, tt.wantEvents)
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	dir := newTestRecordingsDir(t)
+
+	for _, s := range []string{"bbbb", "aaaa"} {
+		r, err := Create(dir, Header{User: "alice", Session: s})
+		assert.Nil(t, err)
+		assert.Nil(t, r.Close())
+	}
+
+	//The other files are ignored
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("notes"), 0600))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "broken.cast"), []byte("{"), 0600))
+
+	headers, err := List(dir)
+	assert.Nil(t, err)
+	assert.Len(t, headers, 2)
+
+	headers, err = List(filepath.Join(dir, "missing"))
+	assert.Nil(t, err)
+	assert.Len(t, headers, 0)
+}